@@ -1,12 +1,22 @@
 package avrosqlite
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/hamba/avro"
+	"github.com/mattn/go-sqlite3"
 )
 
 var (
@@ -16,6 +26,101 @@ var (
 	stringSchema  = avro.MustParse(`{"type": "string"}`)
 	bytesSchema   = avro.MustParse(`{"type": "bytes"}`)
 	booleanSchema = avro.MustParse(`{"type": "boolean"}`)
+
+	dateSchema            = avro.MustParse(`{"type": "int", "logicalType": "date"}`)
+	timestampMicrosSchema = avro.MustParse(`{"type": "long", "logicalType": "timestamp-micros"}`)
+
+	// anySchema holds the values of an ANY column, which can be of any storage class.
+	anySchema = avro.MustParse(`["null", "long", "double", "string", "bytes"]`)
+)
+
+// LoadOptions controls how LoadAvroWithOptions loads data into SQLite.
+type LoadOptions struct {
+	// BusyRetries is the number of times a statement is retried when SQLite
+	// reports the database as busy or locked. Zero disables retries.
+	BusyRetries int
+	// BusyBackoff is the delay before the first retry. It doubles after each attempt.
+	BusyBackoff time.Duration
+	// Fields restricts the load to the named columns of the schema, ignoring the
+	// other fields of each record. If the table does not exist it is created with
+	// only these columns. If empty, every column of the schema is loaded.
+	Fields []string
+	// WriterSchema is the Avro schema the data was written with. If nil, the data
+	// is decoded with the schema generated from the SqliteSchema. It allows loading
+	// data from other producers, for example with nullable unions ordered [T, "null"].
+	// Its fields must match the Avro fields of the SqliteSchema, once flattened if
+	// Flatten is set.
+	WriterSchema avro.Schema
+	// Mode selects how records are written to an existing table.
+	// The default, Truncate, replaces the rows of the table.
+	Mode LoadMode
+	// Flatten loads the fields of nested records of the WriterSchema into columns
+	// named after the path to the field joined by FlattenSeparator, for example
+	// the street of an address record into address_street. A nested record that
+	// is null leaves its columns NULL.
+	Flatten bool
+	// FlattenSeparator joins the names of nested fields when Flatten is set.
+	// If empty, "_" is used.
+	FlattenSeparator string
+	// FastInsert speeds up bulk loads by setting PRAGMA synchronous=OFF and
+	// PRAGMA journal_mode=MEMORY on the connection running the load, and restoring
+	// the previous settings once it completes. It trades durability for speed: if
+	// the process or the machine crashes during the load the database file can be
+	// corrupted, so only use it for databases that can be rebuilt. The journal mode
+	// of a database in WAL mode is left unchanged. It is ignored unless the load
+	// runs on a *sql.DB.
+	FastInsert bool
+	// Decompress, if set, returns a reader of the decompressed input, or the input
+	// itself to turn off detection. If nil, gzip input is detected from its first
+	// bytes and decompressed. zstd input is detected too, but only the caller can
+	// decompress it: without a Decompress function wrapping a zstd decoder, such
+	// as github.com/klauspost/compress/zstd, loading it fails with ErrZstdUnsupported.
+	Decompress func(io.Reader) (io.Reader, error)
+	// Logger receives warnings about the load, such as the generated columns whose
+	// values are ignored. If nil, they are discarded.
+	Logger *slog.Logger
+	// DisableTriggers keeps the triggers of an existing table from firing during
+	// the load, including the DELETE triggers when it is truncated: they are
+	// dropped before the load and created again from their recorded statements
+	// once it is done, whether it succeeded or not. When the load runs in a
+	// transaction, as it does on a *sql.DB, a failure rolls back the drops with
+	// the rest of the load, and other connections never see the table without
+	// its triggers. On a Querier outside of a transaction they are visible, and
+	// the triggers are lost if the process stops during the load.
+	DisableTriggers bool
+	// ExactFieldNames requires the fields of the records to have the Avro field
+	// names of the columns exactly. Otherwise a column without a field of its
+	// name is loaded from the field whose name differs only in case, such as
+	// Name for the column name, and a warning is logged.
+	ExactFieldNames bool
+	// StrictFields makes OCFToTableWithOptions fail when the OCF has fields with
+	// no column in the existing table, instead of dropping them.
+	StrictFields bool
+}
+
+// logger returns the Logger of the options, or a logger discarding messages.
+func (opts LoadOptions) logger() *slog.Logger {
+	return orDiscard(opts.Logger)
+}
+
+// flattenSeparator returns the separator of flattened column names.
+func (opts LoadOptions) flattenSeparator() string {
+	if opts.FlattenSeparator == "" {
+		return "_"
+	}
+	return opts.FlattenSeparator
+}
+
+// LoadMode selects how a load writes records to an existing table.
+type LoadMode int
+
+const (
+	// Truncate deletes the rows of an existing table before inserting the records.
+	Truncate LoadMode = iota
+	// Upsert keeps the rows of an existing table, inserting records with a new
+	// primary key and updating the rows of records whose primary key exists.
+	// The table must have a primary key.
+	Upsert
 )
 
 // LoadAvro loads Avro data into a SQLite database.
@@ -29,81 +134,533 @@ var (
 //   - int64: The number of records successfully inserted into the database.
 //   - error: An error if any occurred during the process, nil otherwise.
 //
-// If the specified table does not exist in the database, it will be created.
+// If the specified table does not exist in the database, it will be created
+// with the Sql of the schema, after checking that it creates the table with the
+// columns of the fields: otherwise an error wrapping ErrSqlMismatch is returned.
 // If the table already exists, it will be truncated before inserting new data.
+// Columns whose names are not valid Avro names are read from their sanitized
+// fields, see AvroOptions.SanitizeNames.
 func LoadAvro(db *sql.DB, schema *SqliteSchema, r io.Reader) (int64, error) {
-	avroSchema, err := schema.ToAvro()
+	return LoadAvroWithOptions(db, schema, r, LoadOptions{})
+}
+
+// ErrSchemaMismatch is returned by LoadAvroExpect when the schema of the table
+// differs from the expected Avro schema.
+var ErrSchemaMismatch = errors.New("schema does not match the expected schema")
+
+// LoadAvroExpect loads Avro data into a SQLite database after checking that the
+// schema of the table is the one expected.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - schema: A pointer to the SqliteSchema containing the table structure.
+//   - expected: The Avro schema the Avro form of schema must match.
+//   - r: An io.Reader providing the Avro data to be loaded.
+//
+// Returns:
+//   - int64: The number of records successfully inserted into the database.
+//   - error: An error wrapping ErrSchemaMismatch if the fingerprint of the Avro
+//     form of schema, as the data is decoded with, differs from that of expected,
+//     any other error that occurred, nil otherwise.
+//
+// The schemas are compared before anything is written, so a mismatch leaves the
+// table untouched. Otherwise the data is loaded as with LoadAvro.
+func LoadAvroExpect(db *sql.DB, schema *SqliteSchema, expected avro.Schema, r io.Reader) (int64, error) {
+	avroSchema, err := schema.ToAvroWithOptions(AvroOptions{SanitizeNames: true})
 	if err != nil {
 		return 0, err
 	}
-	decoder, err := avro.NewDecoder(avroSchema.String(), r)
+	got, want := avroSchema.Fingerprint(), expected.Fingerprint()
+	if got != want {
+		return 0, fmt.Errorf("%w: table %s has fingerprint %x, want %x", ErrSchemaMismatch, schema.Table, got, want)
+	}
+	return LoadAvro(db, schema, r)
+}
+
+// LoadAvroWithOptions loads Avro data into a SQLite database using the given options.
+//
+// Parameters:
+//   - q: The Querier used to run statements, usually a *sql.DB or *sql.Tx.
+//   - schema: A pointer to the SqliteSchema containing the table structure.
+//   - r: An io.Reader providing the Avro data to be loaded.
+//   - opts: The LoadOptions controlling the load.
+//
+// Returns:
+//   - int64: The number of records successfully inserted into the database.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// When q is a *sql.DB the whole load runs in a single transaction on one connection,
+// so a failed load leaves the table untouched. Statements failing with SQLITE_BUSY or
+// SQLITE_LOCKED, including the final COMMIT, are retried as configured in opts.
+func LoadAvroWithOptions(q Querier, schema *SqliteSchema, r io.Reader, opts LoadOptions) (int64, error) {
+	result, err := LoadAvroWithResult(q, schema, r, opts)
+	return result.Inserted, err
+}
+
+// LoadAvroResult describes the outcome of loading Avro data into a table.
+type LoadAvroResult struct {
+	// Created is true if the table did not exist and was created.
+	Created bool
+	// Truncated is true if the table existed and its rows were deleted.
+	Truncated bool
+	// Inserted is the number of records inserted.
+	Inserted int64
+	// Fingerprint is the fingerprint of the Avro schema used to decode the data.
+	Fingerprint [32]byte
+}
+
+// LoadAvroWithResult loads Avro data into a SQLite database and describes what was done.
+//
+// It behaves like LoadAvroWithOptions but returns a LoadAvroResult reporting
+// whether the table was created or truncated, the number of inserted records
+// and the fingerprint of the schema used. This is useful for idempotency checks
+// and logging in import pipelines.
+func LoadAvroWithResult(q Querier, schema *SqliteSchema, r io.Reader, opts LoadOptions) (LoadAvroResult, error) {
+	if len(schema.Fields) == 0 {
+		return LoadAvroResult{}, fmt.Errorf("%w: %q", ErrEmptySchema, schema.Table)
+	}
+	decoder, avroSchema, err := opts.recordDecoder(schema, r)
 	if err != nil {
-		return 0, err
+		return LoadAvroResult{}, err
 	}
 
-	// detect if the table exists
-	exists, err := tableExists(db, schema.Table)
+	result, err := loadRecords(q, schema, decoder, opts)
+	result.Fingerprint = avroSchema.Fingerprint()
+	return result, err
+}
+
+// recordDecoder returns the decoder of the records of schema read from r, and
+// the Avro schema they are decoded with.
+func (opts LoadOptions) recordDecoder(schema *SqliteSchema, r io.Reader) (recordDecoder, avro.Schema, error) {
+	r, err := decompress(r, opts.Decompress)
 	if err != nil {
-		return 0, err
+		return nil, nil, err
 	}
-	// create a table in the database
-	if !exists {
-		_, err := db.Exec(schema.Sql)
-		if err != nil {
-			return 0, err
+
+	// Avro data can only have been written with valid names, so columns are
+	// always matched to their sanitized field names.
+	avroSchema, err := schema.ToAvroWithOptions(AvroOptions{SanitizeNames: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.WriterSchema != nil {
+		avroSchema = opts.WriterSchema
+	}
+	avroDecoder, err := avro.NewDecoder(avroSchema.String(), r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !opts.Flatten {
+		return avroDecoder, avroSchema, nil
+	}
+	record, ok := avroSchema.(*avro.RecordSchema)
+	if !ok {
+		return nil, nil, fmt.Errorf("avro schema must be a record, got %s", avroSchema.Type())
+	}
+	return &flatteningDecoder{decoder: avroDecoder, schema: record, separator: opts.flattenSeparator()}, avroSchema, nil
+}
+
+// recordDecoder decodes a single Avro record per call, returning io.EOF
+// once the input is exhausted.
+type recordDecoder interface {
+	Decode(v any) error
+}
+
+// flatteningDecoder decodes records of schema and moves the fields of their nested
+// records to the top level, see LoadOptions.Flatten.
+type flatteningDecoder struct {
+	decoder   recordDecoder
+	schema    *avro.RecordSchema
+	separator string
+}
+
+func (d *flatteningDecoder) Decode(v any) error {
+	var record map[string]any
+	if err := d.decoder.Decode(&record); err != nil {
+		return err
+	}
+
+	out, ok := v.(*map[string]any)
+	if !ok {
+		return fmt.Errorf("cannot decode record into %T", v)
+	}
+	*out = map[string]any{}
+	flattenRecord(d.schema, record, "", d.separator, *out)
+	return nil
+}
+
+// flattenRecord adds the fields of record to out, prefixing their names with prefix.
+// The fields of nested records, or of nullable unions of records, are added recursively.
+func flattenRecord(schema *avro.RecordSchema, record map[string]any, prefix, separator string, out map[string]any) {
+	for _, field := range schema.Fields() {
+		name := prefix + field.Name()
+		v := record[field.Name()]
+
+		nested := nestedRecordSchema(field.Type())
+		if nested == nil {
+			out[name] = v
+			continue
 		}
-	} else {
-		_, err := db.Exec(fmt.Sprintf("DELETE FROM %s", schema.Table))
-		if err != nil {
-			return 0, err
+		// a record in a union is decoded as {"fullname": record}
+		if union, ok := field.Type().(*avro.UnionSchema); ok && union.Nullable() {
+			v = unionValue(v)
 		}
+		nestedRecord, _ := v.(map[string]any)
+		flattenRecord(nested, nestedRecord, name+separator, separator, out)
+	}
+}
+
+// nestedRecordSchema returns the record schema of a record or nullable union of a record, or nil.
+func nestedRecordSchema(schema avro.Schema) *avro.RecordSchema {
+	if union, ok := schema.(*avro.UnionSchema); ok && union.Nullable() {
+		_, typ := union.Indices()
+		schema = union.Types()[typ]
 	}
-	// generate an insert prepared statement
-	fieldNames := []string{}
-	for _, f := range schema.Fields {
-		fieldNames = append(fieldNames, f.Name)
+	record, _ := schema.(*avro.RecordSchema)
+	return record
+}
+
+// loadRecords creates or truncates the table described by schema and inserts
+// every record produced by decoder into it. A *sql.DB is pinned to a single
+// connection and the load is wrapped in a transaction.
+func loadRecords(q Querier, schema *SqliteSchema, decoder recordDecoder, opts LoadOptions) (LoadAvroResult, error) {
+	if len(schema.Fields) == 0 {
+		return LoadAvroResult{}, fmt.Errorf("%w: %q", ErrEmptySchema, schema.Table)
 	}
-	insertSql := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", schema.Table, strings.Join(fieldNames, ", "), strings.Repeat("?, ", len(schema.Fields)-1)+"?")
-	stmt, err := db.Prepare(insertSql)
+	db, ok := q.(*sql.DB)
+	if !ok {
+		return insertRecords(q, schema, decoder, opts)
+	}
+
+	conn, err := db.Conn(context.Background())
 	if err != nil {
-		return 0, err
+		return LoadAvroResult{}, err
+	}
+	defer conn.Close()
+	cq := &connQuerier{conn: conn}
+	if !opts.FastInsert {
+		return loadRecordsInTx(cq, schema, decoder, opts)
 	}
-	defer stmt.Close()
 
-	// for each record in the avro file
-	var count int64
-	var st map[string]any
-	for err == nil {
-		err = decoder.Decode(&st)
-		if err == io.EOF {
-			break
+	var result LoadAvroResult
+	err = withFastInsert(cq, func() error {
+		result, err = loadRecordsInTx(cq, schema, decoder, opts)
+		return err
+	})
+	return result, err
+}
+
+// withFastInsert runs fn with synchronous=OFF and journal_mode=MEMORY set on the
+// connection, then restores their previous values. See LoadOptions.FastInsert.
+func withFastInsert(cq *connQuerier, fn func() error) (err error) {
+	var synchronous int
+	var journalMode string
+	if err := cq.conn.QueryRowContext(context.Background(), "PRAGMA synchronous").Scan(&synchronous); err != nil {
+		return fmt.Errorf("failed to read synchronous pragma: [%w]", err)
+	}
+	if err := cq.conn.QueryRowContext(context.Background(), "PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		return fmt.Errorf("failed to read journal_mode pragma: [%w]", err)
+	}
+
+	if _, err := cq.Exec("PRAGMA synchronous = OFF"); err != nil {
+		return fmt.Errorf("failed to set synchronous pragma: [%w]", err)
+	}
+	defer func() {
+		if _, restoreErr := cq.Exec(fmt.Sprintf("PRAGMA synchronous = %d", synchronous)); restoreErr != nil && err == nil {
+			err = fmt.Errorf("failed to restore synchronous pragma: [%w]", restoreErr)
 		}
-		if err != nil {
-			return 0, err
+	}()
+	// leaving WAL mode needs exclusive access to the database and is persistent
+	if !strings.EqualFold(journalMode, "wal") {
+		if _, err := cq.Exec("PRAGMA journal_mode = MEMORY"); err != nil {
+			return fmt.Errorf("failed to set journal_mode pragma: [%w]", err)
 		}
+		defer func() {
+			if _, restoreErr := cq.Exec("PRAGMA journal_mode = " + journalMode); restoreErr != nil && err == nil {
+				err = fmt.Errorf("failed to restore journal_mode pragma: [%w]", restoreErr)
+			}
+		}()
+	}
+
+	return fn()
+}
 
-		args := []any{}
-		for _, f := range fieldNames {
-			args = append(args, st[f])
+// loadRecordsInTx runs insertRecords in a transaction on a single connection.
+func loadRecordsInTx(cq *connQuerier, schema *SqliteSchema, decoder recordDecoder, opts LoadOptions) (LoadAvroResult, error) {
+	err := opts.retryBusy(func() error {
+		_, err := cq.Exec("BEGIN IMMEDIATE")
+		return err
+	})
+	if err != nil {
+		return LoadAvroResult{}, err
+	}
+
+	result, err := insertRecords(cq, schema, decoder, opts)
+	if err != nil {
+		return LoadAvroResult{}, rollback(cq, err)
+	}
+
+	err = opts.retryBusy(func() error {
+		_, err := cq.Exec("COMMIT")
+		return err
+	})
+	if err != nil {
+		return LoadAvroResult{}, rollback(cq, err)
+	}
+	return result, nil
+}
+
+// rollback rolls back the transaction of cq after it failed with err, and
+// returns err joined with the error of the ROLLBACK, if any. Some errors roll
+// the transaction back on their own, so a ROLLBACK without a transaction is
+// not an error.
+func rollback(cq *connQuerier, err error) error {
+	_, rollbackErr := cq.Exec("ROLLBACK")
+	if rollbackErr == nil || strings.Contains(rollbackErr.Error(), "no transaction is active") {
+		return err
+	}
+	return errors.Join(err, fmt.Errorf("failed to roll back: [%w]", rollbackErr))
+}
+
+// insertRecords creates or truncates the table described by schema and inserts
+// every record produced by decoder into it using q.
+func insertRecords(q Querier, schema *SqliteSchema, decoder recordDecoder, opts LoadOptions) (LoadAvroResult, error) {
+	if opts.DisableTriggers {
+		return withoutTriggers(q, schema.Table, func() (LoadAvroResult, error) {
+			opts.DisableTriggers = false
+			return insertRecords(q, schema, decoder, opts)
+		})
+	}
+	sink := NewSQLiteSink(q, opts)
+	inserted, err := writeRecords(sink, schema, decoder, opts)
+	return LoadAvroResult{Created: sink.created, Truncated: sink.truncated, Inserted: inserted}, err
+}
+
+// recordKeys returns the keys of record holding each of the columns. A column is
+// held by the field of its Avro name or, unless ExactFieldNames is set, by the
+// only field whose name differs from it in case.
+func (opts LoadOptions) recordKeys(schema *SqliteSchema, columns []string, record map[string]any) (map[string]string, error) {
+	names, err := schema.loadFieldNames()
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]string, len(columns))
+	for _, column := range columns {
+		name := avroFieldName(names, column)
+		keys[column] = name
+		if _, ok := record[name]; ok || opts.ExactFieldNames {
+			continue
 		}
 
-		_, err = stmt.Exec(args...)
-		if err != nil {
-			return count, err
+		matches := []string{}
+		for key := range record {
+			if strings.EqualFold(key, name) {
+				matches = append(matches, key)
+			}
+		}
+		sort.Strings(matches)
+		switch len(matches) {
+		case 0:
+		case 1:
+			opts.logger().Warn("field matched to a column of another case", "table", schema.Table, "column", column, "field", matches[0])
+			keys[column] = matches[0]
+		default:
+			return nil, fmt.Errorf("column %s of table %s matches several fields: %s", column, schema.Table, strings.Join(matches, ", "))
+		}
+	}
+	return keys, nil
+}
+
+// upsertSQL returns the ON CONFLICT clause turning the INSERT of fields into an upsert
+// keyed on the primary key of the table.
+func upsertSQL(schema *SqliteSchema, fields []SchemaField) (string, error) {
+	primaryKey := schema.primaryKey()
+	if len(primaryKey) == 0 {
+		return "", fmt.Errorf("upsert requires a primary key on table %s", schema.Table)
+	}
+
+	inserted := map[string]bool{}
+	for _, f := range fields {
+		inserted[f.Name] = true
+	}
+	isKey := map[string]bool{}
+	keys := []string{}
+	for _, name := range primaryKey {
+		if !inserted[name] {
+			return "", fmt.Errorf("upsert requires primary key column %s to be loaded", name)
+		}
+		isKey[name] = true
+		keys = append(keys, quoteIdentifier(name))
+	}
+
+	updates := []string{}
+	for _, f := range fields {
+		if isKey[f.Name] || f.Generated {
+			continue
+		}
+		updates = append(updates, fmt.Sprintf("%s = excluded.%s", quoteIdentifier(f.Name), quoteIdentifier(f.Name)))
+	}
+	if len(updates) == 0 {
+		return fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(keys, ", ")), nil
+	}
+	return fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(keys, ", "), strings.Join(updates, ", ")), nil
+}
+
+// SQLite has no date type, dates and times are stored as text in these formats.
+// https://www.sqlite.org/lang_datefunc.html
+const (
+	sqliteDateFormat     = "2006-01-02"
+	sqliteDatetimeFormat = "2006-01-02 15:04:05.999999"
+)
+
+// toSqliteValue converts a decoded Avro value to the value stored in a column of type t.
+// Times are formatted as text in UTC, other values are returned as is.
+func toSqliteValue(t SqliteType, v any) any {
+	tm, ok := v.(time.Time)
+	if !ok {
+		return v
+	}
+	switch t {
+	case SqliteDate:
+		return tm.UTC().Format(sqliteDateFormat)
+	case SqliteDatetime, SqliteTimestamp:
+		return tm.UTC().Format(sqliteDatetimeFormat)
+	}
+	return v
+}
+
+// toJSONText encodes an Avro array or map as JSON text. NULL is returned as is.
+func toJSONText(v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// unionValue returns the value of the branch of a union decoded as a map keyed by
+// the branch type, such as {"long": 1}, whatever the position of the branch in the
+// union. Other values are returned as is.
+func unionValue(v any) any {
+	branch, ok := v.(map[string]any)
+	if !ok || len(branch) != 1 {
+		return v
+	}
+	for _, value := range branch {
+		return value
+	}
+	return v
+}
+
+// projectFields returns the fields of schema selected by Fields, in the order of Fields.
+func (opts LoadOptions) projectFields(schema *SqliteSchema) ([]SchemaField, error) {
+	if len(opts.Fields) == 0 {
+		return schema.Fields, nil
+	}
+
+	fields := []SchemaField{}
+	for _, name := range opts.Fields {
+		found := false
+		for _, f := range schema.Fields {
+			if f.Name == name {
+				fields = append(fields, f)
+				found = true
+				break
+			}
 		}
-		count += 1
+		if !found {
+			return nil, fmt.Errorf("projected field %s is not in the schema of %s", name, schema.Table)
+		}
+	}
+	return fields, nil
+}
+
+// retryBusy calls fn, retrying it while it fails because the database is busy
+// or locked, up to BusyRetries times with an exponential backoff.
+func (opts LoadOptions) retryBusy(fn func() error) error {
+	err := fn()
+	backoff := opts.BusyBackoff
+	for i := 0; i < opts.BusyRetries && isBusy(err); i++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		err = fn()
+	}
+	if opts.BusyRetries > 0 && isBusy(err) {
+		return fmt.Errorf("database still busy after %d retries: [%w]", opts.BusyRetries, err)
+	}
+	return err
+}
+
+// isBusy reports whether err is a SQLITE_BUSY or SQLITE_LOCKED error.
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
 	}
-	// insert the record into the database
-	return count, nil
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+}
+
+// TypeMapper converts a field of a SqliteSchema to the Avro schema of the matching record field.
+// Implementations can change the density of the mapping (int instead of long) or
+// introduce logical types and enums without changing the rest of the conversion.
+type TypeMapper interface {
+	ToAvro(field SchemaField) (avro.Schema, error)
 }
 
-// sqliteTypeToAvroSchema converts a sqlite type to an avro primitve schema.
-// Sqlite typoes are convered into the largest avro type that can hold the sqlite type.
+// DefaultTypeMapper is the TypeMapper used when none is configured.
+// It maps each sqlite type to the largest Avro primitive that can hold it,
+// see SqliteTypeToAvroSchema.
+type DefaultTypeMapper struct{}
+
+// ToAvro converts the field to its Avro primitive schema, wrapped in a union with null if it is nullable.
+func (DefaultTypeMapper) ToAvro(field SchemaField) (avro.Schema, error) {
+	return SqliteTypeToAvroSchema(field.Type, field.Nullable)
+}
+
+// avroNamePattern matches valid Avro names.
+// https://avro.apache.org/docs/1.8.2/spec.html#names
+var avroNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// isValidAvroName reports whether name can be used as an Avro name.
+func isValidAvroName(name string) bool {
+	return avroNamePattern.MatchString(name)
+}
+
+// sanitizeAvroName converts name to a valid Avro name by replacing every invalid
+// character with an underscore and prefixing names that start with a digit.
+func sanitizeAvroName(name string) string {
+	b := strings.Builder{}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// SqliteTypeToAvroSchema converts a sqlite type to an avro primitive schema.
+// If nullable is true the schema is a union of null and the primitive, ["null", T].
+// Sqlite types are converted into the largest avro type that can hold the sqlite type.
 // This means that representations are not as dense as they could be, but it is a simple
-// way to ensure compatibility.
+// way to ensure compatibility. DATE columns are mapped to the date logical type and
+// DATETIME and TIMESTAMP columns to the timestamp-micros logical type.
 // https://www.sqlite.org/datatype3.html
 // https://avro.apache.org/docs/1.8.2/spec.html#schema_primitive
-func sqliteTypeToAvroSchema(t SqliteType, nullable bool) (avro.Schema, error) {
+func SqliteTypeToAvroSchema(t SqliteType, nullable bool) (avro.Schema, error) {
 	var avroSchema avro.Schema
 	switch t {
 	case SqliteNull:
@@ -118,8 +675,15 @@ func sqliteTypeToAvroSchema(t SqliteType, nullable bool) (avro.Schema, error) {
 		avroSchema = bytesSchema
 	case SqliteBoolean:
 		avroSchema = booleanSchema
+	case SqliteDate:
+		avroSchema = dateSchema
+	case SqliteDatetime, SqliteTimestamp:
+		avroSchema = timestampMicrosSchema
+	case SqliteAny:
+		// the union already includes null
+		return anySchema, nil
 	default:
-		return nil, fmt.Errorf("unknown sqlite type: %s", t)
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedType, t)
 	}
 
 	if nullable {
@@ -141,6 +705,10 @@ func sqliteTypeToAvroSchema(t SqliteType, nullable bool) (avro.Schema, error) {
 //   - error: An error if any occurred during the reading process, nil otherwise.
 //
 // This function decodes Avro records until it reaches the end of the input or encounters an error.
+// A decoding error names the index of the record that failed, counting from 0.
+// If the schema is a union of records, as written by TableToOCFUnion, each map has a single
+// entry keyed by the full name of the record's schema holding the record, and non-null
+// values of the record's nullable fields are in turn maps keyed by their Avro type.
 func ReadAvro(schema avro.Schema, r io.Reader) ([]map[string]any, error) {
 	out := []map[string]any{}
 
@@ -149,17 +717,267 @@ func ReadAvro(schema avro.Schema, r io.Reader) ([]map[string]any, error) {
 		return out, err
 	}
 
-	var st map[string]any
 	for err == nil {
+		// decode each record into a new map, the decoder adds to an existing one
+		var st map[string]any
 		err = decoder.Decode(&st)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return out, err
+			return out, fmt.Errorf("failed to decode record %d: [%w]", len(out), err)
 		}
 		out = append(out, st)
 	}
 
 	return out, nil
 }
+
+// DecodeInto decodes Avro records from an io.Reader into a slice of structs.
+//
+// Parameters:
+//   - schema: The Avro schema used to decode the data.
+//   - r: An io.Reader providing the Avro data to be read.
+//   - dest: A pointer to a slice the records are appended to, for example *[]Person.
+//
+// Returns:
+//   - error: An error if any occurred during the reading process, nil otherwise.
+//
+// Records are decoded directly into the slice's element type by hamba/avro, without
+// going through map[string]any. Struct fields are matched to Avro fields by their
+// avro tag, or by their name if they have none. Nullable columns are encoded as
+// unions with null and must be decoded into pointer fields.
+func DecodeInto(schema avro.Schema, r io.Reader, dest any) error {
+	slice := reflect.ValueOf(dest)
+	if slice.Kind() != reflect.Pointer || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("dest must be a pointer to a slice, got %T", dest)
+	}
+	slice = slice.Elem()
+	elemType := slice.Type().Elem()
+
+	decoder, err := avro.NewDecoder(schema.String(), r)
+	if err != nil {
+		return err
+	}
+
+	for n := 0; ; n++ {
+		elem := reflect.New(elemType)
+		err = decoder.Decode(elem.Interface())
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode record %d: [%w]", n, err)
+		}
+		slice.Set(reflect.Append(slice, elem.Elem()))
+	}
+}
+
+// AvroToSqliteSchema converts an Avro record schema into a SqliteSchema.
+//
+// The record name becomes the table name, each field becomes a column and
+// nullable unions (["null", T]) become nullable columns. Fields sanitized by
+// ToAvroWithOptions are mapped back to the column name recorded in their doc.
+// Arrays and maps become TEXT columns flagged as JSON, their values are loaded
+// as JSON text. The Sql of the returned schema is generated from the fields with GenerateSQL.
+// The custom properties of the fields cannot be listed from a hamba/avro schema,
+// see AvroJSONToSqliteSchema to keep them.
+func AvroToSqliteSchema(schema avro.Schema) (*SqliteSchema, error) {
+	record, ok := schema.(*avro.RecordSchema)
+	if !ok {
+		return nil, fmt.Errorf("avro schema must be a record, got %s", schema.Type())
+	}
+
+	s := &SqliteSchema{
+		Table:  record.Name(),
+		Fields: []SchemaField{},
+	}
+	for _, field := range record.Fields() {
+		t, nullable, err := avroSchemaToSqliteType(field.Type())
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert avro field %s: [%w]", field.Name(), err)
+		}
+
+		var def any = avro.NoDefault
+		if field.HasDefault() {
+			def = field.Default()
+			// a bytes default is a string of its bytes, see SchemaField.AvroDefault
+			if b, ok := def.(string); ok && t == SqliteBlob {
+				def = []byte(b)
+			}
+		}
+
+		// sanitized fields carry the original column name as their doc
+		name := field.Name()
+		if doc := field.Doc(); doc != "" && doc != name && sanitizeAvroName(doc) == name {
+			name = doc
+			if s.FieldAliases == nil {
+				s.FieldAliases = map[string]string{}
+			}
+			s.FieldAliases[name] = field.Name()
+		}
+
+		s.Fields = append(s.Fields, SchemaField{
+			Name:     name,
+			Type:     t,
+			Nullable: nullable,
+			Default:  def,
+			JSON:     isAvroCollection(field.Type()),
+		})
+	}
+	ddl, err := s.GenerateSQL()
+	if err != nil {
+		return nil, err
+	}
+	s.Sql = ddl
+
+	return s, nil
+}
+
+// AvroJSONToSqliteSchema converts the JSON of an Avro record schema to a SqliteSchema.
+// It is the counterpart of SqliteSchema.ToAvroJSON: unlike AvroToSqliteSchema,
+// the aliases of the fields and their custom properties, as Props, are kept.
+func AvroJSONToSqliteSchema(data []byte) (*SqliteSchema, error) {
+	avroSchema, err := avro.Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse avro schema: [%w]", err)
+	}
+	schema, err := AvroToSqliteSchema(avroSchema)
+	if err != nil {
+		return nil, err
+	}
+	if err := schema.setPropsFromJSON(data); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// setPropsFromJSON sets the Aliases and the Props of the fields from the aliases
+// and the custom properties of the fields of the Avro record schema JSON the
+// schema was converted from.
+func (s *SqliteSchema) setPropsFromJSON(data []byte) error {
+	var record struct {
+		Fields []map[string]any `json:"fields"`
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return fmt.Errorf("failed to read avro field properties: [%w]", err)
+	}
+	for i, field := range record.Fields {
+		if i >= len(s.Fields) {
+			break
+		}
+		if aliases, ok := field["aliases"].([]any); ok {
+			for _, alias := range aliases {
+				if name, ok := alias.(string); ok {
+					s.Fields[i].Aliases = append(s.Fields[i].Aliases, name)
+				}
+			}
+		}
+		for key, value := range field {
+			if avroFieldReserved[key] {
+				continue
+			}
+			if s.Fields[i].Props == nil {
+				s.Fields[i].Props = map[string]any{}
+			}
+			s.Fields[i].Props[key] = value
+		}
+	}
+	return nil
+}
+
+// avroSchemaToSqliteType converts an avro schema to the sqlite type used to store it.
+// It is the reverse of SqliteTypeToAvroSchema, nullable unions are reported as nullable
+// and unwrapped to their non-null type.
+func avroSchemaToSqliteType(schema avro.Schema) (SqliteType, bool, error) {
+	nullable := false
+	if union, ok := schema.(*avro.UnionSchema); ok {
+		if isAnyUnion(union) {
+			return SqliteAny, true, nil
+		}
+		if !union.Nullable() {
+			return "", false, fmt.Errorf("unsupported avro union: %s", union)
+		}
+		_, typ := union.Indices()
+		schema = union.Types()[typ]
+		nullable = true
+	}
+
+	if primitive, ok := schema.(*avro.PrimitiveSchema); ok && primitive.Logical() != nil {
+		switch primitive.Logical().Type() {
+		case avro.Date:
+			return SqliteDate, nullable, nil
+		case avro.TimestampMillis, avro.TimestampMicros:
+			return SqliteDatetime, nullable, nil
+		}
+	}
+
+	switch schema.Type() {
+	case avro.Null:
+		return SqliteNull, true, nil
+	case avro.Int, avro.Long:
+		return SqliteInteger, nullable, nil
+	case avro.Float, avro.Double:
+		return SqliteReal, nullable, nil
+	case avro.String:
+		return SqliteText, nullable, nil
+	case avro.Bytes, avro.Fixed:
+		return SqliteBlob, nullable, nil
+	case avro.Boolean:
+		return SqliteBoolean, nullable, nil
+	case avro.Array, avro.Map:
+		// collections are stored as JSON text
+		return SqliteText, nullable, nil
+	}
+	return "", false, fmt.Errorf("unsupported avro type: %s", schema.Type())
+}
+
+// isAvroCollection reports whether schema is an array or a map, or a nullable union of one.
+func isAvroCollection(schema avro.Schema) bool {
+	if union, ok := schema.(*avro.UnionSchema); ok && union.Nullable() {
+		_, typ := union.Indices()
+		schema = union.Types()[typ]
+	}
+	return schema.Type() == avro.Array || schema.Type() == avro.Map
+}
+
+// isAnyUnion reports whether union is the union of primitives an ANY column is converted to.
+func isAnyUnion(union *avro.UnionSchema) bool {
+	return union.Fingerprint() == anySchema.Fingerprint()
+}
+
+// CreateTableFromAvscFile creates an empty table from an Avro schema file (.avsc).
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - path: The path of the file holding the Avro record schema as JSON.
+//
+// Returns:
+//   - *SqliteSchema: The schema of the created table.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// The schema is converted with AvroToSqliteSchema, so the table is named after
+// the record, and the generated CREATE TABLE statement is executed. It fails if
+// the table already exists.
+func CreateTableFromAvscFile(db *sql.DB, path string) (*SqliteSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read avro schema file: [%w]", err)
+	}
+	avroSchema, err := avro.Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse avro schema file %s: [%w]", path, err)
+	}
+
+	schema, err := AvroToSqliteSchema(avroSchema)
+	if err != nil {
+		return nil, err
+	}
+	if err := schema.setPropsFromJSON(data); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema.Sql); err != nil {
+		return nil, fmt.Errorf("failed to create table %s: [%w]", schema.Table, err)
+	}
+	return schema, nil
+}
@@ -0,0 +1,144 @@
+package avrosqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hamba/avro"
+)
+
+// LoadAvroJSON loads JSON encoded Avro data into a SQLite database.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - schema: A pointer to the SqliteSchema containing the table structure.
+//   - r: An io.Reader providing a stream of JSON encoded Avro records.
+//
+// Returns:
+//   - int64: The number of records successfully inserted into the database.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// Records use the Avro JSON encoding (https://avro.apache.org/docs/1.8.2/spec.html#json_encoding):
+// non-null union values are wrapped in an object keyed by their type, for example
+// {"id": {"long": 1}, "name": null}, and bytes are strings of code points 0-255.
+// The table is created or truncated as with LoadAvro.
+func LoadAvroJSON(db *sql.DB, schema *SqliteSchema, r io.Reader) (int64, error) {
+	avroSchema, err := schema.ToAvroWithOptions(AvroOptions{SanitizeNames: true})
+	if err != nil {
+		return 0, err
+	}
+
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	result, err := loadRecords(db, schema, &jsonRecordDecoder{dec: dec, schema: avroSchema.(*avro.RecordSchema)}, LoadOptions{})
+	return result.Inserted, err
+}
+
+// jsonRecordDecoder decodes JSON encoded Avro records from a stream of JSON objects.
+type jsonRecordDecoder struct {
+	dec    *json.Decoder
+	schema *avro.RecordSchema
+}
+
+func (d *jsonRecordDecoder) Decode(v any) error {
+	var raw map[string]any
+	if err := d.dec.Decode(&raw); err != nil {
+		return err
+	}
+
+	record := map[string]any{}
+	for _, field := range d.schema.Fields() {
+		value, ok := raw[field.Name()]
+		if !ok {
+			if !field.HasDefault() {
+				return fmt.Errorf("missing value for field %s", field.Name())
+			}
+			record[field.Name()] = field.Default()
+			continue
+		}
+
+		value, err := fromAvroJSON(field.Type(), value)
+		if err != nil {
+			return fmt.Errorf("invalid value for field %s: [%w]", field.Name(), err)
+		}
+		record[field.Name()] = value
+	}
+
+	out, ok := v.(*map[string]any)
+	if !ok {
+		return fmt.Errorf("cannot decode record into %T", v)
+	}
+	*out = record
+	return nil
+}
+
+// fromAvroJSON converts a JSON decoded value in the Avro JSON encoding of schema
+// into the Go value used for the binary encoding.
+func fromAvroJSON(schema avro.Schema, v any) (any, error) {
+	switch schema.Type() {
+	case avro.Null:
+		if v != nil {
+			return nil, fmt.Errorf("expected null, got %v", v)
+		}
+		return nil, nil
+	case avro.Union:
+		union := schema.(*avro.UnionSchema)
+		if v == nil {
+			if !union.Nullable() {
+				return nil, fmt.Errorf("null is not allowed in union %s", union)
+			}
+			return nil, nil
+		}
+		branch, ok := v.(map[string]any)
+		if !ok || len(branch) != 1 {
+			return nil, fmt.Errorf("expected union value of the form {\"type\": value}, got %v", v)
+		}
+		for name, value := range branch {
+			typ, _ := union.Types().Get(name)
+			if typ == nil {
+				return nil, fmt.Errorf("unknown union branch %s", name)
+			}
+			return fromAvroJSON(typ, value)
+		}
+	case avro.Int, avro.Long:
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("expected number, got %v", v)
+		}
+		return n.Int64()
+	case avro.Float, avro.Double:
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("expected number, got %v", v)
+		}
+		return n.Float64()
+	case avro.String:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string, got %v", v)
+		}
+		return s, nil
+	case avro.Bytes:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string, got %v", v)
+		}
+		b := make([]byte, 0, len(s))
+		for _, r := range s {
+			if r > 0xff {
+				return nil, fmt.Errorf("invalid code point %U in bytes", r)
+			}
+			b = append(b, byte(r))
+		}
+		return b, nil
+	case avro.Boolean:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected boolean, got %v", v)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unsupported avro type: %s", schema.Type())
+}
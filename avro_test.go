@@ -0,0 +1,1318 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hamba/avro"
+	"github.com/hamba/avro/ocf"
+	"github.com/mattn/go-sqlite3"
+)
+
+// encodeAvro encodes rows as a binary Avro stream using the Avro form of schema,
+// renaming columns the same way the exporter does.
+func encodeAvro(t *testing.T, schema *SqliteSchema, rows []map[string]any) []byte {
+	t.Helper()
+	avroSchema, err := schema.ToAvroWithOptions(AvroOptions{SanitizeNames: true})
+	if err != nil {
+		t.Fatalf("SqliteSchema.ToAvroWithOptions() error = %v", err)
+	}
+	names, err := schema.AvroFieldNames(AvroOptions{SanitizeNames: true})
+	if err != nil {
+		t.Fatalf("SqliteSchema.AvroFieldNames() error = %v", err)
+	}
+	var buf bytes.Buffer
+	enc := avro.NewEncoderForSchema(avroSchema, &buf)
+	for _, row := range rows {
+		if err := enc.Encode(toAvroRecord(names, row)); err != nil {
+			t.Fatalf("Encoder.Encode() error = %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// busyQuerier fails the first failures INSERT statements with err.
+type busyQuerier struct {
+	Querier
+	failures int
+	err      error
+	attempts int
+}
+
+func (q *busyQuerier) Exec(query string, args ...any) (sql.Result, error) {
+	if strings.HasPrefix(query, "INSERT") {
+		q.attempts++
+		if q.failures > 0 {
+			q.failures--
+			return nil, q.err
+		}
+	}
+	return q.Querier.Exec(query, args...)
+}
+
+func TestLoadAvroWithOptions_BusyRetries(t *testing.T) {
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+	schema, err := ReadSchema(testDB, "foo")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	rows, err := LoadData(testDB, "foo")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	data := encodeAvro(t, schema, rows)
+
+	tests := []struct {
+		name         string
+		failures     int
+		err          error
+		retries      int
+		want         int64
+		wantAttempts int
+		wantBusy     bool
+		wantErr      bool
+	}{
+		{name: "no errors", failures: 0, err: busy, retries: 0, want: 3, wantAttempts: 3},
+		{name: "retried", failures: 2, err: busy, retries: 2, want: 3, wantAttempts: 5},
+		{name: "locked retried", failures: 1, err: sqlite3.Error{Code: sqlite3.ErrLocked}, retries: 1, want: 3, wantAttempts: 4},
+		{name: "retries exhausted", failures: 3, err: busy, retries: 2, want: 0, wantAttempts: 3, wantBusy: true, wantErr: true},
+		{name: "retries disabled", failures: 1, err: busy, retries: 0, want: 0, wantAttempts: 1, wantBusy: true, wantErr: true},
+		{name: "other errors not retried", failures: 1, err: errors.New("boom"), retries: 2, want: 0, wantAttempts: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &busyQuerier{Querier: newTestDB(t), failures: tt.failures, err: tt.err}
+			opts := LoadOptions{BusyRetries: tt.retries, BusyBackoff: time.Millisecond}
+
+			got, err := LoadAvroWithOptions(q, schema, bytes.NewReader(data), opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadAvroWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("LoadAvroWithOptions() = %v, want %v", got, tt.want)
+			}
+			if q.attempts != tt.wantAttempts {
+				t.Errorf("LoadAvroWithOptions() attempts = %v, want %v", q.attempts, tt.wantAttempts)
+			}
+			var sqliteErr sqlite3.Error
+			if gotBusy := errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrBusy; gotBusy != tt.wantBusy {
+				t.Errorf("LoadAvroWithOptions() error = %v, wantBusy %v", err, tt.wantBusy)
+			}
+		})
+	}
+}
+
+func TestLoadAvro(t *testing.T) {
+	schema, err := ReadSchema(testDB, "meats")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	want, err := LoadData(testDB, "meats")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+
+	db := newTestDB(t)
+	got, err := LoadAvro(db, schema, bytes.NewReader(encodeAvro(t, schema, want)))
+	if err != nil {
+		t.Fatalf("LoadAvro() error = %v", err)
+	}
+	if got != 3 {
+		t.Errorf("LoadAvro() = %v, want %v", got, 3)
+	}
+}
+
+func TestLoadAvro_SanitizedNamesRoundTrip(t *testing.T) {
+	src := newTestDB(t)
+	_, err := src.Exec(`CREATE TABLE people (id INTEGER PRIMARY KEY, "first name" TEXT, "2fa" INTEGER)`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	_, err = src.Exec(`INSERT INTO people ("first name", "2fa") VALUES ('Luz', 1), ('Hunter', 0)`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	schema, err := ReadSchema(src, "people")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	want, err := LoadData(src, "people")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	data := encodeAvro(t, schema, want)
+
+	wantNames := map[string]string{"first name": "first_name", "2fa": "_2fa"}
+	names, err := schema.AvroFieldNames(AvroOptions{SanitizeNames: true})
+	if err != nil {
+		t.Fatalf("SqliteSchema.AvroFieldNames() error = %v", err)
+	}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("SqliteSchema.AvroFieldNames() = %v, want %v", names, wantNames)
+	}
+	// converting the schema does not change it
+	if schema.FieldAliases != nil {
+		t.Errorf("SqliteSchema.FieldAliases = %v, want nil", schema.FieldAliases)
+	}
+
+	dst := newTestDB(t)
+	count, err := LoadAvro(dst, schema, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("LoadAvro() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("LoadAvro() = %v, want %v", count, 2)
+	}
+
+	got, err := LoadData(dst, "people")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadData() = %v, want %v", got, want)
+	}
+}
+
+func TestAvroToSqliteSchema_SanitizedNames(t *testing.T) {
+	schema := &SqliteSchema{
+		Table:  "people",
+		Fields: []SchemaField{{Name: "first name", Type: SqliteText, Nullable: true}},
+	}
+	avroSchema, err := schema.ToAvroWithOptions(AvroOptions{SanitizeNames: true})
+	if err != nil {
+		t.Fatalf("SqliteSchema.ToAvroWithOptions() error = %v", err)
+	}
+
+	got, err := AvroToSqliteSchema(avroSchema)
+	if err != nil {
+		t.Fatalf("AvroToSqliteSchema() error = %v", err)
+	}
+	if got.Fields[0].Name != "first name" {
+		t.Errorf("AvroToSqliteSchema() Fields[0].Name = %v, want %v", got.Fields[0].Name, "first name")
+	}
+	if want := map[string]string{"first name": "first_name"}; !reflect.DeepEqual(got.FieldAliases, want) {
+		t.Errorf("AvroToSqliteSchema() FieldAliases = %v, want %v", got.FieldAliases, want)
+	}
+}
+
+func TestLoadAvroJSON(t *testing.T) {
+	schema, err := ReadSchema(testDB, "foo")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		data    string
+		want    []map[string]any
+		wantErr bool
+	}{
+		{
+			name: "foo",
+			data: `{"id": {"long": 1}, "name": {"string": "bar"}}
+{"id": {"long": 2}, "name": {"string": "bat"}}
+{"id": {"long": 3}, "name": {"string": "baz"}}`,
+			want: []map[string]any{{"id": int64(1), "name": "bar"}, {"id": int64(2), "name": "bat"}, {"id": int64(3), "name": "baz"}},
+		},
+		{
+			name: "null",
+			data: `{"id": {"long": 1}, "name": null}`,
+			want: []map[string]any{{"id": int64(1), "name": nil}},
+		},
+		{
+			name:    "unwrapped union",
+			data:    `{"id": 1, "name": "bar"}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing field",
+			data:    `{"id": {"long": 1}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			count, err := LoadAvroJSON(db, schema, strings.NewReader(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadAvroJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if count != int64(len(tt.want)) {
+				t.Errorf("LoadAvroJSON() = %v, want %v", count, len(tt.want))
+			}
+
+			got, err := LoadData(db, "foo")
+			if err != nil {
+				t.Fatalf("LoadData() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadData() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_fromAvroJSON_Bytes(t *testing.T) {
+	got, err := fromAvroJSON(bytesSchema, "\u0000\u00ffA")
+	if err != nil {
+		t.Fatalf("fromAvroJSON() error = %v", err)
+	}
+	if want := []byte{0x00, 0xff, 'A'}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fromAvroJSON() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadAvroWithResult(t *testing.T) {
+	schema, err := ReadSchema(testDB, "foo")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	rows, err := LoadData(testDB, "foo")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	data := encodeAvro(t, schema, rows)
+	fingerprint, err := schema.Fingerprint()
+	if err != nil {
+		t.Fatalf("SqliteSchema.Fingerprint() error = %v", err)
+	}
+
+	// the same database is loaded twice, first creating then truncating the table
+	db := newTestDB(t)
+	tests := []struct {
+		name string
+		want LoadAvroResult
+	}{
+		{name: "create", want: LoadAvroResult{Created: true, Inserted: 3, Fingerprint: fingerprint}},
+		{name: "truncate", want: LoadAvroResult{Truncated: true, Inserted: 3, Fingerprint: fingerprint}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadAvroWithResult(db, schema, bytes.NewReader(data), LoadOptions{})
+			if err != nil {
+				t.Fatalf("LoadAvroWithResult() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadAvroWithResult() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	counts, err := TableRowCounts(db)
+	if err != nil {
+		t.Fatalf("TableRowCounts() error = %v", err)
+	}
+	if counts["foo"] != 3 {
+		t.Errorf("TableRowCounts() = %v, want %v", counts["foo"], 3)
+	}
+}
+
+func TestLoadAvroWithOptions_Fields(t *testing.T) {
+	schema, err := ReadSchema(testDB, "meats")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	rows, err := LoadData(testDB, "meats")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	data := encodeAvro(t, schema, rows)
+
+	tests := []struct {
+		name    string
+		create  bool
+		fields  []string
+		want    []map[string]any
+		wantErr bool
+	}{
+		{
+			name:   "existing table",
+			create: true,
+			fields: []string{"id", "name"},
+			want:   []map[string]any{{"id": int64(1), "name": "beef"}, {"id": int64(2), "name": "pork"}, {"id": int64(3), "name": "chicken"}},
+		},
+		{
+			name:   "new table",
+			fields: []string{"name", "id"},
+			want:   []map[string]any{{"id": int64(1), "name": "beef"}, {"id": int64(2), "name": "pork"}, {"id": int64(3), "name": "chicken"}},
+		},
+		{
+			name:    "unknown field",
+			fields:  []string{"id", "price"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			if tt.create {
+				if _, err := db.Exec("CREATE TABLE meats (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+					t.Fatalf("db.Exec() error = %v", err)
+				}
+			}
+
+			count, err := LoadAvroWithOptions(db, schema, bytes.NewReader(data), LoadOptions{Fields: tt.fields})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadAvroWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if count != 3 {
+				t.Errorf("LoadAvroWithOptions() = %v, want %v", count, 3)
+			}
+
+			got, err := LoadData(db, "meats")
+			if err != nil {
+				t.Fatalf("LoadData() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadData() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadAvroWithOptions_FieldsTextDefault(t *testing.T) {
+	src := newTestDB(t)
+	_, err := src.Exec(`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT DEFAULT 'abc', note TEXT DEFAULT 'it''s', price REAL);
+		INSERT INTO items (id, price) VALUES (1, 0.5)`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	schema, err := ReadSchema(src, "items")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	rows, err := LoadData(src, "items")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	data := encodeAvro(t, schema, rows)
+
+	// the table created for the projected fields keeps the defaults of the
+	// schema, which ReadSchema returns quoted, without quoting them again
+	db := newTestDB(t)
+	if _, err := LoadAvroWithOptions(db, schema, bytes.NewReader(data), LoadOptions{Fields: []string{"id", "name", "note"}}); err != nil {
+		t.Fatalf("LoadAvroWithOptions() error = %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO items (id) VALUES (2)"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	got, err := LoadData(db, "items")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	want := []map[string]any{{"id": int64(1), "name": "abc", "note": "it's"}, {"id": int64(2), "name": "abc", "note": "it's"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadData() = %v, want %v", got, want)
+	}
+}
+
+func TestReadAvro_UnionOfRecords(t *testing.T) {
+	records := []avro.Schema{}
+	for _, table := range []string{"foo", "meats"} {
+		schema, err := ReadSchema(testDB, table)
+		if err != nil {
+			t.Fatalf("ReadSchema() error = %v", err)
+		}
+		record, err := schema.ToAvro()
+		if err != nil {
+			t.Fatalf("SqliteSchema.ToAvro() error = %v", err)
+		}
+		records = append(records, record)
+	}
+	union, err := avro.NewUnionSchema(records)
+	if err != nil {
+		t.Fatalf("avro.NewUnionSchema() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	enc := avro.NewEncoderForSchema(union, &buf)
+	rows := []map[string]any{
+		{AvroNamespace + ".foo": map[string]any{"id": int64(1), "name": "bar"}},
+		{AvroNamespace + ".meats": map[string]any{"id": int64(2), "name": "pork", "description": nil}},
+	}
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			t.Fatalf("Encoder.Encode() error = %v", err)
+		}
+	}
+
+	got, err := ReadAvro(union, &buf)
+	if err != nil {
+		t.Fatalf("ReadAvro() error = %v", err)
+	}
+	want := []map[string]any{
+		{AvroNamespace + ".foo": map[string]any{"id": map[string]any{"long": int64(1)}, "name": map[string]any{"string": "bar"}}},
+		{AvroNamespace + ".meats": map[string]any{"id": map[string]any{"long": int64(2)}, "name": map[string]any{"string": "pork"}, "description": nil}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAvro() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadAvro_GeneratedColumns(t *testing.T) {
+	src := newTestDB(t)
+	_, err := src.Exec(`CREATE TABLE people (
+		first TEXT,
+		last TEXT,
+		full TEXT GENERATED ALWAYS AS (first || ' ' || last) VIRTUAL,
+		initials TEXT AS (substr(first, 1, 1) || substr(last, 1, 1)) STORED
+	);
+	INSERT INTO people (first, last) VALUES ('Luz', 'Noceda'), ('Amity', 'Blight');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	schema, err := ReadSchema(src, "people")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	got := map[string]bool{}
+	for _, f := range schema.Fields {
+		got[f.Name] = f.Generated
+	}
+	want := map[string]bool{"first": false, "last": false, "full": true, "initials": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadSchema() Generated = %v, want %v", got, want)
+	}
+
+	rows, err := LoadData(src, "people")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	if rows[0]["full"] != "Luz Noceda" {
+		t.Errorf("LoadData() full = %v, want %v", rows[0]["full"], "Luz Noceda")
+	}
+
+	dst := newTestDB(t)
+	count, err := LoadAvro(dst, schema, bytes.NewReader(encodeAvro(t, schema, rows)))
+	if err != nil {
+		t.Fatalf("LoadAvro() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("LoadAvro() = %v, want %v", count, 2)
+	}
+
+	loaded, err := LoadData(dst, "people")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	if !reflect.DeepEqual(loaded, rows) {
+		t.Errorf("LoadData() = %v, want %v", loaded, rows)
+	}
+}
+
+func TestDecodeInto(t *testing.T) {
+	schema, err := ReadSchema(testDB, "foo")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	rows, err := LoadData(testDB, "foo")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	avroSchema, err := schema.ToAvro()
+	if err != nil {
+		t.Fatalf("SqliteSchema.ToAvro() error = %v", err)
+	}
+
+	// nullable columns are decoded into pointers
+	type nullableFoo struct {
+		ID   *int64  `avro:"id"`
+		Name *string `avro:"name"`
+	}
+	var nullable []nullableFoo
+	if err := DecodeInto(avroSchema, bytes.NewReader(encodeAvro(t, schema, rows)), &nullable); err != nil {
+		t.Fatalf("DecodeInto() error = %v", err)
+	}
+	if len(nullable) != 3 {
+		t.Fatalf("DecodeInto() = %v records, want %v", len(nullable), 3)
+	}
+	if *nullable[1].ID != 2 || *nullable[1].Name != "bat" {
+		t.Errorf("DecodeInto() = {%v %v}, want {2 bat}", *nullable[1].ID, *nullable[1].Name)
+	}
+
+	type foo struct {
+		ID   int64  `avro:"id"`
+		Name string `avro:"name"`
+	}
+	notNull := avro.MustParse(`{"type": "record", "name": "foo", "fields": [
+		{"name": "id", "type": "long"},
+		{"name": "name", "type": "string"}
+	]}`)
+	var buf bytes.Buffer
+	enc := avro.NewEncoderForSchema(notNull, &buf)
+	want := []foo{{ID: 1, Name: "bar"}, {ID: 2, Name: "bat"}}
+	for _, f := range want {
+		if err := enc.Encode(f); err != nil {
+			t.Fatalf("Encoder.Encode() error = %v", err)
+		}
+	}
+	var got []foo
+	if err := DecodeInto(notNull, &buf, &got); err != nil {
+		t.Fatalf("DecodeInto() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeInto() = %v, want %v", got, want)
+	}
+
+	if err := DecodeInto(notNull, &buf, got); err == nil {
+		t.Errorf("DecodeInto() into a slice error = nil, want error")
+	}
+}
+
+func TestLoadAvroWithOptions_ValueFirstUnions(t *testing.T) {
+	schema, err := ReadSchema(testDB, "foo")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	writerSchema := avro.MustParse(`{"type": "record", "name": "foo", "namespace": "com.example", "fields": [
+		{"name": "id", "type": ["long", "null"]},
+		{"name": "name", "type": ["string", "null"]}
+	]}`)
+
+	var buf bytes.Buffer
+	enc := avro.NewEncoderForSchema(writerSchema, &buf)
+	rows := []map[string]any{
+		{"id": map[string]any{"long": int64(1)}, "name": map[string]any{"string": "bar"}},
+		{"id": map[string]any{"long": int64(2)}, "name": nil},
+	}
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			t.Fatalf("Encoder.Encode() error = %v", err)
+		}
+	}
+
+	db := newTestDB(t)
+	count, err := LoadAvroWithOptions(db, schema, &buf, LoadOptions{WriterSchema: writerSchema})
+	if err != nil {
+		t.Fatalf("LoadAvroWithOptions() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("LoadAvroWithOptions() = %v, want %v", count, 2)
+	}
+
+	got, err := LoadData(db, "foo")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	want := []map[string]any{
+		{"id": int64(1), "name": "bar"},
+		{"id": int64(2), "name": nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadData() = %v, want %v", got, want)
+	}
+}
+
+func Test_unionValue(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+		want any
+	}{
+		{name: "plain", v: int64(1), want: int64(1)},
+		{name: "null", v: nil, want: nil},
+		{name: "branch", v: map[string]any{"long": int64(1)}, want: int64(1)},
+		{name: "not a branch", v: map[string]any{"a": 1, "b": 2}, want: map[string]any{"a": 1, "b": 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unionValue(tt.v); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unionValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSqliteTypeToAvroSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		t        SqliteType
+		nullable bool
+		want     string
+		wantErr  bool
+	}{
+		{name: "null", t: SqliteNull, want: `"null"`},
+		{name: "integer", t: SqliteInteger, want: `"long"`},
+		{name: "nullable integer", t: SqliteInteger, nullable: true, want: `["null","long"]`},
+		{name: "real", t: SqliteReal, want: `"double"`},
+		{name: "nullable real", t: SqliteReal, nullable: true, want: `["null","double"]`},
+		{name: "text", t: SqliteText, want: `"string"`},
+		{name: "nullable text", t: SqliteText, nullable: true, want: `["null","string"]`},
+		{name: "blob", t: SqliteBlob, want: `"bytes"`},
+		{name: "nullable blob", t: SqliteBlob, nullable: true, want: `["null","bytes"]`},
+		{name: "boolean", t: SqliteBoolean, want: `"boolean"`},
+		{name: "nullable boolean", t: SqliteBoolean, nullable: true, want: `["null","boolean"]`},
+		{name: "any", t: SqliteAny, want: `["null","long","double","string","bytes"]`},
+		{name: "nullable any", t: SqliteAny, nullable: true, want: `["null","long","double","string","bytes"]`},
+		{name: "unknown", t: SqliteType("geometry"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SqliteTypeToAvroSchema(tt.t, tt.nullable)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SqliteTypeToAvroSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsupportedType) {
+					t.Errorf("SqliteTypeToAvroSchema() error = %v, want %v", err, ErrUnsupportedType)
+				}
+				return
+			}
+			if got.String() != tt.want {
+				t.Errorf("SqliteTypeToAvroSchema() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadAvroWithResult_Upsert(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE parts (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO parts VALUES (1, 'bolt'), (2, 'nut');
+		CREATE TABLE loose (id INTEGER, name TEXT);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	schema, err := ReadSchema(db, "parts")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	stream := encodeAvro(t, schema, []map[string]any{
+		{"id": int64(2), "name": "washer"},
+		{"id": int64(3), "name": "screw"},
+	})
+
+	result, err := LoadAvroWithResult(db, schema, bytes.NewReader(stream), LoadOptions{Mode: Upsert})
+	if err != nil {
+		t.Fatalf("LoadAvroWithResult() error = %v", err)
+	}
+	want := LoadAvroResult{Inserted: 2, Fingerprint: result.Fingerprint}
+	if result != want {
+		t.Errorf("LoadAvroWithResult() = %+v, want %+v", result, want)
+	}
+
+	got, err := LoadData(db, "parts")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	wantRows := []map[string]any{
+		{"id": int64(1), "name": "bolt"},
+		{"id": int64(2), "name": "washer"},
+		{"id": int64(3), "name": "screw"},
+	}
+	if !reflect.DeepEqual(got, wantRows) {
+		t.Errorf("LoadData() = %v, want %v", got, wantRows)
+	}
+
+	loose, err := ReadSchema(db, "loose")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	_, err = LoadAvroWithResult(db, loose, bytes.NewReader(stream), LoadOptions{Mode: Upsert})
+	if err == nil {
+		t.Errorf("LoadAvroWithResult() without a primary key error = nil, want error")
+	}
+}
+
+func TestLoadAvro_DecodeErrorRecordIndex(t *testing.T) {
+	schema := &SqliteSchema{
+		Table: "items",
+		Sql:   "CREATE TABLE items (id INTEGER, name TEXT)",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger, Nullable: true},
+			{Name: "name", Type: SqliteText, Nullable: true},
+		},
+	}
+	data := encodeAvro(t, schema, []map[string]any{
+		{"id": int64(1), "name": "one"},
+		{"id": int64(2), "name": "two"},
+	})
+	// a union index of 4 is not a branch of ["null", "long"]
+	corrupt := append(data, 0x08)
+
+	avroSchema, err := schema.ToAvroWithOptions(AvroOptions{SanitizeNames: true})
+	if err != nil {
+		t.Fatalf("ToAvroWithOptions() error = %v", err)
+	}
+	_, err = ReadAvro(avroSchema, bytes.NewReader(corrupt))
+	if err == nil || !strings.Contains(err.Error(), "record 2") {
+		t.Errorf("ReadAvro() error = %v, want an error naming record 2", err)
+	}
+
+	got, err := LoadAvro(newTestDB(t), schema, bytes.NewReader(corrupt))
+	if err == nil || !strings.Contains(err.Error(), "record 2") {
+		t.Errorf("LoadAvro() error = %v, want an error naming record 2", err)
+	}
+	if got != 0 {
+		t.Errorf("LoadAvro() = %v, want %v", got, 0)
+	}
+}
+
+func TestLoadAvroWithOptions_FieldCase(t *testing.T) {
+	schema := &SqliteSchema{
+		Table: "people",
+		Sql:   "CREATE TABLE people (id INTEGER, name TEXT)",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger, Nullable: true},
+			{Name: "name", Type: SqliteText, Nullable: true},
+		},
+	}
+	encode := func(t *testing.T, writerSchema avro.Schema, record map[string]any) *bytes.Buffer {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := avro.NewEncoderForSchema(writerSchema, &buf).Encode(record); err != nil {
+			t.Fatalf("Encoder.Encode() error = %v", err)
+		}
+		return &buf
+	}
+	writerSchema := avro.MustParse(`{"type": "record", "name": "people", "fields": [
+		{"name": "id", "type": "long"},
+		{"name": "Name", "type": "string"}
+	]}`)
+
+	tests := []struct {
+		name     string
+		exact    bool
+		wantName any
+		wantLog  bool
+	}{
+		{name: "case-insensitive", wantName: "ada", wantLog: true},
+		{name: "exact", exact: true, wantName: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			db := newTestDB(t)
+			opts := LoadOptions{WriterSchema: writerSchema, ExactFieldNames: tt.exact, Logger: slog.New(slog.NewTextHandler(&logs, nil))}
+			buf := encode(t, writerSchema, map[string]any{"id": int64(1), "Name": "ada"})
+			if _, err := LoadAvroWithOptions(db, schema, buf, opts); err != nil {
+				t.Fatalf("LoadAvroWithOptions() error = %v", err)
+			}
+
+			got, err := LoadData(db, "people")
+			if err != nil {
+				t.Fatalf("LoadData() error = %v", err)
+			}
+			if want := []map[string]any{{"id": int64(1), "name": tt.wantName}}; !reflect.DeepEqual(got, want) {
+				t.Errorf("LoadData() = %v, want %v", got, want)
+			}
+			if logged := strings.Contains(logs.String(), "column=name field=Name"); logged != tt.wantLog {
+				t.Errorf("LoadAvroWithOptions() logged %q, want warning %v", logs.String(), tt.wantLog)
+			}
+		})
+	}
+
+	ambiguous := avro.MustParse(`{"type": "record", "name": "people", "fields": [
+		{"name": "id", "type": "long"},
+		{"name": "Name", "type": "string"},
+		{"name": "NAME", "type": "string"}
+	]}`)
+	buf := encode(t, ambiguous, map[string]any{"id": int64(1), "Name": "ada", "NAME": "bob"})
+	if _, err := LoadAvroWithOptions(newTestDB(t), schema, buf, LoadOptions{WriterSchema: ambiguous}); err == nil {
+		t.Error("LoadAvroWithOptions() with ambiguous fields error = nil")
+	}
+}
+
+func TestLoadAvroWithOptions_FastInsert(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer db.Close()
+	// a single connection, so the pragmas read below are those of the load's connection
+	db.SetMaxOpenConns(1)
+
+	pragmas := func(q interface {
+		QueryRowContext(context.Context, string, ...any) *sql.Row
+	}) (int, string) {
+		t.Helper()
+		var synchronous int
+		var journalMode string
+		if err := q.QueryRowContext(context.Background(), "PRAGMA synchronous").Scan(&synchronous); err != nil {
+			t.Fatalf("PRAGMA synchronous error = %v", err)
+		}
+		if err := q.QueryRowContext(context.Background(), "PRAGMA journal_mode").Scan(&journalMode); err != nil {
+			t.Fatalf("PRAGMA journal_mode error = %v", err)
+		}
+		return synchronous, journalMode
+	}
+	wantSynchronous, wantJournalMode := pragmas(db)
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("db.Conn() error = %v", err)
+	}
+	err = withFastInsert(&connQuerier{conn: conn}, func() error {
+		if synchronous, journalMode := pragmas(conn); synchronous != 0 || journalMode != "memory" {
+			t.Errorf("pragmas during load = %d, %s, want 0, memory", synchronous, journalMode)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withFastInsert() error = %v", err)
+	}
+	conn.Close()
+
+	schema := &SqliteSchema{
+		Table: "items",
+		Sql:   "CREATE TABLE items (id INTEGER, name TEXT)",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger, Nullable: true},
+			{Name: "name", Type: SqliteText, Nullable: true},
+		},
+	}
+	data := encodeAvro(t, schema, []map[string]any{
+		{"id": int64(1), "name": "one"},
+		{"id": int64(2), "name": "two"},
+	})
+	got, err := LoadAvroWithOptions(db, schema, bytes.NewReader(data), LoadOptions{FastInsert: true})
+	if err != nil {
+		t.Fatalf("LoadAvroWithOptions() error = %v", err)
+	}
+	if got != 2 {
+		t.Errorf("LoadAvroWithOptions() = %v, want %v", got, 2)
+	}
+
+	if synchronous, journalMode := pragmas(db); synchronous != wantSynchronous || journalMode != wantJournalMode {
+		t.Errorf("pragmas after load = %d, %s, want %d, %s", synchronous, journalMode, wantSynchronous, wantJournalMode)
+	}
+}
+
+func TestLoadAvroWithReaderSchema(t *testing.T) {
+	writerSchema := avro.MustParse(`{"type": "record", "name": "Item", "namespace": "com.example", "fields": [
+		{"name": "id", "type": "int"},
+		{"name": "name", "type": ["null", "bytes"]},
+		{"name": "color", "type": "string"}
+	]}`)
+	var buf bytes.Buffer
+	enc, err := avro.NewEncoder(writerSchema.String(), &buf)
+	if err != nil {
+		t.Fatalf("avro.NewEncoder() error = %v", err)
+	}
+	for _, record := range []map[string]any{
+		{"id": 1, "name": []byte("bolt"), "color": "grey"},
+		{"id": 2, "name": nil, "color": "red"},
+	} {
+		if err := enc.Encode(record); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+	}
+
+	schema := &SqliteSchema{
+		Table: "items",
+		Sql:   "CREATE TABLE items (id INTEGER, name TEXT, price REAL)",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger, Nullable: true},
+			{Name: "name", Type: SqliteText, Nullable: true},
+			{Name: "price", Type: SqliteReal, Nullable: true},
+		},
+	}
+	db := newTestDB(t)
+	got, err := LoadAvroWithReaderSchema(db, schema, writerSchema, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("LoadAvroWithReaderSchema() error = %v", err)
+	}
+	if got != 2 {
+		t.Errorf("LoadAvroWithReaderSchema() = %v, want %v", got, 2)
+	}
+
+	// color is dropped, price is missing from the writer and defaults to NULL
+	rows, err := LoadData(db, "items")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	want := []map[string]any{
+		{"id": int64(1), "name": "bolt", "price": nil},
+		{"id": int64(2), "name": nil, "price": nil},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("LoadData() = %v, want %v", rows, want)
+	}
+
+	incompatible := avro.MustParse(`{"type": "record", "name": "Item", "fields": [{"name": "id", "type": "string"}]}`)
+	if _, err := LoadAvroWithReaderSchema(db, schema, incompatible, bytes.NewReader(nil)); err == nil {
+		t.Error("LoadAvroWithReaderSchema() with an incompatible writer schema error = nil")
+	}
+}
+
+func TestLoadAvroWithReaderSchema_Aliases(t *testing.T) {
+	// the data was written before the title column was renamed to name
+	old := &SqliteSchema{
+		Table:  "items",
+		Fields: []SchemaField{{Name: "id", Type: SqliteInteger}, {Name: "title", Type: SqliteText, Nullable: true}},
+	}
+	writerSchema, err := old.ToAvro()
+	if err != nil {
+		t.Fatalf("SqliteSchema.ToAvro() error = %v", err)
+	}
+	data := encodeAvro(t, old, []map[string]any{{"id": int64(1), "title": "bolt"}, {"id": int64(2), "title": nil}})
+
+	b, err := (&SqliteSchema{
+		Table: "items",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger},
+			{Name: "name", Type: SqliteText, Nullable: true, Aliases: []string{"title"}},
+		},
+	}).ToAvroJSON(AvroOptions{})
+	if err != nil {
+		t.Fatalf("SqliteSchema.ToAvroJSON() error = %v", err)
+	}
+	schema, err := AvroJSONToSqliteSchema(b)
+	if err != nil {
+		t.Fatalf("AvroJSONToSqliteSchema() error = %v", err)
+	}
+
+	db := newTestDB(t)
+	if _, err := LoadAvroWithReaderSchema(db, schema, writerSchema, bytes.NewReader(data)); err != nil {
+		t.Fatalf("LoadAvroWithReaderSchema() error = %v", err)
+	}
+	rows, err := LoadData(db, "items")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	want := []map[string]any{{"id": int64(1), "name": "bolt"}, {"id": int64(2), "name": nil}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("LoadData() = %v, want %v", rows, want)
+	}
+}
+
+func TestLoadAvroWithOptions_DisableTriggers(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      LoadOptions
+		wantAudit int
+	}{
+		{name: "triggers enabled", opts: LoadOptions{}, wantAudit: 3},
+		{name: "triggers disabled", opts: LoadOptions{DisableTriggers: true}, wantAudit: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			_, err := db.Exec(`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT);
+				CREATE TABLE audit (action TEXT);
+				CREATE TRIGGER items_insert AFTER INSERT ON items BEGIN INSERT INTO audit VALUES ('insert'); END;
+				CREATE TRIGGER items_delete AFTER DELETE ON items BEGIN INSERT INTO audit VALUES ('delete'); END;
+				INSERT INTO items VALUES (1, 'old');
+				DELETE FROM audit;`)
+			if err != nil {
+				t.Fatalf("db.Exec() error = %v", err)
+			}
+			schema, err := ReadSchema(db, "items")
+			if err != nil {
+				t.Fatalf("ReadSchema() error = %v", err)
+			}
+			data := encodeAvro(t, schema, []map[string]any{
+				{"id": int64(1), "name": "a"},
+				{"id": int64(2), "name": "b"},
+			})
+
+			if _, err := LoadAvroWithOptions(db, schema, bytes.NewReader(data), tt.opts); err != nil {
+				t.Fatalf("LoadAvroWithOptions() error = %v", err)
+			}
+			count := func(query string) int {
+				var n int
+				if err := db.QueryRow(query).Scan(&n); err != nil {
+					t.Fatalf("db.QueryRow() error = %v", err)
+				}
+				return n
+			}
+			// the truncation deletes a row and the load inserts two
+			if got := count("SELECT count(*) FROM audit"); got != tt.wantAudit {
+				t.Errorf("audit rows after the load = %v, want %v", got, tt.wantAudit)
+			}
+			if got := count("SELECT count(*) FROM sqlite_master WHERE type = 'trigger'"); got != 2 {
+				t.Errorf("triggers after the load = %v, want %v", got, 2)
+			}
+			if _, err := db.Exec("INSERT INTO items VALUES (3, 'c')"); err != nil {
+				t.Fatalf("db.Exec() error = %v", err)
+			}
+			if got := count("SELECT count(*) FROM audit"); got != tt.wantAudit+1 {
+				t.Errorf("audit rows after an insert = %v, want %v", got, tt.wantAudit+1)
+			}
+		})
+	}
+}
+
+func TestLoadAvroExpect(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec("CREATE TABLE items (id INTEGER, name TEXT); INSERT INTO items VALUES (1, 'old')"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	schema, err := ReadSchema(db, "items")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	data := encodeAvro(t, schema, []map[string]any{{"id": int64(2), "name": "new"}})
+	expected, err := schema.ToAvro()
+	if err != nil {
+		t.Fatalf("SqliteSchema.ToAvro() error = %v", err)
+	}
+	changed := avro.MustParse(`{"type": "record", "name": "items", "namespace": "com.github.britt.avrosqlite", "fields": [
+		{"name": "id", "type": ["null", "long"]},
+		{"name": "name", "type": ["null", "string"]},
+		{"name": "price", "type": ["null", "double"]}
+	]}`)
+
+	_, err = LoadAvroExpect(db, schema, changed, bytes.NewReader(data))
+	if !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("LoadAvroExpect() error = %v, want %v", err, ErrSchemaMismatch)
+	}
+	rows, err := LoadData(db, "items")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	if want := []map[string]any{{"id": int64(1), "name": "old"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("LoadData() after a mismatch = %v, want %v", rows, want)
+	}
+
+	got, err := LoadAvroExpect(db, schema, expected, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("LoadAvroExpect() error = %v", err)
+	}
+	if got != 1 {
+		t.Errorf("LoadAvroExpect() = %v, want %v", got, 1)
+	}
+}
+
+// writeOCFFile writes records to an OCF file in dir using the given schema and returns its path.
+func writeOCFFile(t *testing.T, dir, name, schema string, records []map[string]any) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("os.Create() error = %v", err)
+	}
+	defer f.Close()
+	enc, err := ocf.NewEncoder(schema, f)
+	if err != nil {
+		t.Fatalf("ocf.NewEncoder() error = %v", err)
+	}
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return path
+}
+
+func TestOCFFilesToTable(t *testing.T) {
+	dir := t.TempDir()
+	day1 := writeOCFFile(t, dir, "day1.avro", `{"type": "record", "name": "events", "fields": [
+		{"name": "id", "type": "int"},
+		{"name": "name", "type": "string"}
+	]}`, []map[string]any{{"id": 1, "name": "open"}})
+	day2 := writeOCFFile(t, dir, "day2.avro", `{"type": "record", "name": "events", "fields": [
+		{"name": "id", "type": "long"},
+		{"name": "name", "type": "string"},
+		{"name": "score", "type": ["null", "double"], "default": null}
+	]}`, []map[string]any{{"id": int64(2), "name": "close", "score": 1.5}})
+	broken := writeOCFFile(t, dir, "broken.avro", `{"type": "record", "name": "events", "fields": [
+		{"name": "id", "type": "string"}
+	]}`, []map[string]any{{"id": "3"}})
+
+	db := newTestDB(t)
+	got, err := OCFFilesToTable(db, []string{day1, day2}, "")
+	if err != nil {
+		t.Fatalf("OCFFilesToTable() error = %v", err)
+	}
+	if got != 2 {
+		t.Errorf("OCFFilesToTable() = %v, want %v", got, 2)
+	}
+	rows, err := LoadData(db, "events")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	want := []map[string]any{
+		{"id": int64(1), "name": "open", "score": nil},
+		{"id": int64(2), "name": "close", "score": 1.5},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("LoadData() = %v, want %v", rows, want)
+	}
+
+	// an explicit reader schema drops the fields it does not have
+	readerSchema := avro.MustParse(`{"type": "record", "name": "events", "fields": [{"name": "name", "type": "string"}]}`)
+	if _, err := OCFFilesToTableWithSchema(db, []string{day1, day2}, "names", readerSchema); err != nil {
+		t.Fatalf("OCFFilesToTableWithSchema() error = %v", err)
+	}
+	rows, err = LoadData(db, "names")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	if want := []map[string]any{{"name": "open"}, {"name": "close"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("LoadData() = %v, want %v", rows, want)
+	}
+
+	_, err = OCFFilesToTable(db, []string{day1, broken, day2}, "")
+	if err == nil || !strings.Contains(err.Error(), broken) {
+		t.Errorf("OCFFilesToTable() error = %v, want it to name %s", err, broken)
+	}
+}
+
+func TestCreateTableFromAvscFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "people.avsc")
+	avsc := `{
+		"type": "record",
+		"name": "people",
+		"fields": [
+			{"name": "id", "type": "long"},
+			{"name": "name", "type": ["null", "string"]},
+			{"name": "country", "type": "string", "default": "NZ"}
+		]
+	}`
+	if err := os.WriteFile(path, []byte(avsc), 0o644); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+
+	db := newTestDB(t)
+	schema, err := CreateTableFromAvscFile(db, path)
+	if err != nil {
+		t.Fatalf("CreateTableFromAvscFile() error = %v", err)
+	}
+	if schema.Table != "people" {
+		t.Errorf("CreateTableFromAvscFile() table = %v, want %v", schema.Table, "people")
+	}
+
+	if _, err := db.Exec("INSERT INTO people (id) VALUES (1)"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	got, err := LoadData(db, "people")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	want := []map[string]any{{"id": int64(1), "name": nil, "country": "NZ"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadData() = %v, want %v", got, want)
+	}
+
+	read, err := ReadSchema(db, "people")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	nullable := map[string]bool{}
+	for _, f := range read.Fields {
+		nullable[f.Name] = f.Nullable
+	}
+	if want := map[string]bool{"id": false, "name": true, "country": false}; !reflect.DeepEqual(nullable, want) {
+		t.Errorf("ReadSchema() nullable = %v, want %v", nullable, want)
+	}
+
+	if _, err := CreateTableFromAvscFile(db, path); err == nil {
+		t.Error("CreateTableFromAvscFile() of an existing table error = nil")
+	}
+}
+
+func TestLoadAvroWithOptions_Flatten(t *testing.T) {
+	writerSchema := avro.MustParse(`{
+		"type": "record",
+		"name": "customers",
+		"namespace": "shop",
+		"fields": [
+			{"name": "id", "type": "long"},
+			{"name": "address", "type": ["null", {
+				"type": "record",
+				"name": "Address",
+				"fields": [
+					{"name": "street", "type": "string"},
+					{"name": "city", "type": "string"}
+				]
+			}]}
+		]
+	}`)
+	var buf bytes.Buffer
+	enc := avro.NewEncoderForSchema(writerSchema, &buf)
+	records := []map[string]any{
+		{"id": int64(1), "address": map[string]any{"shop.Address": map[string]any{"street": "1 Main St", "city": "Springfield"}}},
+		{"id": int64(2), "address": nil},
+	}
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+	}
+
+	schema := &SqliteSchema{
+		Table: "customers",
+		Sql:   "CREATE TABLE customers (id INTEGER, address_street TEXT, address_city TEXT)",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger, Nullable: true},
+			{Name: "address_street", Type: SqliteText, Nullable: true},
+			{Name: "address_city", Type: SqliteText, Nullable: true},
+		},
+	}
+	db := newTestDB(t)
+	got, err := LoadAvroWithOptions(db, schema, &buf, LoadOptions{WriterSchema: writerSchema, Flatten: true})
+	if err != nil {
+		t.Fatalf("LoadAvroWithOptions() error = %v", err)
+	}
+	if got != 2 {
+		t.Errorf("LoadAvroWithOptions() = %v, want %v", got, 2)
+	}
+
+	rows, err := LoadData(db, "customers")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	want := []map[string]any{
+		{"id": int64(1), "address_street": "1 Main St", "address_city": "Springfield"},
+		{"id": int64(2), "address_street": nil, "address_city": nil},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("LoadData() = %v, want %v", rows, want)
+	}
+}
+
+func TestLoadAvro_SqlMismatch(t *testing.T) {
+	schema := &SqliteSchema{
+		Table: "items",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger, Nullable: true},
+			{Name: "name", Type: SqliteText, Nullable: true},
+		},
+	}
+	data := encodeAvro(t, schema, []map[string]any{{"id": int64(1), "name": "a"}})
+
+	tests := []struct {
+		name    string
+		sql     string
+		wantErr error
+	}{
+		{name: "consistent", sql: "CREATE TABLE items (id INTEGER, NAME TEXT)"},
+		{name: "missing column", sql: "CREATE TABLE items (id INTEGER, title TEXT)", wantErr: ErrSqlMismatch},
+		{name: "extra column", sql: "CREATE TABLE items (id INTEGER, name TEXT, price REAL NOT NULL)", wantErr: ErrSqlMismatch},
+		{name: "other table", sql: "CREATE TABLE products (id INTEGER, name TEXT)", wantErr: ErrSqlMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			s := *schema
+			s.Sql = tt.sql
+			_, err := LoadAvro(db, &s, bytes.NewReader(data))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("LoadAvro() error = %v, want %v", err, tt.wantErr)
+			}
+			if exists, err := tableExists(db, "items"); err != nil || exists != (tt.wantErr == nil) {
+				t.Errorf("tableExists() = %v, %v, want %v", exists, err, tt.wantErr == nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package avrosqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/hamba/avro"
+)
+
+// DetectBigIntegers finds the INTEGER columns of a table holding integers that do
+// not fit in an int64. SQLite stores such values as TEXT or BLOB when they are
+// written as such, and as REAL when the INTEGER affinity of the column converts
+// them, in which case their digits beyond the precision of a REAL are already lost.
+// https://www.sqlite.org/datatype3.html#type_affinity
+func DetectBigIntegers(db *sql.DB, table string) ([]string, error) {
+	schema, err := ReadSchema(db, table)
+	if err != nil {
+		return nil, err
+	}
+	found, err := detectBigIntegers(db, schema)
+	if err != nil {
+		return nil, err
+	}
+	columns := []string{}
+	for _, f := range schema.Fields {
+		if found[f.Name] {
+			columns = append(columns, f.Name)
+		}
+	}
+	return columns, nil
+}
+
+// detectBigIntegers finds the big integer columns of the table described by schema.
+// See DetectBigIntegers.
+func detectBigIntegers(q Querier, schema *SqliteSchema) (map[string]bool, error) {
+	found := map[string]bool{}
+	for _, f := range schema.Fields {
+		if f.Type != SqliteInteger || f.Generated {
+			continue
+		}
+		column := quoteIdentifier(f.Name)
+		rows, err := q.Query(fmt.Sprintf("SELECT %s FROM %s WHERE typeof(%s) IN ('real', 'text', 'blob')",
+			column, quoteTableName(schema.Table), column))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var v any
+			if err := rows.Scan(&v); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if _, ok := bigIntegerString(v); ok {
+				found[f.Name] = true
+				break
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// minInt64Float and maxInt64Float bound the float64 values that convert to an int64.
+const (
+	minInt64Float = -(1 << 63)
+	maxInt64Float = 1 << 63
+)
+
+// bigIntegerString returns the decimal digits of an integer out of the range of
+// int64, held as a string, a []byte, a float64 or a uint64. It reports false for
+// any other value.
+func bigIntegerString(v any) (string, bool) {
+	var s string
+	switch n := v.(type) {
+	case string:
+		s = n
+	case []byte:
+		s = string(n)
+	case uint64:
+		if n <= math.MaxInt64 {
+			return "", false
+		}
+		return new(big.Int).SetUint64(n).String(), true
+	case float64:
+		if math.IsInf(n, 0) || math.IsNaN(n) || n != math.Trunc(n) || (n >= minInt64Float && n < maxInt64Float) {
+			return "", false
+		}
+		i, _ := big.NewFloat(n).Int(nil)
+		return i.String(), true
+	default:
+		return "", false
+	}
+
+	i, ok := new(big.Int).SetString(strings.TrimSpace(s), 10)
+	if !ok || i.IsInt64() {
+		return "", false
+	}
+	return i.String(), true
+}
+
+// convertBigIntegers converts the values of the big integer columns of row: integers
+// out of the range of int64 to their decimal digits and the others to int64.
+func convertBigIntegers(columns map[string]bool, row map[string]any) {
+	for column := range columns {
+		v, ok := row[column]
+		if !ok {
+			continue
+		}
+		if s, ok := bigIntegerString(v); ok {
+			row[column] = s
+		} else {
+			row[column] = toInt64(v)
+		}
+	}
+}
+
+// bigIntegerTypeMapper is a TypeMapper that exports big integer columns as a
+// union of a long and a string, which holds the integers out of the range of a long.
+type bigIntegerTypeMapper struct {
+	mapper  TypeMapper
+	columns map[string]bool
+}
+
+func (m bigIntegerTypeMapper) ToAvro(field SchemaField) (avro.Schema, error) {
+	if !m.columns[field.Name] {
+		return m.mapper.ToAvro(field)
+	}
+
+	schemas := []avro.Schema{}
+	if field.Nullable {
+		schemas = append(schemas, nullSchema)
+	}
+	schemas = append(schemas, avro.NewPrimitiveSchema(avro.Long, nil), avro.NewPrimitiveSchema(avro.String, nil))
+	return avro.NewUnionSchema(schemas)
+}
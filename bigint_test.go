@@ -0,0 +1,103 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+// newBigIntegerTestDB returns a database with an INTEGER column holding integers
+// beyond the range of int64 stored as text in a BLOB, and as REAL.
+func newBigIntegerTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE ids (id INTEGER, n INTEGER NOT NULL DEFAULT 0);
+		INSERT INTO ids VALUES (1, 5), (2, CAST('18446744073709551616' AS BLOB)),
+			(3, '-99999999999999999999'), (4, x'2D3932323333373230333638353437373538303920');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	return db
+}
+
+func TestDetectBigIntegers(t *testing.T) {
+	db := newBigIntegerTestDB(t)
+	got, err := DetectBigIntegers(db, "ids")
+	if err != nil {
+		t.Fatalf("DetectBigIntegers() error = %v", err)
+	}
+	if want := []string{"n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DetectBigIntegers() = %v, want %v", got, want)
+	}
+
+	got, err = DetectBigIntegers(testDB, "foo")
+	if err != nil {
+		t.Fatalf("DetectBigIntegers() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("DetectBigIntegers() = %v, want none", got)
+	}
+}
+
+func TestTableToOCFWriterWithOptions_BigIntegers(t *testing.T) {
+	db := newBigIntegerTestDB(t)
+
+	var buf bytes.Buffer
+	if err := TableToOCFWriterWithOptions(db, "ids", &buf, ExportOptions{}); err == nil {
+		t.Error("TableToOCFWriterWithOptions() without BigIntegers error = nil")
+	}
+
+	buf.Reset()
+	if err := TableToOCFWriterWithOptions(db, "ids", &buf, ExportOptions{BigIntegers: true}); err != nil {
+		t.Fatalf("TableToOCFWriterWithOptions() error = %v", err)
+	}
+	got := readOCFValues(t, buf.Bytes(), "n")
+	want := []any{int64(5), "18446744073709551616", "-100000000000000000000", "-9223372036854775809"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TableToOCFWriterWithOptions() values = %#v, want %#v", got, want)
+	}
+}
+
+func TestLoadDataWithOptions_BigIntegers(t *testing.T) {
+	db := newBigIntegerTestDB(t)
+	data, err := LoadDataWithOptions(db, "ids", DataOptions{BigIntegers: true})
+	if err != nil {
+		t.Fatalf("LoadDataWithOptions() error = %v", err)
+	}
+	got := []any{}
+	for _, row := range data {
+		got = append(got, row["n"])
+	}
+	want := []any{int64(5), "18446744073709551616", "-100000000000000000000", "-9223372036854775809"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadDataWithOptions() values = %#v, want %#v", got, want)
+	}
+}
+
+func Test_bigIntegerString(t *testing.T) {
+	tests := []struct {
+		name   string
+		v      any
+		want   string
+		wantOK bool
+	}{
+		{name: "big text", v: " 9223372036854775808 ", want: "9223372036854775808", wantOK: true},
+		{name: "big negative blob", v: []byte("-9223372036854775809"), want: "-9223372036854775809", wantOK: true},
+		{name: "big real", v: 1e19, want: "10000000000000000000", wantOK: true},
+		{name: "big uint64", v: uint64(1 << 63), want: "9223372036854775808", wantOK: true},
+		{name: "int64 text", v: "9223372036854775807"},
+		{name: "int64 real", v: 1e18},
+		{name: "not a number", v: "King"},
+		{name: "int64", v: int64(1)},
+		{name: "nil", v: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := bigIntegerString(tt.v)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("bigIntegerString(%v) = %q, %v, want %q, %v", tt.v, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package avrosqlite
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ExternalBlobProp is the property of the fields of BLOB columns externalized by
+// ExportOptions.ExternalizeBlobs, see SchemaField.Props. It marks the strings of
+// those fields as references to the files holding the BLOB values.
+const ExternalBlobProp = "external_blob"
+
+// ExternalBlobs writes the values of BLOB columns to separate files instead of
+// inlining them in the OCF, see ExportOptions.ExternalizeBlobs.
+type ExternalBlobs struct {
+	// Dir is the directory the files are written to. It must exist.
+	Dir string
+	// Name returns the name of the file, relative to Dir, holding the value of
+	// a column in the index-th row of a table. It must return distinct names for
+	// distinct values. If nil, files are named table.column.index.bin.
+	Name func(table, column string, index int64) string
+}
+
+// fileName returns the path of the file holding the value of a column in the
+// index-th row of a table.
+func (b *ExternalBlobs) fileName(table, column string, index int64) string {
+	name := fmt.Sprintf("%s.%s.%d.bin", table, column, index)
+	if b.Name != nil {
+		name = b.Name(table, column, index)
+	}
+	return filepath.Join(b.Dir, name)
+}
+
+// applySchema changes the BLOB columns of schema to TEXT columns marked with
+// ExternalBlobProp and returns their names. It does nothing if b is nil.
+func (b *ExternalBlobs) applySchema(schema *SqliteSchema) map[string]bool {
+	if b == nil {
+		return nil
+	}
+	columns := map[string]bool{}
+	for i, f := range schema.Fields {
+		if f.Type != SqliteBlob {
+			continue
+		}
+		props := map[string]any{ExternalBlobProp: true}
+		for key, value := range f.Props {
+			props[key] = value
+		}
+		schema.Fields[i].Type = SqliteText
+		// the default of a BLOB column has no file to refer to
+		schema.Fields[i].Default = nil
+		schema.Fields[i].Props = props
+		columns[f.Name] = true
+	}
+	return columns
+}
+
+// writeRow writes the values of the externalized columns of row, the index-th
+// row of table, to their files and replaces them with the paths of the files.
+// NULL values are left as they are.
+func (b *ExternalBlobs) writeRow(table string, columns map[string]bool, index int64, row map[string]any) error {
+	for column := range columns {
+		v := row[column]
+		if v == nil {
+			continue
+		}
+		data, err := coerceValue(SqliteBlob, v)
+		if err != nil {
+			return fmt.Errorf("failed to externalize column %s: [%w]", column, err)
+		}
+		fileName := b.fileName(table, column, index)
+		if err := os.WriteFile(fileName, data.([]byte), 0644); err != nil {
+			return fmt.Errorf("failed to externalize column %s: [%w]", column, err)
+		}
+		row[column] = fileName
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package avrosqlite
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// bundleTables exports tables into a single gzipped tar archive at opts.BundlePath
+// and returns the path of the archive. Each table is buffered in memory before
+// it is written to the archive, since tar entries must declare their size up front.
+func bundleTables(q Querier, tables []string, savePath string, opts ExportOptions) ([]string, error) {
+	archivePath := opts.BundlePath
+	if !filepath.IsAbs(archivePath) {
+		archivePath = filepath.Join(savePath, archivePath)
+	}
+
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	manifest := &Manifest{Tables: []ManifestTable{}}
+	failed := map[string]error{}
+	for _, table := range tables {
+		// a table is exported to memory first, so a failed table leaves no entries behind
+		var buf bytes.Buffer
+		stats, err := tableToOCF(q, table, &buf, opts)
+		var schemaJSON []byte
+		if err == nil && opts.IncludeJSON {
+			schemaJSON, err = tableSchemaJSON(q, table, opts)
+		}
+		if err != nil {
+			if !opts.ContinueOnError {
+				return nil, err
+			}
+			failed[table] = err
+			continue
+		}
+
+		stats.Bytes = int64(buf.Len())
+		stats.SHA256 = sha256.Sum256(buf.Bytes())
+		if err := writeTarEntry(tw, ocfFileName(opts.Prefix, table), buf.Bytes()); err != nil {
+			return nil, err
+		}
+
+		jsonFile := ""
+		if opts.IncludeJSON {
+			jsonFile = jsonFileName(opts.Prefix, table)
+			if err := writeTarEntry(tw, jsonFile, schemaJSON); err != nil {
+				return nil, err
+			}
+		}
+		manifest.add(table, ocfFileName(opts.Prefix, table), jsonFile, stats)
+	}
+
+	if opts.Manifest {
+		b, err := manifest.marshal()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeTarEntry(tw, ManifestFileName, b); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+
+	if len(failed) > 0 {
+		return []string{archivePath}, &ExportError{Tables: failed}
+	}
+	return []string{archivePath}, nil
+}
+
+// writeTarEntry writes data as a regular file named name to tw.
+func writeTarEntry(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
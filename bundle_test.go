@@ -0,0 +1,81 @@
+package avrosqlite
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSqliteToAvroWithOptions_Bundle(t *testing.T) {
+	dir := t.TempDir()
+	files, err := SqliteToAvroWithOptions(testDB, dir, ExportOptions{
+		Prefix:      "test_",
+		IncludeJSON: true,
+		BundlePath:  "export.tar.gz",
+	})
+	if err != nil {
+		t.Fatalf("SqliteToAvroWithOptions() error = %v", err)
+	}
+	wantPath := filepath.Join(dir, "export.tar.gz")
+	if !reflect.DeepEqual(files, []string{wantPath}) {
+		t.Fatalf("SqliteToAvroWithOptions() = %v, want %v", files, []string{wantPath})
+	}
+
+	f, err := os.Open(wantPath)
+	if err != nil {
+		t.Fatalf("os.Open() error = %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	tr := tar.NewReader(gz)
+
+	entries := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar.Reader.Next() error = %v", err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("io.ReadAll() error = %v", err)
+		}
+		entries[hdr.Name] = b
+	}
+
+	for _, name := range []string{"test_foo.avro", "test_foo.json", "test_meats.avro", "test_meats.json"} {
+		if _, ok := entries[name]; !ok {
+			t.Errorf("archive entries = %v, missing %v", len(entries), name)
+		}
+	}
+	if len(entries) != 4 {
+		t.Errorf("archive has %v entries, want %v", len(entries), 4)
+	}
+
+	schema := &SqliteSchema{}
+	if err := json.Unmarshal(entries["test_foo.json"], schema); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if schema.Table != "foo" {
+		t.Errorf("schema.Table = %v, want %v", schema.Table, "foo")
+	}
+
+	db := newTestDB(t)
+	count, err := OCFBytesToTable(db, entries["test_meats.avro"], "meats")
+	if err != nil {
+		t.Fatalf("OCFBytesToTable() error = %v", err)
+	}
+	if count != 3 {
+		t.Errorf("OCFBytesToTable() = %v, want %v", count, 3)
+	}
+}
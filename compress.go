@@ -0,0 +1,50 @@
+package avrosqlite
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Magic numbers of the compressed formats detected by the loaders.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ErrZstdUnsupported is returned when a loader is given zstd compressed input
+// without a LoadOptions.Decompress function able to read it. The standard
+// library has no zstd decoder; one can be plugged in with LoadOptions.Decompress,
+// for example using github.com/klauspost/compress/zstd.
+var ErrZstdUnsupported = errors.New("zstd compressed input requires LoadOptions.Decompress")
+
+// decompress returns a reader of the decompressed content of r. If fn is not nil
+// it is used as is, otherwise the compression is detected from the first bytes
+// of r: gzip input is decompressed, zstd input is an ErrZstdUnsupported error
+// and other input is returned unchanged.
+func decompress(r io.Reader, fn func(io.Reader) (io.Reader, error)) (io.Reader, error) {
+	if fn != nil {
+		return fn(r)
+	}
+
+	br := bufio.NewReader(r)
+	// a short input is not compressed, Peek returns what there is
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip input: [%w]", err)
+		}
+		return zr, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return nil, ErrZstdUnsupported
+	}
+	return br, nil
+}
@@ -0,0 +1,90 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+)
+
+// gzipBytes returns data compressed with gzip.
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("gzip.Write() error = %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip.Close() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestOCFToTable_Compressed(t *testing.T) {
+	data, err := TableToOCFBytes(testDB, "foo", nil)
+	if err != nil {
+		t.Fatalf("TableToOCFBytes() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    int64
+		wantErr error
+	}{
+		{name: "uncompressed", data: data, want: 3},
+		{name: "gzip", data: gzipBytes(t, data), want: 3},
+		{name: "zstd", data: append(append([]byte{}, zstdMagic...), data...), wantErr: ErrZstdUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OCFToTable(newTestDB(t), bytes.NewReader(tt.data), "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OCFToTable() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("OCFToTable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadAvroWithOptions_Decompress(t *testing.T) {
+	schema, err := ReadSchema(testDB, "meats")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	data := encodeAvro(t, schema, []map[string]any{
+		{"id": int64(1), "name": "beef", "description": "a cow"},
+	})
+	// a stand-in for a zstd decoder, reading frames made of the magic number and the data
+	fakeZstd := func(r io.Reader) (io.Reader, error) {
+		magic := make([]byte, len(zstdMagic))
+		if _, err := io.ReadFull(r, magic); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		opts LoadOptions
+	}{
+		{name: "gzip", data: gzipBytes(t, data)},
+		{name: "zstd", data: append(append([]byte{}, zstdMagic...), data...), opts: LoadOptions{Decompress: fakeZstd}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadAvroWithOptions(newTestDB(t), schema, bytes.NewReader(tt.data), tt.opts)
+			if err != nil {
+				t.Fatalf("LoadAvroWithOptions() error = %v", err)
+			}
+			if got != 1 {
+				t.Errorf("LoadAvroWithOptions() = %v, want %v", got, 1)
+			}
+		})
+	}
+}
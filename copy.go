@@ -0,0 +1,131 @@
+package avrosqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+)
+
+// CopyTable copies a table from one SQLite database to another without intermediate files.
+//
+// Parameters:
+//   - src: A pointer to the sql.DB the table is read from.
+//   - dst: A pointer to the sql.DB the table is written to.
+//   - table: The name of the table to copy.
+//   - enhancer: An Enhancer interface for modifying the schema and data (can be nil).
+//
+// Returns:
+//   - int64: The number of rows copied.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// Rows are streamed from src into dst one at a time, so memory use does not grow with
+// the size of the table. As with LoadAvro, the table is created in dst if it does not
+// exist and truncated if it does. src and dst must be different databases, since src
+// is read while dst is written in a transaction.
+func CopyTable(src, dst *sql.DB, table string, enhancer Enhancer) (int64, error) {
+	if enhancer == nil {
+		enhancer = &noopEnhancer{}
+	}
+
+	schema, err := ReadSchema(src, table)
+	if err != nil {
+		return 0, err
+	}
+	err = enhancer.Schema(schema)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := src.Query(fmt.Sprintf("SELECT * FROM %s", quoteTableName(table)))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	reader, err := newRowReader(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := loadRecords(dst, schema, &rowRecordDecoder{reader: reader, enhancer: enhancer}, LoadOptions{})
+	return result.Inserted, err
+}
+
+// rowReader reads the rows of a query one at a time as maps keyed by column name.
+// The buffers the rows are scanned into are allocated once and reused for every row.
+type rowReader struct {
+	rows      *sql.Rows
+	columns   []string
+	values    []any
+	valuePtrs []any
+}
+
+func newRowReader(rows *sql.Rows) (*rowReader, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	r := &rowReader{
+		rows:      rows,
+		columns:   columns,
+		values:    make([]any, len(columns)),
+		valuePtrs: make([]any, len(columns)),
+	}
+	for i := range r.values {
+		r.valuePtrs[i] = &r.values[i]
+	}
+	return r, nil
+}
+
+// Next returns the next row, or io.EOF when there are no more rows.
+func (r *rowReader) Next() (map[string]any, error) {
+	row := make(map[string]any, len(r.columns))
+	if err := r.nextInto(row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// nextInto reads the next row into row, which must be empty, or returns io.EOF
+// when there are no more rows.
+func (r *rowReader) nextInto(row map[string]any) error {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+
+	// Scan copies bytes into values, so they are not overwritten by the next row
+	if err := r.rows.Scan(r.valuePtrs...); err != nil {
+		return err
+	}
+	for i, col := range r.columns {
+		row[col] = r.values[i]
+	}
+	return nil
+}
+
+// rowRecordDecoder adapts a rowReader to the recordDecoder interface,
+// applying the enhancer to each row.
+type rowRecordDecoder struct {
+	reader   *rowReader
+	enhancer Enhancer
+}
+
+func (d *rowRecordDecoder) Decode(v any) error {
+	row, err := d.reader.Next()
+	if err != nil {
+		return err
+	}
+	if err := d.enhancer.Row(row); err != nil {
+		return err
+	}
+
+	out, ok := v.(*map[string]any)
+	if !ok {
+		return fmt.Errorf("cannot decode record into %T", v)
+	}
+	*out = row
+	return nil
+}
@@ -0,0 +1,51 @@
+package avrosqlite
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestCopyTable(t *testing.T) {
+	dst, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	// every connection to :memory: is a separate database
+	dst.SetMaxOpenConns(1)
+	defer dst.Close()
+
+	for i := 0; i < 2; i++ {
+		count, err := CopyTable(testDB, dst, "meats", nil)
+		if err != nil {
+			t.Fatalf("CopyTable() error = %v", err)
+		}
+		if count != 3 {
+			t.Errorf("CopyTable() = %v, want %v", count, 3)
+		}
+	}
+
+	want, err := LoadData(testDB, "meats")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	got, err := LoadData(dst, "meats")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadData() = %v, want %v", got, want)
+	}
+
+	wantSchema, err := ReadSchema(testDB, "meats")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	gotSchema, err := ReadSchema(dst, "meats")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	if !reflect.DeepEqual(gotSchema, wantSchema) {
+		t.Errorf("ReadSchema() = %v, want %v", gotSchema, wantSchema)
+	}
+}
@@ -0,0 +1,529 @@
+package avrosqlite
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GenerateOptions controls the CREATE TABLE statement built by GenerateSQLWithOptions.
+type GenerateOptions struct {
+	// Strict creates a STRICT table (SQLite 3.37+). Column types are restricted
+	// to INTEGER, REAL, TEXT, BLOB and ANY, and values of the wrong type are
+	// rejected on insert instead of being coerced.
+	Strict bool
+}
+
+// sqliteStrictTypes maps sqlite types to the types allowed in a STRICT table.
+var sqliteStrictTypes = map[SqliteType]string{
+	SqliteNull:      "ANY",
+	SqliteInteger:   "INTEGER",
+	SqliteReal:      "REAL",
+	SqliteText:      "TEXT",
+	SqliteBlob:      "BLOB",
+	SqliteBoolean:   "INTEGER",
+	SqliteDate:      "TEXT",
+	SqliteDatetime:  "TEXT",
+	SqliteTimestamp: "TEXT",
+	SqliteAny:       "ANY",
+}
+
+// GenerateSQL builds a CREATE TABLE statement from the fields of the schema.
+//
+// Unlike Sql, which holds the DDL read from the database, the generated
+// statement only describes what is known from Fields. It is used when a
+// schema did not come from SQLite, for example one converted from Avro.
+// The statement of a virtual table cannot be built from its fields, so Sql
+// is returned as is for them.
+func (s *SqliteSchema) GenerateSQL() (string, error) {
+	return s.GenerateSQLWithOptions(GenerateOptions{})
+}
+
+// ErrSqlMismatch is returned, wrapped with the differences, when the Sql of a
+// schema does not create its table with the columns of its fields.
+var ErrSqlMismatch = errors.New("schema SQL does not match its fields")
+
+// checkSql returns an error wrapping ErrSqlMismatch if Sql does not create the
+// table of the schema with a column for each of its fields and no other. A
+// rowid field is held by the implicit rowid of a table that has one.
+// Rather than parsing the statement, it is run by SQLite in an in-memory
+// database. The columns of a virtual table depend on its module, which may not
+// be available there, so they are not checked.
+func (s *SqliteSchema) checkSql() error {
+	if s.Virtual {
+		return nil
+	}
+	scratch, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return err
+	}
+	defer scratch.Close()
+	// every connection to :memory: is a separate database
+	scratch.SetMaxOpenConns(1)
+
+	if _, err := scratch.Exec(s.Sql); err != nil {
+		return fmt.Errorf("failed to run the SQL of table %s: [%w]", s.Table, err)
+	}
+	exists, err := tableExists(scratch, s.Table)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: it does not create table %s", ErrSqlMismatch, s.Table)
+	}
+	created, err := ReadSchema(scratch, s.Table)
+	if err != nil {
+		return err
+	}
+
+	missing, extra := []string{}, []string{}
+	for _, f := range s.Fields {
+		if created.field(f.Name) != nil {
+			continue
+		}
+		// the rowid field added by ExportOptions.IncludeRowID is loaded into the implicit rowid
+		if strings.EqualFold(f.Name, rowIDColumn) && !s.WithoutRowID {
+			continue
+		}
+		missing = append(missing, f.Name)
+	}
+	for _, f := range created.Fields {
+		if s.field(f.Name) == nil {
+			extra = append(extra, f.Name)
+		}
+	}
+	if len(missing) > 0 || len(extra) > 0 {
+		return fmt.Errorf("%w: table %s is created without columns %v and with columns %v that are not fields", ErrSqlMismatch, s.Table, missing, extra)
+	}
+	return nil
+}
+
+// GenerateSQLWithOptions builds a CREATE TABLE statement from the fields of the schema
+// using the given options. See GenerateSQL.
+func (s *SqliteSchema) GenerateSQLWithOptions(opts GenerateOptions) (string, error) {
+	// the arguments of a virtual table depend on its module, not on its columns
+	if s.Virtual {
+		if s.Sql == "" {
+			return "", fmt.Errorf("virtual table %s has no CREATE VIRTUAL TABLE statement", s.Table)
+		}
+		return s.Sql, nil
+	}
+
+	primaryKey := s.primaryKey()
+	// a single INTEGER primary key is declared inline, as it was most likely
+	// created, so that it stays an alias of the rowid
+	inlineKey := ""
+	if len(primaryKey) == 1 && !s.WithoutRowID {
+		for _, f := range s.Fields {
+			if f.Name == primaryKey[0] && f.Type == SqliteInteger {
+				inlineKey = f.Name
+			}
+		}
+	}
+
+	columns := []string{}
+	for _, f := range s.Fields {
+		def := f.columnDefinition(opts)
+		if f.Name == inlineKey {
+			def += " PRIMARY KEY"
+		}
+		columns = append(columns, def)
+	}
+
+	if len(primaryKey) > 0 && inlineKey == "" {
+		quoted := []string{}
+		for _, name := range primaryKey {
+			quoted = append(quoted, quoteIdentifier(name))
+		}
+		columns = append(columns, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(quoted, ", ")))
+	}
+	for _, check := range s.Checks {
+		columns = append(columns, fmt.Sprintf("CHECK (%s)", check))
+	}
+	for _, fk := range s.ForeignKeys {
+		columns = append(columns, fk.definition())
+	}
+
+	tableOptions := []string{}
+	if s.WithoutRowID {
+		if len(primaryKey) == 0 {
+			return "", fmt.Errorf("WITHOUT ROWID table %s requires a primary key", s.Table)
+		}
+		tableOptions = append(tableOptions, "WITHOUT ROWID")
+	}
+	if opts.Strict {
+		tableOptions = append(tableOptions, "STRICT")
+	}
+
+	ddl := fmt.Sprintf("CREATE TABLE %s (%s)", quoteIdentifier(s.Table), strings.Join(columns, ", "))
+	if len(tableOptions) > 0 {
+		ddl += " " + strings.Join(tableOptions, ", ")
+	}
+	return ddl, nil
+}
+
+// definition returns the table constraint of the foreign key for use in a CREATE TABLE statement.
+func (fk ForeignKey) definition() string {
+	def := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s", quoteIdentifiers(fk.Columns), quoteIdentifier(fk.ParentTable))
+	if len(fk.ParentColumns) > 0 {
+		def += fmt.Sprintf(" (%s)", quoteIdentifiers(fk.ParentColumns))
+	}
+	if fk.OnDelete != "" {
+		def += " ON DELETE " + fk.OnDelete
+	}
+	if fk.OnUpdate != "" {
+		def += " ON UPDATE " + fk.OnUpdate
+	}
+	return def
+}
+
+// primaryKey returns the names of the primary key columns in key order.
+func (s *SqliteSchema) primaryKey() []string {
+	fields := []SchemaField{}
+	for _, f := range s.Fields {
+		if f.PrimaryKey > 0 {
+			fields = append(fields, f)
+		}
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].PrimaryKey < fields[j].PrimaryKey
+	})
+
+	names := []string{}
+	for _, f := range fields {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// withoutRowIDPattern matches the WITHOUT ROWID table option in a CREATE TABLE statement.
+var withoutRowIDPattern = regexp.MustCompile(`(?i)\bWITHOUT\s+ROWID\b`)
+
+// virtualTablePattern matches a CREATE VIRTUAL TABLE statement.
+var virtualTablePattern = regexp.MustCompile(`(?i)^\s*CREATE\s+VIRTUAL\s+TABLE\b`)
+
+// columnDefinition returns the column definition of the field for use in a
+// CREATE TABLE statement.
+func (s SchemaField) columnDefinition(opts GenerateOptions) string {
+	def := quoteIdentifier(s.Name)
+	if opts.Strict {
+		t, ok := sqliteStrictTypes[s.Type]
+		if !ok {
+			t = "ANY"
+		}
+		def += " " + t
+	} else if s.Type != SqliteNull && s.Type != SqliteAny {
+		// ANY has NUMERIC affinity outside of STRICT tables, so the type is left
+		// out to store values as they are
+		def += " " + strings.ToUpper(string(s.Type))
+	}
+	if !s.Nullable && s.Type != SqliteNull {
+		def += " NOT NULL"
+	}
+	if literal, ok := sqlLiteral(s.Default); ok {
+		def += " DEFAULT " + literal
+	}
+	if s.Collation != "" {
+		def += " COLLATE " + s.Collation
+	}
+	if s.Check != "" {
+		def += fmt.Sprintf(" CHECK (%s)", s.Check)
+	}
+	return def
+}
+
+// quoteIdentifier quotes a table or column name for use in a SQL statement.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteIdentifiers quotes a list of names and joins them with commas.
+func quoteIdentifiers(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, quoteIdentifier(name))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// sqlLiteral formats a default value as a SQL literal.
+// It returns false if the value has no literal representation. A string that is
+// already a SQL string literal, as the TEXT defaults read by ReadSchema keep
+// their quotes, is returned as is rather than quoted again.
+func sqlLiteral(v any) (string, bool) {
+	switch v := v.(type) {
+	case int:
+		return strconv.Itoa(v), true
+	case int32:
+		return strconv.FormatInt(int64(v), 10), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case bool:
+		if v {
+			return "1", true
+		}
+		return "0", true
+	case string:
+		if isSQLStringLiteral(v) {
+			return v, true
+		}
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'", true
+	case []byte:
+		return "X'" + hex.EncodeToString(v) + "'", true
+	}
+	return "", false
+}
+
+// isSQLStringLiteral reports whether s is a quoted SQL string literal, such as
+// 'abc', whose inner quotes are all doubled.
+func isSQLStringLiteral(s string) bool {
+	if len(s) < 2 || s[0] != '\'' || s[len(s)-1] != '\'' {
+		return false
+	}
+	return !strings.Contains(strings.ReplaceAll(s[1:len(s)-1], "''", ""), "'")
+}
+
+// tableConstraintKeywords are the keywords that start a table constraint
+// rather than a column definition in a CREATE TABLE statement.
+var tableConstraintKeywords = []string{"CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN"}
+
+// parseChecks extracts the CHECK constraints from a CREATE TABLE statement.
+// It returns the expressions of the table constraints and of the column
+// constraints, the latter keyed by column name.
+func parseChecks(ddl string) ([]string, map[string]string) {
+	tableChecks := []string{}
+	columnChecks := map[string]string{}
+	for _, item := range parseCreateTable(ddl) {
+		i := findKeyword(item, "CHECK")
+		if i < 0 {
+			continue
+		}
+		expr, ok := parenthesized(item[i+len("CHECK"):])
+		if !ok {
+			continue
+		}
+		if isTableConstraint(item) {
+			tableChecks = append(tableChecks, expr)
+		} else {
+			columnChecks[definedColumnName(item)] = expr
+		}
+	}
+	return tableChecks, columnChecks
+}
+
+// parseCollations extracts the collating sequences of the columns of a CREATE TABLE
+// statement, keyed by column name. Columns using the default BINARY are left out.
+func parseCollations(ddl string) map[string]string {
+	collations := map[string]string{}
+	for _, item := range parseCreateTable(ddl) {
+		if isTableConstraint(item) {
+			continue
+		}
+		i := findKeyword(item, "COLLATE")
+		if i < 0 {
+			continue
+		}
+		name := definedColumnName(strings.TrimLeft(item[i+len("COLLATE"):], " \t\r\n"))
+		if name != "" && !strings.EqualFold(name, "BINARY") {
+			collations[definedColumnName(item)] = name
+		}
+	}
+	return collations
+}
+
+// parseCreateTable splits the body of a CREATE TABLE statement into its column
+// definitions and table constraints. Nested parentheses, quoted strings and
+// identifiers, and comments are kept intact.
+func parseCreateTable(ddl string) []string {
+	items := []string{}
+	depth := 0
+	start := -1
+	scanSQL(ddl, func(i int, c byte) bool {
+		switch c {
+		case '(':
+			depth++
+			if depth == 1 {
+				start = i + 1
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				items = append(items, trimSQLSpace(ddl[start:i]))
+				return false
+			}
+		case ',':
+			if depth == 1 {
+				items = append(items, trimSQLSpace(ddl[start:i]))
+				start = i + 1
+			}
+		}
+		return true
+	})
+	return items
+}
+
+// trimSQLSpace removes leading and trailing whitespace and leading comments from s.
+func trimSQLSpace(s string) string {
+	for {
+		s = strings.TrimSpace(s)
+		switch {
+		case strings.HasPrefix(s, "--"):
+			i := strings.IndexByte(s, '\n')
+			if i < 0 {
+				return ""
+			}
+			s = s[i+1:]
+		case strings.HasPrefix(s, "/*"):
+			i := strings.Index(s, "*/")
+			if i < 0 {
+				return ""
+			}
+			s = s[i+2:]
+		default:
+			return s
+		}
+	}
+}
+
+// scanSQL calls fn with every byte of s that is not part of a quoted string,
+// quoted identifier or comment, until fn returns false.
+func scanSQL(s string, fn func(i int, c byte) bool) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		var end string
+		switch {
+		case c == '\'', c == '"', c == '`':
+			end = string(c)
+		case c == '[':
+			end = "]"
+		case c == '-' && strings.HasPrefix(s[i:], "--"):
+			end = "\n"
+		case c == '/' && strings.HasPrefix(s[i:], "/*"):
+			end = "*/"
+		}
+		if end != "" {
+			j := strings.Index(s[i+1:], end)
+			if j < 0 {
+				return
+			}
+			i += j + len(end)
+			continue
+		}
+		if !fn(i, c) {
+			return
+		}
+	}
+}
+
+// findKeyword returns the index of the first occurrence of keyword in s that is
+// a whole word outside of parentheses, quotes and comments, or -1.
+func findKeyword(s, keyword string) int {
+	found := -1
+	depth := 0
+	scanSQL(s, func(i int, c byte) bool {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth != 0 || !strings.EqualFold(s[i:min(i+len(keyword), len(s))], keyword) {
+			return true
+		}
+		if i > 0 && isIdentifierByte(s[i-1]) {
+			return true
+		}
+		if end := i + len(keyword); end < len(s) && isIdentifierByte(s[end]) {
+			return true
+		}
+		found = i
+		return false
+	})
+	return found
+}
+
+// parenthesized returns the content of the parenthesized group at the start of s,
+// ignoring leading whitespace.
+func parenthesized(s string) (string, bool) {
+	s = strings.TrimLeft(s, " \t\r\n")
+	if !strings.HasPrefix(s, "(") {
+		return "", false
+	}
+	content := ""
+	found := false
+	depth := 0
+	scanSQL(s, func(i int, c byte) bool {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				content = strings.TrimSpace(s[1:i])
+				found = true
+				return false
+			}
+		}
+		return true
+	})
+	return content, found
+}
+
+// isTableConstraint reports whether an item of a CREATE TABLE body is a table constraint.
+func isTableConstraint(item string) bool {
+	for _, keyword := range tableConstraintKeywords {
+		if findKeyword(item, keyword) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// definedColumnName returns the unquoted name of the column defined by a column definition.
+func definedColumnName(def string) string {
+	if def == "" {
+		return ""
+	}
+	var end byte
+	switch def[0] {
+	case '"', '`', '\'':
+		end = def[0]
+	case '[':
+		end = ']'
+	}
+	if end != 0 {
+		name := ""
+		for i := 1; i < len(def); i++ {
+			if def[i] != end {
+				name += string(def[i])
+				continue
+			}
+			// a doubled quote is an escaped quote
+			if end != ']' && i+1 < len(def) && def[i+1] == end {
+				name += string(end)
+				i++
+				continue
+			}
+			break
+		}
+		return name
+	}
+	if i := strings.IndexAny(def, " \t\r\n("); i >= 0 {
+		return def[:i]
+	}
+	return def
+}
+
+// isIdentifierByte reports whether c can be part of an unquoted identifier.
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
@@ -0,0 +1,371 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSqliteSchema_GenerateSQLWithOptions(t *testing.T) {
+	schema := &SqliteSchema{
+		Table: "people",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger, Nullable: false, Default: int64(0)},
+			{Name: "name", Type: SqliteText, Nullable: true},
+			{Name: "active", Type: SqliteBoolean, Nullable: false, Default: true},
+		},
+	}
+	tests := []struct {
+		name string
+		opts GenerateOptions
+		want string
+	}{
+		{
+			name: "default",
+			opts: GenerateOptions{},
+			want: `CREATE TABLE "people" ("id" INTEGER NOT NULL DEFAULT 0, "name" TEXT, "active" BOOLEAN NOT NULL DEFAULT 1)`,
+		},
+		{
+			name: "strict",
+			opts: GenerateOptions{Strict: true},
+			want: `CREATE TABLE "people" ("id" INTEGER NOT NULL DEFAULT 0, "name" TEXT, "active" INTEGER NOT NULL DEFAULT 1) STRICT`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := schema.GenerateSQLWithOptions(tt.opts)
+			if err != nil {
+				t.Fatalf("SqliteSchema.GenerateSQLWithOptions() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("SqliteSchema.GenerateSQLWithOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSqliteSchema_GenerateSQLWithOptions_StrictRejectsMistypedInsert(t *testing.T) {
+	schema := &SqliteSchema{
+		Table: "strict_people",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger, Nullable: true},
+			{Name: "name", Type: SqliteText, Nullable: true},
+		},
+	}
+	tests := []struct {
+		name    string
+		strict  bool
+		wantErr bool
+	}{
+		{name: "strict", strict: true, wantErr: true},
+		{name: "not strict", strict: false, wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			ddl, err := schema.GenerateSQLWithOptions(GenerateOptions{Strict: tt.strict})
+			if err != nil {
+				t.Fatalf("SqliteSchema.GenerateSQLWithOptions() error = %v", err)
+			}
+			if _, err := db.Exec(ddl); err != nil {
+				t.Fatalf("db.Exec() error = %v", err)
+			}
+
+			_, err = db.Exec(`INSERT INTO strict_people (id, name) VALUES ('not a number', 'Amity Blight')`)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("db.Exec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSqliteSchema_GenerateSQL_WithoutRowID(t *testing.T) {
+	src := newTestDB(t)
+	_, err := src.Exec("CREATE TABLE settings (key TEXT PRIMARY KEY, value TEXT) WITHOUT ROWID")
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	_, err = src.Exec("INSERT INTO settings (key, value) VALUES ('theme', 'dark'), ('lang', 'en')")
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	schema, err := ReadSchema(src, "settings")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	if !schema.WithoutRowID {
+		t.Errorf("ReadSchema() WithoutRowID = %v, want %v", schema.WithoutRowID, true)
+	}
+	if schema.Fields[0].PrimaryKey != 1 || schema.Fields[0].Nullable {
+		t.Errorf("ReadSchema() Fields[0] = %+v, want a non-nullable primary key", schema.Fields[0])
+	}
+
+	// round trip the schema through JSON and regenerate the DDL from the fields
+	b, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	restored := &SqliteSchema{}
+	if err := json.Unmarshal(b, restored); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	restored.Sql, err = restored.GenerateSQL()
+	if err != nil {
+		t.Fatalf("SqliteSchema.GenerateSQL() error = %v", err)
+	}
+
+	rows, err := LoadData(src, "settings")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	dst := newTestDB(t)
+	count, err := LoadAvro(dst, restored, bytes.NewReader(encodeAvro(t, restored, rows)))
+	if err != nil {
+		t.Fatalf("LoadAvro() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("LoadAvro() = %v, want %v", count, 2)
+	}
+
+	got, err := ReadSchema(dst, "settings")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	if !got.WithoutRowID {
+		t.Errorf("ReadSchema() WithoutRowID = %v, want %v; sql = %v", got.WithoutRowID, true, got.Sql)
+	}
+	if _, err := dst.Exec("SELECT rowid FROM settings"); err == nil {
+		t.Errorf("SELECT rowid succeeded, want an error for a WITHOUT ROWID table")
+	}
+}
+
+func TestSqliteSchema_GenerateSQL_WithoutRowIDRequiresPrimaryKey(t *testing.T) {
+	schema := &SqliteSchema{
+		Table:        "settings",
+		Fields:       []SchemaField{{Name: "key", Type: SqliteText, Nullable: false}},
+		WithoutRowID: true,
+	}
+	if _, err := schema.GenerateSQL(); err == nil {
+		t.Errorf("SqliteSchema.GenerateSQL() error = %v, wantErr %v", err, true)
+	}
+}
+
+func TestReadSchema_Checks(t *testing.T) {
+	src := newTestDB(t)
+	_, err := src.Exec(`CREATE TABLE people (
+		id INTEGER PRIMARY KEY,
+		"name, full" TEXT, -- a comma, in a comment (
+		age INTEGER CHECK (age >= 0),
+		CONSTRAINT named CHECK (length("name, full") > 0)
+	)`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	schema, err := ReadSchema(src, "people")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	if got := schema.Fields[2].Check; got != "age >= 0" {
+		t.Errorf("ReadSchema() Fields[2].Check = %v, want %v", got, "age >= 0")
+	}
+	if want := []string{`length("name, full") > 0`}; !reflect.DeepEqual(schema.Checks, want) {
+		t.Errorf("ReadSchema() Checks = %v, want %v", schema.Checks, want)
+	}
+
+	// regenerate the table from the schema and confirm the checks are enforced
+	ddl, err := schema.GenerateSQL()
+	if err != nil {
+		t.Fatalf("SqliteSchema.GenerateSQL() error = %v", err)
+	}
+	dst := newTestDB(t)
+	if _, err := dst.Exec(ddl); err != nil {
+		t.Fatalf("db.Exec(%v) error = %v", ddl, err)
+	}
+	tests := []struct {
+		name    string
+		insert  string
+		wantErr bool
+	}{
+		{name: "valid", insert: `INSERT INTO people ("name, full", age) VALUES ('Eda', 40)`, wantErr: false},
+		{name: "column check", insert: `INSERT INTO people ("name, full", age) VALUES ('Eda', -1)`, wantErr: true},
+		{name: "table check", insert: `INSERT INTO people ("name, full", age) VALUES ('', 40)`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := dst.Exec(tt.insert)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("db.Exec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSqliteSchema_GenerateSQL_ForeignKeys(t *testing.T) {
+	src := newTestDB(t)
+	_, err := src.Exec(`CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE books (id INTEGER PRIMARY KEY, author_id INTEGER, title TEXT,
+			FOREIGN KEY (author_id) REFERENCES authors (id) ON DELETE CASCADE);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	want := []ForeignKey{{Columns: []string{"author_id"}, ParentTable: "authors", ParentColumns: []string{"id"}, OnDelete: "CASCADE"}}
+	schemas := map[string]*SqliteSchema{}
+	for _, table := range []string{"authors", "books"} {
+		schema, err := ReadSchema(src, table)
+		if err != nil {
+			t.Fatalf("ReadSchema() error = %v", err)
+		}
+		// round trip the schema through JSON and regenerate the DDL from the fields
+		b, err := json.Marshal(schema)
+		if err != nil {
+			t.Fatalf("json.Marshal() error = %v", err)
+		}
+		restored := &SqliteSchema{}
+		if err := json.Unmarshal(b, restored); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		restored.Sql, err = restored.GenerateSQL()
+		if err != nil {
+			t.Fatalf("SqliteSchema.GenerateSQL() error = %v", err)
+		}
+		schemas[table] = restored
+	}
+	if !reflect.DeepEqual(schemas["books"].ForeignKeys, want) {
+		t.Errorf("ReadSchema() ForeignKeys = %+v, want %+v", schemas["books"].ForeignKeys, want)
+	}
+	if schemas["authors"].ForeignKeys != nil {
+		t.Errorf("ReadSchema() ForeignKeys = %+v, want none", schemas["authors"].ForeignKeys)
+	}
+
+	dst := newTestDB(t)
+	dst.SetMaxOpenConns(1)
+	if _, err := dst.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	// parents are created before the tables referencing them
+	for _, table := range []string{"authors", "books"} {
+		if _, err := dst.Exec(schemas[table].Sql); err != nil {
+			t.Fatalf("db.Exec(%q) error = %v", schemas[table].Sql, err)
+		}
+	}
+	got, err := ReadSchema(dst, "books")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.ForeignKeys, want) {
+		t.Errorf("ReadSchema() ForeignKeys = %+v, want %+v", got.ForeignKeys, want)
+	}
+
+	if _, err := dst.Exec("INSERT INTO books (id, author_id, title) VALUES (1, 42, 'Orphan')"); err == nil {
+		t.Error("inserting a book of a missing author succeeded, want a foreign key error")
+	}
+}
+
+func TestSqliteSchema_GenerateSQL_Collation(t *testing.T) {
+	src := newTestDB(t)
+	_, err := src.Exec(`CREATE TABLE codes (code TEXT PRIMARY KEY COLLATE NOCASE, label "TEXT" COLLATE "RTRIM", note TEXT COLLATE BINARY)`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	schema, err := ReadSchema(src, "codes")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	collations := map[string]string{}
+	for _, f := range schema.Fields {
+		collations[f.Name] = f.Collation
+	}
+	if want := map[string]string{"code": "NOCASE", "label": "RTRIM", "note": ""}; !reflect.DeepEqual(collations, want) {
+		t.Errorf("ReadSchema() collations = %v, want %v", collations, want)
+	}
+
+	ddl, err := schema.GenerateSQL()
+	if err != nil {
+		t.Fatalf("SqliteSchema.GenerateSQL() error = %v", err)
+	}
+	dst := newTestDB(t)
+	if _, err := dst.Exec(ddl); err != nil {
+		t.Fatalf("db.Exec(%q) error = %v", ddl, err)
+	}
+	if _, err := dst.Exec("INSERT INTO codes (code) VALUES ('ABC')"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	if _, err := dst.Exec("INSERT INTO codes (code) VALUES ('abc')"); err == nil {
+		t.Error("inserting a code differing only in case succeeded, want a uniqueness error")
+	}
+}
+
+func TestSqliteSchema_GenerateSQL_PrimaryKeys(t *testing.T) {
+	src := newTestDB(t)
+	_, err := src.Exec(`CREATE TABLE post_tags (tag_id INTEGER, post_id INTEGER, PRIMARY KEY (post_id, tag_id));
+		CREATE TABLE posts (title TEXT, id INTEGER PRIMARY KEY);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	tests := []struct {
+		table   string
+		wantSQL string
+		wantPK  map[string]int
+	}{
+		{
+			table:   "post_tags",
+			wantSQL: `CREATE TABLE "post_tags" ("tag_id" INTEGER, "post_id" INTEGER, PRIMARY KEY ("post_id", "tag_id"))`,
+			wantPK:  map[string]int{"tag_id": 2, "post_id": 1},
+		},
+		{
+			table:   "posts",
+			wantSQL: `CREATE TABLE "posts" ("title" TEXT, "id" INTEGER PRIMARY KEY)`,
+			wantPK:  map[string]int{"title": 0, "id": 1},
+		},
+	}
+	dst := newTestDB(t)
+	for _, tt := range tests {
+		t.Run(tt.table, func(t *testing.T) {
+			schema, err := ReadSchema(src, tt.table)
+			if err != nil {
+				t.Fatalf("ReadSchema() error = %v", err)
+			}
+			got, err := schema.GenerateSQL()
+			if err != nil {
+				t.Fatalf("SqliteSchema.GenerateSQL() error = %v", err)
+			}
+			if got != tt.wantSQL {
+				t.Errorf("SqliteSchema.GenerateSQL() = %v, want %v", got, tt.wantSQL)
+			}
+
+			if _, err := dst.Exec(got); err != nil {
+				t.Fatalf("db.Exec() error = %v", err)
+			}
+			restored, err := ReadSchema(dst, tt.table)
+			if err != nil {
+				t.Fatalf("ReadSchema() error = %v", err)
+			}
+			for _, f := range restored.Fields {
+				if f.PrimaryKey != tt.wantPK[f.Name] {
+					t.Errorf("ReadSchema() %s PrimaryKey = %v, want %v", f.Name, f.PrimaryKey, tt.wantPK[f.Name])
+				}
+			}
+		})
+	}
+
+	if _, err := dst.Exec("INSERT INTO post_tags VALUES (1, 2), (2, 2)"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	if _, err := dst.Exec("INSERT INTO post_tags VALUES (1, 2)"); err == nil {
+		t.Error("inserting a duplicate composite key error = nil")
+	}
+	// the inline INTEGER PRIMARY KEY is an alias of the rowid
+	var id, rowid int64
+	if err := dst.QueryRow("INSERT INTO posts (title) VALUES ('hello') RETURNING id, rowid").Scan(&id, &rowid); err != nil {
+		t.Fatalf("db.QueryRow() error = %v", err)
+	}
+	if id != rowid {
+		t.Errorf("id = %v, want the rowid %v", id, rowid)
+	}
+}
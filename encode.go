@@ -0,0 +1,70 @@
+package avrosqlite
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hamba/avro"
+)
+
+// EncodeError describes a value that could not be encoded as the Avro type of
+// its field. It is returned by the export in place of the error of the encoder,
+// which does not name the field, and wraps it.
+type EncodeError struct {
+	// Table is the exported table, or the record name of an exported query.
+	Table string
+	// Field is the name of the Avro field, which may differ from the column name.
+	Field string
+	// Row is the index of the row in the table, counting from 0.
+	Row int64
+	// Expected is the Avro type of the field, such as "double" or "null or double".
+	Expected string
+	// Got is the Go type of the value, or "nil".
+	Got string
+	Err error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("field %s of row %d of table %s: expected %s, got %s", e.Field, e.Row, e.Table, e.Expected, e.Got)
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// encodeError finds the field of record, the index-th row of table, whose value
+// does not match its type in schema once encoding record failed with err, and
+// returns an *EncodeError describing it. If every field matches on its own, err
+// is returned unchanged.
+func encodeError(schema avro.Schema, table string, index int64, record map[string]any, err error) error {
+	recordSchema, ok := schema.(*avro.RecordSchema)
+	if !ok {
+		return err
+	}
+	for _, f := range recordSchema.Fields() {
+		v := record[f.Name()]
+		if _, fieldErr := avro.Marshal(f.Type(), v); fieldErr == nil {
+			continue
+		}
+		got := "nil"
+		if v != nil {
+			got = fmt.Sprintf("%T", v)
+		}
+		return &EncodeError{Table: table, Field: f.Name(), Row: index, Expected: avroTypeName(f.Type()), Got: got, Err: err}
+	}
+	return err
+}
+
+// avroTypeName describes an Avro type for an error message: the name of its
+// type, or the names of its branches for a union.
+func avroTypeName(schema avro.Schema) string {
+	union, ok := schema.(*avro.UnionSchema)
+	if !ok {
+		return string(schema.Type())
+	}
+	names := make([]string, len(union.Types()))
+	for i, t := range union.Types() {
+		names[i] = avroTypeName(t)
+	}
+	return strings.Join(names, " or ")
+}
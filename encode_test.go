@@ -0,0 +1,71 @@
+package avrosqlite
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+// priceEnhancer replaces the price of every row with a string.
+type priceEnhancer struct {
+	noopEnhancer
+}
+
+func (e *priceEnhancer) Row(row map[string]any) error {
+	row["price"] = "cheap"
+	return nil
+}
+
+func TestTableToOCFWriter_EncodeError(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE goods (name TEXT, price REAL NOT NULL, stock REAL);
+		INSERT INTO goods VALUES ('apple', 1.5, 3);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		export   func() error
+		table    string
+		expected string
+	}{
+		{
+			name:     "table",
+			export:   func() error { return TableToOCFWriter(db, "goods", io.Discard, &priceEnhancer{}) },
+			table:    "goods",
+			expected: "double",
+		},
+		{
+			name: "shards",
+			export: func() error {
+				_, err := TableToOCFShards(db, "goods", t.TempDir(), "", 10, &priceEnhancer{})
+				return err
+			},
+			table:    "goods",
+			expected: "double",
+		},
+		{
+			name: "query",
+			export: func() error {
+				return queryToOCF(db, "SELECT * FROM goods", nil, "priced", io.Discard, &priceEnhancer{})
+			},
+			table:    "priced",
+			expected: "null or double",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.export()
+			var encodeErr *EncodeError
+			if !errors.As(err, &encodeErr) {
+				t.Fatalf("export error = %v, want an *EncodeError", err)
+			}
+			want := EncodeError{Table: tt.table, Field: "price", Row: 0, Expected: tt.expected, Got: "string"}
+			if encodeErr.Table != want.Table || encodeErr.Field != want.Field || encodeErr.Row != want.Row ||
+				encodeErr.Expected != want.Expected || encodeErr.Got != want.Got || encodeErr.Err == nil {
+				t.Errorf("export error = %+v, want %+v", encodeErr, want)
+			}
+		})
+	}
+}
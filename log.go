@@ -0,0 +1,17 @@
+package avrosqlite
+
+import (
+	"io"
+	"log/slog"
+)
+
+// discardLogger is used when no logger is set, it drops every message.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// orDiscard returns logger, or discardLogger if it is nil.
+func orDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return discardLogger
+	}
+	return logger
+}
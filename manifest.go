@@ -0,0 +1,103 @@
+package avrosqlite
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ManifestFileName is the name of the manifest written to the export directory
+// when ExportOptions.Manifest is set.
+const ManifestFileName = "manifest.json"
+
+// Manifest describes the files produced by an export.
+type Manifest struct {
+	Tables []ManifestTable `json:"tables"`
+}
+
+// ManifestTable describes the files a table was exported to.
+type ManifestTable struct {
+	Table string `json:"table"`
+	// OCFFile is the name of the OCF file, relative to the manifest.
+	OCFFile string `json:"ocf_file"`
+	// JSONFile is the name of the JSON schema file, relative to the manifest, if any.
+	JSONFile string `json:"json_file,omitempty"`
+	// Fingerprint is the hex encoded SHA-256 fingerprint of the Avro schema of the OCF.
+	Fingerprint string `json:"fingerprint"`
+	// Rows is the number of records in the OCF.
+	Rows int64 `json:"rows"`
+	// Bytes is the size of the OCF file.
+	Bytes int64 `json:"bytes"`
+	// SHA256 is the hex encoded SHA-256 checksum of the OCF file, see VerifyOCF.
+	SHA256 string `json:"sha256"`
+	// Sequence is the AUTOINCREMENT high-water mark of the table when exported
+	// with ExportOptions.IncludeSequence, nil if the table has none.
+	Sequence *int64 `json:"sequence,omitempty"`
+}
+
+// ReadManifest reads a manifest written by an export.
+func ReadManifest(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: [%w]", path, err)
+	}
+	return m, nil
+}
+
+// VerifyOCF checks that the SHA-256 checksum of a file matches expectedSum,
+// the hex encoded checksum recorded in the manifest of an export.
+func VerifyOCF(fileName, expectedSum string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(sum, expectedSum) {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", fileName, sum, expectedSum)
+	}
+	return nil
+}
+
+// add records a table written to ocfFile, and to jsonFile if it is not empty.
+func (m *Manifest) add(table, ocfFile, jsonFile string, stats tableStats) {
+	m.Tables = append(m.Tables, ManifestTable{
+		Table:       table,
+		OCFFile:     ocfFile,
+		JSONFile:    jsonFile,
+		Fingerprint: hex.EncodeToString(stats.Fingerprint[:]),
+		Rows:        stats.Rows,
+		Bytes:       stats.Bytes,
+		SHA256:      hex.EncodeToString(stats.SHA256[:]),
+		Sequence:    stats.Sequence,
+	})
+}
+
+// marshal returns the manifest as indented JSON.
+func (m *Manifest) marshal() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// write saves the manifest to path.
+func (m *Manifest) write(path string) error {
+	b, err := m.marshal()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,80 @@
+package avrosqlite
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSqliteToAvroWithOptions_Manifest(t *testing.T) {
+	dir := t.TempDir()
+	files, err := SqliteToAvroWithOptions(testDB, dir, ExportOptions{IncludeJSON: true, Manifest: true})
+	if err != nil {
+		t.Fatalf("SqliteToAvroWithOptions() error = %v", err)
+	}
+	manifestPath := files[len(files)-1]
+	if manifestPath != filepath.Join(dir, ManifestFileName) {
+		t.Errorf("SqliteToAvroWithOptions() manifest = %v, want %v", manifestPath, filepath.Join(dir, ManifestFileName))
+	}
+
+	manifest, err := ReadManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+
+	tables := []string{}
+	for _, entry := range manifest.Tables {
+		tables = append(tables, entry.Table)
+
+		info, err := os.Stat(filepath.Join(dir, entry.OCFFile))
+		if err != nil {
+			t.Fatalf("os.Stat() error = %v", err)
+		}
+		if entry.Bytes != info.Size() {
+			t.Errorf("Manifest %s bytes = %v, want %v", entry.Table, entry.Bytes, info.Size())
+		}
+		if entry.Rows != 3 {
+			t.Errorf("Manifest %s rows = %v, want %v", entry.Table, entry.Rows, 3)
+		}
+		if _, err := os.Stat(filepath.Join(dir, entry.JSONFile)); err != nil {
+			t.Errorf("Manifest %s json file: %v", entry.Table, err)
+		}
+		if len(entry.Fingerprint) != 64 {
+			t.Errorf("Manifest %s fingerprint = %v, want a hex SHA-256", entry.Table, entry.Fingerprint)
+		}
+	}
+	if want := []string{"foo", "meats"}; !reflect.DeepEqual(tables, want) {
+		t.Errorf("Manifest tables = %v, want %v", tables, want)
+	}
+}
+
+func TestVerifyOCF(t *testing.T) {
+	dir := t.TempDir()
+	files, err := SqliteToAvroWithOptions(testDB, dir, ExportOptions{Manifest: true})
+	if err != nil {
+		t.Fatalf("SqliteToAvroWithOptions() error = %v", err)
+	}
+	manifest, err := ReadManifest(files[len(files)-1])
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	entry := manifest.Tables[0]
+	fileName := filepath.Join(dir, entry.OCFFile)
+
+	if err := VerifyOCF(fileName, entry.SHA256); err != nil {
+		t.Errorf("VerifyOCF() error = %v, want nil", err)
+	}
+
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	b[len(b)-1] ^= 0xff
+	if err := os.WriteFile(fileName, b, 0644); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	if err := VerifyOCF(fileName, entry.SHA256); err == nil {
+		t.Errorf("VerifyOCF() of a tampered file error = nil, want error")
+	}
+}
@@ -0,0 +1,223 @@
+package avrosqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/hamba/avro"
+)
+
+// MixedTypes controls how columns holding values of a storage class other than
+// their declared type are exported. SQLite uses dynamic typing, so a column
+// declared INTEGER can hold TEXT in some rows and a column declared BLOB can
+// hold any storage class.
+// https://www.sqlite.org/datatype3.html
+type MixedTypes int
+
+const (
+	// MixedTypesError exports every column as its declared type.
+	// Values of another storage class fail to encode.
+	MixedTypesError MixedTypes = iota
+	// MixedTypesCoerce converts the values of mixed columns to the declared type
+	// of the column. Values that cannot be converted are an error.
+	MixedTypesCoerce
+	// MixedTypesUnion promotes the Avro type of mixed columns to a union of the
+	// declared type and every storage class found in the column.
+	MixedTypesUnion
+)
+
+// storageClasses are the non-null storage classes of SQLite values in the
+// order they are added to a promoted union.
+var storageClasses = []SqliteType{SqliteInteger, SqliteReal, SqliteText, SqliteBlob}
+
+// DetectMixedTypes finds the columns of a table holding values of a storage class
+// other than their declared type. It returns the non-null storage classes found
+// in each of those columns.
+func DetectMixedTypes(db *sql.DB, table string) (map[string][]SqliteType, error) {
+	schema, err := ReadSchema(db, table)
+	if err != nil {
+		return nil, err
+	}
+	return detectMixedTypes(db, schema)
+}
+
+// detectMixedTypes finds the mixed columns of the table described by schema.
+// See DetectMixedTypes.
+func detectMixedTypes(q Querier, schema *SqliteSchema) (map[string][]SqliteType, error) {
+	mixed := map[string][]SqliteType{}
+	for _, f := range schema.Fields {
+		found, err := columnStorageClasses(q, schema.Table, f.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		classes := []SqliteType{}
+		isMixed := false
+		for _, class := range storageClasses {
+			if !found[class] {
+				continue
+			}
+			classes = append(classes, class)
+			if !storageClassMatches(f.Type, class) {
+				isMixed = true
+			}
+		}
+		if isMixed {
+			mixed[f.Name] = classes
+		}
+	}
+	return mixed, nil
+}
+
+// storageClassMatches reports whether values of a storage class are read from
+// a column of type t as the Go type of t.
+func storageClassMatches(t, class SqliteType) bool {
+	switch t {
+	case SqliteAny:
+		return true
+	case SqliteBoolean:
+		return class == SqliteInteger
+	case SqliteDate, SqliteDatetime, SqliteTimestamp:
+		// the driver reads dates from text and unix times from numbers
+		return class == SqliteText || class == SqliteInteger || class == SqliteReal
+	}
+	return class == t
+}
+
+// columnStorageClasses returns the set of storage classes of the values in a column.
+func columnStorageClasses(q Querier, table, column string) (map[SqliteType]bool, error) {
+	rows, err := q.Query(fmt.Sprintf("SELECT DISTINCT typeof(%s) FROM %s", quoteIdentifier(column), quoteTableName(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := map[SqliteType]bool{}
+	for rows.Next() {
+		var class string
+		if err := rows.Scan(&class); err != nil {
+			return nil, err
+		}
+		found[SqliteType(class)] = true
+	}
+	return found, rows.Err()
+}
+
+// coerceRow converts the values of the mixed columns of row to their declared type.
+func coerceRow(schema *SqliteSchema, mixed map[string][]SqliteType, row map[string]any) error {
+	for _, f := range schema.Fields {
+		if _, ok := mixed[f.Name]; !ok {
+			continue
+		}
+		v, err := coerceValue(f.Type, row[f.Name])
+		if err != nil {
+			return fmt.Errorf("failed to coerce column %s: [%w]", f.Name, err)
+		}
+		row[f.Name] = v
+	}
+	return nil
+}
+
+// coerceValue converts a value read from SQLite to the Go type used for t.
+func coerceValue(t SqliteType, v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch t {
+	case SqliteInteger:
+		switch v := v.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			if v == float64(int64(v)) {
+				return int64(v), nil
+			}
+		case string:
+			return strconv.ParseInt(v, 10, 64)
+		case []byte:
+			return strconv.ParseInt(string(v), 10, 64)
+		}
+	case SqliteReal:
+		switch v := v.(type) {
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		case string:
+			return strconv.ParseFloat(v, 64)
+		case []byte:
+			return strconv.ParseFloat(string(v), 64)
+		}
+	case SqliteText:
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case []byte:
+			return string(v), nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case float64:
+			return strconv.FormatFloat(v, 'g', -1, 64), nil
+		}
+	case SqliteBlob:
+		switch v := v.(type) {
+		case []byte:
+			return v, nil
+		case string:
+			return []byte(v), nil
+		case int64:
+			return []byte(strconv.FormatInt(v, 10)), nil
+		case float64:
+			return []byte(strconv.FormatFloat(v, 'g', -1, 64)), nil
+		}
+	case SqliteBoolean:
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case int64:
+			return v != 0, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot convert %v (%T) to %s", v, v, t)
+}
+
+// mixedTypeMapper is a TypeMapper that promotes mixed columns to a union of
+// their declared type and the storage classes found in them.
+type mixedTypeMapper struct {
+	mapper TypeMapper
+	mixed  map[string][]SqliteType
+}
+
+func (m mixedTypeMapper) ToAvro(field SchemaField) (avro.Schema, error) {
+	classes, ok := m.mixed[field.Name]
+	if !ok {
+		return m.mapper.ToAvro(field)
+	}
+
+	types := []SqliteType{}
+	if field.Type != SqliteNull {
+		types = append(types, field.Type)
+	}
+	for _, class := range classes {
+		// integers are read from BOOLEAN columns as booleans
+		if class == field.Type || class == SqliteInteger && field.Type == SqliteBoolean {
+			continue
+		}
+		types = append(types, class)
+	}
+
+	schemas := []avro.Schema{}
+	if field.Nullable {
+		schemas = append(schemas, nullSchema)
+	}
+	for _, t := range types {
+		s, err := SqliteTypeToAvroSchema(t, false)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, s)
+	}
+	return avro.NewUnionSchema(schemas)
+}
@@ -0,0 +1,111 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/hamba/avro/ocf"
+)
+
+// newMixedTestDB returns a database with a BLOB column holding both an integer and a string.
+func newMixedTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db := newTestDB(t)
+	_, err := db.Exec("CREATE TABLE mixed (id INTEGER, value BLOB)")
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	_, err = db.Exec("INSERT INTO mixed VALUES (1, 42), (2, 'King'), (3, NULL)")
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	return db
+}
+
+// readOCFValues returns the values of a field of every record in an OCF.
+func readOCFValues(t *testing.T, data []byte, field string) []any {
+	t.Helper()
+	dec, err := ocf.NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ocf.NewDecoder() error = %v", err)
+	}
+	values := []any{}
+	for dec.HasNext() {
+		var record map[string]any
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		values = append(values, record[field])
+	}
+	if err := dec.Error(); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	return values
+}
+
+func TestDetectMixedTypes(t *testing.T) {
+	db := newMixedTestDB(t)
+	got, err := DetectMixedTypes(db, "mixed")
+	if err != nil {
+		t.Fatalf("DetectMixedTypes() error = %v", err)
+	}
+	want := map[string][]SqliteType{"value": {SqliteInteger, SqliteText}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DetectMixedTypes() = %v, want %v", got, want)
+	}
+
+	got, err = DetectMixedTypes(testDB, "foo")
+	if err != nil {
+		t.Fatalf("DetectMixedTypes() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("DetectMixedTypes() = %v, want none", got)
+	}
+}
+
+func TestTableToOCFWriterWithOptions_MixedTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    MixedTypes
+		want    []any
+		wantErr bool
+	}{
+		{name: "error", mode: MixedTypesError, wantErr: true},
+		{name: "coerce", mode: MixedTypesCoerce, want: []any{[]byte("42"), []byte("King"), nil}},
+		{name: "union", mode: MixedTypesUnion, want: []any{int64(42), "King", nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newMixedTestDB(t)
+			var buf bytes.Buffer
+			err := TableToOCFWriterWithOptions(db, "mixed", &buf, ExportOptions{MixedTypes: tt.mode})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TableToOCFWriterWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got := readOCFValues(t, buf.Bytes(), "value")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TableToOCFWriterWithOptions() values = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTableToOCFWriterWithOptions_CoerceError(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec("CREATE TABLE bad (n INTEGER); INSERT INTO bad VALUES (1), ('one')")
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = TableToOCFWriterWithOptions(db, "bad", &buf, ExportOptions{MixedTypes: MixedTypesCoerce})
+	if err == nil {
+		t.Errorf("TableToOCFWriterWithOptions() error = nil, want error")
+	}
+}
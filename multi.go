@@ -0,0 +1,136 @@
+package avrosqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hamba/avro"
+)
+
+// MultiExporter exports several tables of a database, each as an OCF (Object
+// Container File) to its own writer, from a single snapshot of the database.
+// Unlike SqliteToAvro it is not limited to files: the writers can be network
+// streams, pipes or in-memory buffers.
+type MultiExporter struct {
+	// DB is the database the tables are exported from.
+	DB *sql.DB
+	// Writers maps the name of each exported table to the writer its OCF is written to.
+	Writers map[string]io.Writer
+	// Options controls how each table is exported. Only the options affecting a
+	// single table are used, see TableToOCFWriterWithOptions.
+	Options ExportOptions
+}
+
+// Run exports every table of Writers to its writer.
+//
+// Parameters:
+//   - ctx: The context of the export, which replaces the Context of the options.
+//
+// Returns:
+//   - error: An error naming the table that failed if any occurred, nil otherwise.
+//
+// The tables are exported in the order of their names, in a single read transaction
+// as with ExportOptions.Consistent, so that they all reflect the same snapshot of the
+// database. The export stops at the first table that fails, and the writers of the
+// following tables are left untouched. Run does not close the writers.
+func (m *MultiExporter) Run(ctx context.Context) error {
+	tables := make([]string, 0, len(m.Writers))
+	for table := range m.Writers {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	opts := m.Options
+	opts.Context = ctx
+	// SQLite transactions are deferred: the snapshot is taken by the first read
+	// and kept until the transaction ends
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin read transaction: [%w]", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range tables {
+		if _, err := tableToOCF(tx, table, m.Writers[table], opts); err != nil {
+			return fmt.Errorf("failed to export table %s: [%w]", table, err)
+		}
+	}
+	return nil
+}
+
+// MultiDBToAvro exports a table found in several databases, such as the shards
+// of a dataset split across SQLite files, to a partitioned set of OCF (Object
+// Container File) files, one per database.
+//
+// Parameters:
+//   - dbs: The databases holding the table, by name.
+//   - table: The name of the table to export from each database.
+//   - outDir: The directory the partitions are written to, in a subdirectory named after the table.
+//   - opts: Options controlling the export of each partition.
+//
+// Returns:
+//   - []string: A slice of strings containing the paths of the partitions.
+//   - error: An error naming the database that failed if any occurred, nil otherwise.
+//
+// The partitions are named outDir/table/part-00.avro, part-01.avro and so on,
+// in the order of the names of the databases. Each partition embeds the schema
+// of the table in its database, which must be compatible with the schema of the
+// first partition, so that they can all be loaded with it by
+// OCFFilesToTableWithSchema. Only the options affecting a single table are used,
+// see TableToOCFWriterWithOptions. If the export fails, no partition is left on disk.
+func MultiDBToAvro(dbs map[string]*sql.DB, table, outDir string, opts ...Option) ([]string, error) {
+	exportOpts := exportOptions(opts)
+	files := []string{}
+	names := make([]string, 0, len(dbs))
+	for name := range dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dir := filepath.Join(outDir, table)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return files, err
+	}
+
+	var first *avro.RecordSchema
+	for i, name := range names {
+		fileName := filepath.Join(dir, fmt.Sprintf("part-%02d.avro", i))
+		schema, err := exportPartition(dbs[name], table, fileName, first, exportOpts)
+		if err != nil {
+			// the partitions already written are removed with the failed one
+			for _, f := range files {
+				os.Remove(f)
+			}
+			return []string{}, fmt.Errorf("failed to export table %s of database %s: [%w]", table, name, err)
+		}
+		if first == nil {
+			first = schema
+		}
+		files = append(files, fileName)
+	}
+	return files, nil
+}
+
+// exportPartition writes a table to an OCF file, checking first that its records
+// can be read with the reader schema unless it is nil, and returns its schema.
+func exportPartition(db *sql.DB, table, fileName string, reader *avro.RecordSchema, opts ExportOptions) (*avro.RecordSchema, error) {
+	export, err := newTableExport(db, table, opts, false)
+	if err != nil {
+		return nil, err
+	}
+	schema := export.avroSchema.(*avro.RecordSchema)
+	if reader != nil {
+		if err := checkResolvable(reader, schema, nil); err != nil {
+			return nil, fmt.Errorf("schema is not compatible with the first partition: [%w]", err)
+		}
+	}
+	return schema, writeFile(fileName, func(f *os.File) error {
+		_, err := export.writeOCF(f)
+		return err
+	})
+}
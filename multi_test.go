@@ -0,0 +1,127 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMultiExporter_Run(t *testing.T) {
+	var foo, meats bytes.Buffer
+	exporter := &MultiExporter{
+		DB:      testDB,
+		Writers: map[string]io.Writer{"foo": &foo, "meats": &meats},
+	}
+	if err := exporter.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	for table, buf := range map[string]*bytes.Buffer{"foo": &foo, "meats": &meats} {
+		want, err := TableToOCFBytes(testDB, table, nil)
+		if err != nil {
+			t.Fatalf("TableToOCFBytes() error = %v", err)
+		}
+		schema, err := ReadSchema(testDB, table)
+		if err != nil {
+			t.Fatalf("ReadSchema() error = %v", err)
+		}
+		field := schema.Fields[0].Name
+		if got, want := readOCFValues(t, buf.Bytes(), field), readOCFValues(t, want, field); !reflect.DeepEqual(got, want) {
+			t.Errorf("Run() table %s values = %v, want %v", table, got, want)
+		}
+	}
+}
+
+func TestMultiExporter_RunErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		writers map[string]io.Writer
+		ctx     func() context.Context
+		want    error
+	}{
+		{name: "missing table", writers: map[string]io.Writer{"missing": io.Discard}, ctx: context.Background, want: ErrTableNotFound},
+		{name: "canceled", writers: map[string]io.Writer{"foo": io.Discard}, ctx: func() context.Context {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx
+		}, want: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := &MultiExporter{DB: testDB, Writers: tt.writers}
+			if err := exporter.Run(tt.ctx()); !errors.Is(err, tt.want) {
+				t.Errorf("Run() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// newEventsDB returns an in-memory database holding an events table created with ddl.
+func newEventsDB(t *testing.T, ddl string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	// every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(ddl); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	return db
+}
+
+func TestMultiDBToAvro(t *testing.T) {
+	dbs := map[string]*sql.DB{
+		"shard-b": newEventsDB(t, `CREATE TABLE events (id INTEGER PRIMARY KEY, name TEXT);
+			INSERT INTO events VALUES (3, 'c');`),
+		"shard-a": newEventsDB(t, `CREATE TABLE events (id INTEGER PRIMARY KEY, name TEXT);
+			INSERT INTO events VALUES (1, 'a'), (2, 'b');`),
+	}
+	dir := t.TempDir()
+	files, err := MultiDBToAvro(dbs, "events", dir, WithCodec("deflate"))
+	if err != nil {
+		t.Fatalf("MultiDBToAvro() error = %v", err)
+	}
+	want := []string{filepath.Join(dir, "events", "part-00.avro"), filepath.Join(dir, "events", "part-01.avro")}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("MultiDBToAvro() = %v, want %v", files, want)
+	}
+
+	for i, wantNames := range [][]any{{"a", "b"}, {"c"}} {
+		data, err := os.ReadFile(files[i])
+		if err != nil {
+			t.Fatalf("os.ReadFile() error = %v", err)
+		}
+		if got := readOCFValues(t, data, "name"); !reflect.DeepEqual(got, wantNames) {
+			t.Errorf("MultiDBToAvro() partition %d names = %v, want %v", i, got, wantNames)
+		}
+	}
+}
+
+func TestMultiDBToAvro_IncompatibleSchemas(t *testing.T) {
+	dbs := map[string]*sql.DB{
+		"a": newEventsDB(t, `CREATE TABLE events (id INTEGER PRIMARY KEY, name TEXT);
+			INSERT INTO events VALUES (1, 'a');`),
+		"b": newEventsDB(t, `CREATE TABLE events (id INTEGER PRIMARY KEY, name REAL);
+			INSERT INTO events VALUES (2, 1.5);`),
+	}
+	dir := t.TempDir()
+	if _, err := MultiDBToAvro(dbs, "events", dir); err == nil {
+		t.Fatal("MultiDBToAvro() error = nil, want error")
+	}
+	left, err := os.ReadDir(filepath.Join(dir, "events"))
+	if err != nil {
+		t.Fatalf("os.ReadDir() error = %v", err)
+	}
+	if len(left) != 0 {
+		t.Errorf("MultiDBToAvro() left %d partitions on disk", len(left))
+	}
+}
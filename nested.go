@@ -0,0 +1,337 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hamba/avro"
+	"github.com/hamba/avro/ocf"
+	"github.com/mattn/go-sqlite3"
+)
+
+// ChildSpec describes the child table nested in the rows of its parent table by NestedExport.
+type ChildSpec struct {
+	// Table is the name of the child table.
+	Table string
+	// ForeignKey is the column of the child table referencing the parent table,
+	// and ParentKey the referenced column of the parent table. If ForeignKey is
+	// empty, both are taken from the foreign key of the child table referencing
+	// the parent table, which must be on a single column.
+	ForeignKey string
+	ParentKey  string
+	// Field is the name of the Avro array field holding the children of each
+	// parent row. If empty, it is the name of the child table.
+	Field string
+}
+
+// NestedExport writes the rows of a parent table to an OCF (Object Container File)
+// file, each with the rows of a child table referencing it nested in an array.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - parentTable: The name of the parent table.
+//   - child: The child table and how its rows reference the parent rows.
+//   - fileName: The path and name of the OCF file to be created.
+//
+// Returns:
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// The children of each parent row are aggregated by SQLite with the JSON1
+// json_group_array function, and decoded into an Avro array of child records,
+// which turns a normalized one-to-many relation into denormalized nested records.
+// A parent row without children has an empty array. Parents are ordered by their
+// primary key, or by rowid if there is none, and so are children.
+// BLOB columns of the child table are carried through JSON as hex, while ANY
+// columns of the child table must not hold BLOBs, which JSON cannot represent.
+func NestedExport(db *sql.DB, parentTable string, child ChildSpec, fileName string) error {
+	parent, err := ReadSchema(db, parentTable)
+	if err != nil {
+		return err
+	}
+	children, err := ReadSchema(db, child.Table)
+	if err != nil {
+		return err
+	}
+	if err := child.resolveKeys(parent, children); err != nil {
+		return err
+	}
+	field := child.Field
+	if field == "" {
+		field = child.Table
+	}
+	if parent.field(field) != nil {
+		return fmt.Errorf("field %s of the children of table %s is also a column of it", field, parentTable)
+	}
+
+	avroSchema, err := nestedAvroSchema(parent, children, field)
+	if err != nil {
+		return err
+	}
+	parentNames, err := parent.AvroFieldNames(AvroOptions{SanitizeNames: true})
+	if err != nil {
+		return err
+	}
+	childNames, err := children.AvroFieldNames(AvroOptions{SanitizeNames: true})
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query(nestedQuery(parent, children, child, field))
+	if err != nil {
+		return fmt.Errorf("failed to query nested rows: [%w]", err)
+	}
+	defer rows.Close()
+	reader, err := newRowReader(rows)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(fileName, func(f *os.File) error {
+		enc, err := ocf.NewEncoder(avroSchema.String(), f)
+		if err != nil {
+			return err
+		}
+		defer enc.Close()
+
+		for index := int64(0); ; index++ {
+			row, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			nested, err := decodeChildren(children, childNames, row[field])
+			if err != nil {
+				return fmt.Errorf("failed to decode the children of row %d of table %s: [%w]", index, parentTable, err)
+			}
+			delete(row, field)
+			record := toAvroRecord(parentNames, row)
+			record[field] = nested
+			if err := enc.Encode(record); err != nil {
+				return encodeError(avroSchema, parentTable, index, record, err)
+			}
+		}
+		// the encoder is closed before the file to write its last block
+		return enc.Close()
+	})
+}
+
+// resolveKeys fills in the keys of the spec from the foreign key of the child
+// table referencing the parent table if they are not set.
+func (c *ChildSpec) resolveKeys(parent, children *SqliteSchema) error {
+	if c.ForeignKey != "" {
+		if children.field(c.ForeignKey) == nil {
+			return fmt.Errorf("column %s is not in table %s", c.ForeignKey, children.Table)
+		}
+		if c.ParentKey == "" {
+			return fmt.Errorf("the parent key of foreign key %s of table %s is not set", c.ForeignKey, children.Table)
+		}
+		if parent.field(c.ParentKey) == nil {
+			return fmt.Errorf("column %s is not in table %s", c.ParentKey, parent.Table)
+		}
+		return nil
+	}
+
+	var fk *ForeignKey
+	for i := range children.ForeignKeys {
+		if !strings.EqualFold(children.ForeignKeys[i].ParentTable, parent.Table) {
+			continue
+		}
+		if fk != nil {
+			return fmt.Errorf("table %s has several foreign keys referencing table %s", children.Table, parent.Table)
+		}
+		fk = &children.ForeignKeys[i]
+	}
+	if fk == nil {
+		return fmt.Errorf("table %s has no foreign key referencing table %s", children.Table, parent.Table)
+	}
+	parentColumns := fk.ParentColumns
+	if len(parentColumns) == 0 {
+		parentColumns = parent.primaryKey()
+	}
+	if len(fk.Columns) != 1 || len(parentColumns) != 1 {
+		return fmt.Errorf("foreign key of table %s referencing table %s is not on a single column", children.Table, parent.Table)
+	}
+	c.ForeignKey, c.ParentKey = fk.Columns[0], parentColumns[0]
+	return nil
+}
+
+// field returns the field of the schema holding the given column, or nil.
+func (s *SqliteSchema) field(column string) *SchemaField {
+	for i := range s.Fields {
+		if strings.EqualFold(s.Fields[i].Name, column) {
+			return &s.Fields[i]
+		}
+	}
+	return nil
+}
+
+// nestedAvroSchema returns the schema of the parent records, with the child
+// records nested in an array field.
+func nestedAvroSchema(parent, children *SqliteSchema, field string) (avro.Schema, error) {
+	opts := AvroOptions{SanitizeNames: true}
+	parentSchema, err := parent.ToAvroWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	childSchema, err := children.ToAvroWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if !isValidAvroName(field) {
+		return nil, fmt.Errorf("field %q is not a valid avro field name", field)
+	}
+	array, err := avro.NewField(field, avro.NewArraySchema(childSchema), avro.NoDefault)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create avro field: [%w]", err)
+	}
+	record := parentSchema.(*avro.RecordSchema)
+	fields := append(record.Fields(), array)
+	nested, err := avro.NewRecordSchema(record.Name(), record.Namespace(), fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create avro record schema: [%w]", err)
+	}
+	return nested, nil
+}
+
+// nestedQuery returns the query selecting the rows of the parent table with
+// their children aggregated as a JSON array in the given column.
+func nestedQuery(parent, children *SqliteSchema, child ChildSpec, field string) string {
+	pairs := make([]string, 0, len(children.Fields))
+	for _, f := range children.Fields {
+		value := "c." + quoteIdentifier(f.Name)
+		if f.Type == SqliteBlob {
+			// hex returns an empty string for NULL
+			value = fmt.Sprintf("CASE WHEN %s IS NULL THEN NULL ELSE hex(%s) END", value, value)
+		}
+		pairs = append(pairs, fmt.Sprintf("'%s', %s", strings.ReplaceAll(f.Name, "'", "''"), value))
+	}
+	columns := make([]string, 0, len(parent.Fields))
+	for _, f := range parent.Fields {
+		columns = append(columns, "p."+quoteIdentifier(f.Name))
+	}
+
+	return fmt.Sprintf(
+		"SELECT %s, (SELECT json_group_array(json_object(%s)) FROM (SELECT * FROM %s WHERE %s = p.%s%s) AS c) AS %s FROM %s AS p%s",
+		strings.Join(columns, ", "), strings.Join(pairs, ", "),
+		quoteTableName(children.Table), quoteIdentifier(child.ForeignKey), quoteIdentifier(child.ParentKey),
+		orderByClause(children), quoteIdentifier(field), quoteTableName(parent.Table), orderByClause(parent))
+}
+
+// orderByClause orders the rows of a table by their primary key, or by rowid if it has none.
+func orderByClause(s *SqliteSchema) string {
+	keys := s.primaryKey()
+	if len(keys) == 0 {
+		keys = []string{rowIDColumn}
+	}
+	return " ORDER BY " + quoteIdentifiers(keys)
+}
+
+// decodeChildren decodes the JSON array of child rows aggregated by the nested
+// query into the child records, whose fields are named as in names.
+func decodeChildren(children *SqliteSchema, names map[string]string, v any) ([]any, error) {
+	var data []byte
+	switch v := v.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return nil, fmt.Errorf("unexpected JSON aggregate %v (%T)", v, v)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var rows []map[string]any
+	if err := dec.Decode(&rows); err != nil {
+		return nil, err
+	}
+	records := make([]any, 0, len(rows))
+	for _, row := range rows {
+		for _, f := range children.Fields {
+			value, err := fromJSONValue(f.Type, row[f.Name])
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode column %s: [%w]", f.Name, err)
+			}
+			row[f.Name] = value
+		}
+		records = append(records, toAvroRecord(names, row))
+	}
+	return records, nil
+}
+
+// fromJSONValue converts a value of a column of type t decoded from JSON with
+// UseNumber to the Go type the driver reads from the column.
+func fromJSONValue(t SqliteType, v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	n, isNumber := v.(json.Number)
+	s, isString := v.(string)
+
+	switch t {
+	case SqliteInteger:
+		if isNumber {
+			return n.Int64()
+		}
+	case SqliteReal:
+		if isNumber {
+			return n.Float64()
+		}
+	case SqliteText:
+		if isString {
+			return s, nil
+		}
+		if isNumber {
+			return n.String(), nil
+		}
+	case SqliteBlob:
+		if isString {
+			return hex.DecodeString(s)
+		}
+	case SqliteBoolean:
+		if isNumber {
+			i, err := n.Int64()
+			return i != 0, err
+		}
+	case SqliteDate, SqliteDatetime, SqliteTimestamp:
+		if isNumber {
+			i, err := n.Int64()
+			return time.Unix(i, 0).UTC(), err
+		}
+		if isString {
+			return parseSqliteTime(s)
+		}
+	case SqliteAny:
+		if isNumber {
+			if i, err := n.Int64(); err == nil {
+				return i, nil
+			}
+			return n.Float64()
+		}
+		if isString {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot convert %v (%T) to %s", v, v, t)
+}
+
+// parseSqliteTime parses a time in one of the formats the driver reads times in.
+func parseSqliteTime(s string) (time.Time, error) {
+	s = strings.TrimSuffix(s, "Z")
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(format, s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid time " + s)
+}
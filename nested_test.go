@@ -0,0 +1,137 @@
+package avrosqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newNestedTestDB returns a database with customers and the orders referencing them.
+func newNestedTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE customers (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, customer_id INTEGER REFERENCES customers (id),
+			total REAL, receipt BLOB, placed DATE);
+		INSERT INTO customers VALUES (1, 'ada'), (2, 'bob'), (3, 'cy');
+		INSERT INTO orders VALUES (12, 1, NULL, x'', '2024-03-04'), (10, 1, 9.5, x'0102', '2024-01-02'),
+			(11, 2, 3, NULL, NULL);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	return db
+}
+
+func TestNestedExport(t *testing.T) {
+	db := newNestedTestDB(t)
+	// the values of nullable fields nested in an array are decoded as maps keyed by their type
+	union := func(name string, v any) any {
+		if v == nil {
+			return nil
+		}
+		return map[string]any{name: v}
+	}
+	order := func(id, customer int64, total, receipt, placed any) map[string]any {
+		return map[string]any{
+			"id":          union("long", id),
+			"customer_id": union("long", customer),
+			"total":       union("double", total),
+			"receipt":     union("bytes", receipt),
+			"placed":      union("int.date", placed),
+		}
+	}
+	wantOrders := []any{
+		[]any{
+			order(10, 1, 9.5, []byte{1, 2}, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)),
+			order(12, 1, nil, []byte{}, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)),
+		},
+		[]any{order(11, 2, 3.0, nil, nil)},
+		[]any{},
+	}
+
+	tests := []struct {
+		name  string
+		child ChildSpec
+		field string
+	}{
+		{name: "foreign key", child: ChildSpec{Table: "orders"}, field: "orders"},
+		{name: "explicit keys", child: ChildSpec{Table: "orders", ForeignKey: "customer_id", ParentKey: "id", Field: "purchases"}, field: "purchases"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "customers.avro")
+			if err := NestedExport(db, "customers", tt.child, fileName); err != nil {
+				t.Fatalf("NestedExport() error = %v", err)
+			}
+			data, err := os.ReadFile(fileName)
+			if err != nil {
+				t.Fatalf("os.ReadFile() error = %v", err)
+			}
+
+			if got, want := readOCFValues(t, data, "name"), []any{"ada", "bob", "cy"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("NestedExport() names = %v, want %v", got, want)
+			}
+			if got := readOCFValues(t, data, tt.field); !reflect.DeepEqual(got, wantOrders) {
+				t.Errorf("NestedExport() orders = %v, want %v", got, wantOrders)
+			}
+		})
+	}
+}
+
+func TestNestedExport_Errors(t *testing.T) {
+	db := newNestedTestDB(t)
+	tests := []struct {
+		name   string
+		parent string
+		child  ChildSpec
+	}{
+		{name: "missing child table", parent: "customers", child: ChildSpec{Table: "missing"}},
+		{name: "no foreign key", parent: "orders", child: ChildSpec{Table: "customers"}},
+		{name: "missing foreign key column", parent: "customers", child: ChildSpec{Table: "orders", ForeignKey: "missing", ParentKey: "id"}},
+		{name: "missing parent key", parent: "customers", child: ChildSpec{Table: "orders", ForeignKey: "customer_id"}},
+		{name: "field is a parent column", parent: "customers", child: ChildSpec{Table: "orders", Field: "name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "nested.avro")
+			if err := NestedExport(db, tt.parent, tt.child, fileName); err == nil {
+				t.Error("NestedExport() error = nil, want error")
+			}
+		})
+	}
+}
+
+func Test_fromJSONValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		t       SqliteType
+		v       any
+		want    any
+		wantErr bool
+	}{
+		{name: "null", t: SqliteInteger, v: nil, want: nil},
+		{name: "boolean", t: SqliteBoolean, v: json.Number("1"), want: true},
+		{name: "any integer", t: SqliteAny, v: json.Number("7"), want: int64(7)},
+		{name: "any real", t: SqliteAny, v: json.Number("7.5"), want: 7.5},
+		{name: "text from number", t: SqliteText, v: json.Number("42"), want: "42"},
+		{name: "datetime", t: SqliteDatetime, v: "2024-01-02 03:04:05", want: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{name: "unix time", t: SqliteTimestamp, v: json.Number("60"), want: time.Unix(60, 0).UTC()},
+		{name: "integer from text", t: SqliteInteger, v: "one", wantErr: true},
+		{name: "invalid blob", t: SqliteBlob, v: "zz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fromJSONValue(tt.t, tt.v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fromJSONValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fromJSONValue() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,13 +1,22 @@
 package avrosqlite
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/hamba/avro"
 	"github.com/hamba/avro/ocf"
 )
 
@@ -44,62 +53,875 @@ func (*noopEnhancer) Row(map[string]any) error   { return nil }
 // This function reads the schema and data from the specified table, applies any enhancements,
 // and writes the result to an OCF file.
 func TableToOCF(db *sql.DB, table, fileName string, enhancer Enhancer) error {
+	return TableToOCFWithOptions(db, table, fileName, ExportOptions{Enhancer: enhancer})
+}
+
+// TableToOCFWithOptions writes the data from a specified table to an OCF (Object Container File) file
+// using the given options. Only the options affecting a single table are used, see TableToOCF.
+func TableToOCFWithOptions(db *sql.DB, table, fileName string, opts ExportOptions) error {
+	_, err := tableToOCFFile(db, table, fileName, opts)
+	return err
+}
+
+// tableToOCFFile writes a table to an OCF file and describes what was written.
+// The Bytes and SHA256 of the returned stats are those of the file.
+func tableToOCFFile(q Querier, table, fileName string, opts ExportOptions) (tableStats, error) {
+	var stats tableStats
+	err := writeFile(fileName, func(f *os.File) error {
+		// the checksum is computed while writing to avoid reading the file again
+		h := sha256.New()
+		var err error
+		stats, err = tableToOCF(q, table, io.MultiWriter(f, h), opts)
+		if err != nil {
+			return err
+		}
+		copy(stats.SHA256[:], h.Sum(nil))
+
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		stats.Bytes = info.Size()
+		return nil
+	})
+	return stats, err
+}
+
+// writeFile creates a file and calls write with it, then syncs and closes the file.
+// If any of these steps fails, the partially written file is closed and removed,
+// so a failed export leaves no file behind.
+func writeFile(fileName string, write func(f *os.File) error) (err error) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(fileName)
+		}
+	}()
+
+	if err := write(f); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// TableToOCFWriter writes the data from a specified table as an OCF (Object Container File) to w.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - table: The name of the table to export.
+//   - w: The io.Writer the OCF is written to.
+//   - enhancer: An Enhancer interface for modifying the schema and data (can be nil).
+//
+// Returns:
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// This function reads the schema and data from the specified table, applies any enhancements,
+// and writes the result to w. It does not close w. Columns whose names are not valid
+// Avro names are written to sanitized fields, see AvroOptions.SanitizeNames.
+func TableToOCFWriter(db *sql.DB, table string, w io.Writer, enhancer Enhancer) error {
+	return TableToOCFWriterWithOptions(db, table, w, ExportOptions{Enhancer: enhancer})
+}
+
+// TableToOCFWriterWithOptions writes the data from a specified table as an OCF (Object Container File) to w
+// using the given options. Only the options affecting a single table are used, see TableToOCFWriter.
+func TableToOCFWriterWithOptions(db *sql.DB, table string, w io.Writer, opts ExportOptions) error {
+	_, err := tableToOCF(db, table, w, opts)
+	return err
+}
+
+// tableStats describes a table written to an OCF.
+type tableStats struct {
+	// Rows is the number of records written.
+	Rows int64
+	// Fingerprint is the fingerprint of the Avro schema of the OCF.
+	Fingerprint [32]byte
+	// Bytes is the size of the OCF, if known.
+	Bytes int64
+	// SHA256 is the checksum of the OCF, if known.
+	SHA256 [32]byte
+	// Sequence is the AUTOINCREMENT high-water mark of the table, if exported.
+	Sequence *int64
+}
+
+// tableToOCF writes a table as an OCF to w and describes what was written.
+// See TableToOCFWriterWithOptions.
+func tableToOCF(q Querier, table string, w io.Writer, opts ExportOptions) (tableStats, error) {
+	export, err := newTableExport(q, table, opts, false)
+	if err != nil {
+		return tableStats{}, err
+	}
+	return export.writeOCF(w)
+}
+
+// writeOCF writes the rows of the table as an OCF to w and describes what was written.
+func (e *tableExport) writeOCF(w io.Writer) (tableStats, error) {
+	stats := tableStats{}
+	encOpts, err := e.encoderOptions()
+	if err != nil {
+		return stats, err
+	}
+	stats.Fingerprint = e.avroSchema.Fingerprint()
+
+	enc, err := ocf.NewEncoder(e.avroSchema.String(), w, encOpts...)
+	if err != nil {
+		return stats, err
+	}
+	defer enc.Close()
+
+	ctx := e.opts.context()
+	err = e.eachRow(func(row map[string]any) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record, err := e.record(row)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return nil
+		}
+		err = enc.Encode(record)
+		if err != nil {
+			return encodeError(e.avroSchema, e.schema.Table, e.next-1, record, err)
+		}
+		stats.Rows++
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	return stats, enc.Flush()
+}
+
+// tableExport is a table prepared for export: its schema after the enhancer and
+// the options were applied, the Avro schema it is encoded with and its rows.
+type tableExport struct {
+	opts       ExportOptions
+	enhancer   Enhancer
+	override   SchemaOverride
+	mixed      map[string][]SqliteType
+	bigInts    map[string]bool
+	blobs      map[string]bool
+	schema     *SqliteSchema
+	avroSchema avro.Schema
+	// fieldNames are the renamed columns of schema, see SqliteSchema.AvroFieldNames
+	fieldNames map[string]string
+	data       []map[string]any
+	// q and query read the rows page by page when PageSize is set, data is then nil
+	q     Querier
+	query string
+	// keys are the columns the pages are read by when PageSize is set and the
+	// table has a suitable key, firstQuery and afterQuery the queries reading the
+	// first page and the page after a key, and dropRowID is true if the rowid is
+	// selected only to page by it
+	keys       []string
+	firstQuery string
+	afterQuery string
+	dropRowID  bool
+	// doc is the doc of the Avro record, see ExportOptions.RecordDoc
+	doc string
+	// next is the index of the next row passed to record
+	next int64
+}
+
+// newTableExport reads the schema and the rows of a table and applies the options
+// and the enhancer to the schema. If ordered is true the rows are sorted by the
+// primary key of the table, or by rowid if it has none. With PageSize, the rows
+// are always sorted and they are read by eachRow instead.
+func newTableExport(q Querier, table string, opts ExportOptions, ordered bool) (*tableExport, error) {
+	e := &tableExport{
+		opts:     opts,
+		enhancer: opts.Enhancer,
+		override: opts.Overrides[table],
+		mixed:    map[string][]SqliteType{},
+	}
+	if e.enhancer == nil {
+		e.enhancer = &noopEnhancer{}
+	}
+
+	if opts.PageSize < 0 {
+		return nil, fmt.Errorf("page size must be positive, got %d", opts.PageSize)
+	}
+	schema, err := readSchema(q, table, opts.logger())
+	if err != nil {
+		return nil, err
+	}
+	if err := opts.applyNullability(q, schema); err != nil {
+		return nil, err
+	}
+	// the columns are read before the schema is changed by the options or the enhancer
+	columns := schema.columnNames()
+	var orderBy []string
+	if ordered || opts.PageSize > 0 {
+		orderBy = schema.primaryKey()
+		if len(orderBy) == 0 {
+			orderBy = []string{rowIDColumn}
+		}
+	}
+	includeRowID := opts.IncludeRowID && schema.addRowID()
+
+	// mixed columns are detected before the enhancer can add columns that are not in the table
+	if opts.MixedTypes != MixedTypesError {
+		e.mixed, err = detectMixedTypes(q, schema)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.BigIntegers {
+		e.bigInts, err = detectBigIntegers(q, schema)
+		if err != nil {
+			return nil, err
+		}
+		for column := range e.bigInts {
+			// big integers are converted to strings, not coerced to the declared type
+			delete(e.mixed, column)
+			opts.logger().Info("big integer column exported as a union of long and string", "table", table, "column", column)
+		}
+	}
+	e.blobs = opts.ExternalizeBlobs.applySchema(schema)
+	for column := range e.blobs {
+		delete(e.mixed, column)
+	}
+
+	err = e.enhancer.Schema(schema)
+	if err != nil {
+		return nil, err
+	}
+	e.override.applySchema(schema)
+
+	avroOpts := AvroOptions{SanitizeNames: true, Namespace: opts.Namespace}
+	if opts.MixedTypes == MixedTypesUnion {
+		avroOpts.TypeMapper = mixedTypeMapper{mapper: DefaultTypeMapper{}, mixed: e.mixed}
+	}
+	if len(e.bigInts) > 0 {
+		mapper := avroOpts.TypeMapper
+		if mapper == nil {
+			mapper = DefaultTypeMapper{}
+		}
+		avroOpts.TypeMapper = bigIntegerTypeMapper{mapper: mapper, columns: e.bigInts}
+	}
+	avroOpts = e.override.avroOptions(avroOpts)
+	avroOpts = opts.renameOptions(table, avroOpts)
+	if name, ok := opts.TableRename[table]; ok {
+		schema.RecordName = name
+	}
+	e.schema = schema
+	e.avroSchema, err = schema.ToAvroWithOptions(avroOpts)
+	if err != nil {
+		return nil, err
+	}
+	e.fieldNames, err = schema.AvroFieldNames(avroOpts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.RecordDoc {
+		e.doc, err = recordDoc(q, table)
+		if err != nil {
+			return nil, err
+		}
+		e.avroSchema.(*avro.RecordSchema).AddDoc(e.doc)
+	}
+
+	if opts.PageSize > 0 {
+		e.q = q
+		e.query = selectQuery(table, columns, includeRowID, orderBy) + " LIMIT ? OFFSET ?"
+		if e.keys = keysetColumns(schema, orderBy, columns); len(e.keys) > 0 {
+			selectRowID := includeRowID || e.keys[0] == rowIDColumn
+			e.dropRowID = selectRowID && !includeRowID
+			e.firstQuery = keysetQuery(table, columns, selectRowID, e.keys, false)
+			e.afterQuery = keysetQuery(table, columns, selectRowID, e.keys, true)
+		}
+		return e, nil
+	}
+	e.data, err = loadData(q, table, columns, includeRowID, orderBy)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// eachRow calls fn with each row of the table in order, stopping at the first error.
+// With PageSize, the rows are read a page at a time, each with its own query.
+func (e *tableExport) eachRow(fn func(row map[string]any) error) error {
+	if e.opts.PageSize <= 0 {
+		for _, row := range e.data {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// after is the key of the last row read, nil before the first page
+	var after []any
+	for offset := 0; ; offset += e.opts.PageSize {
+		var page []map[string]any
+		var err error
+		switch {
+		case len(e.keys) == 0:
+			page, err = loadRows(e.q, e.query, e.opts.PageSize, offset)
+		case after == nil:
+			page, err = loadRows(e.q, e.firstQuery, e.opts.PageSize)
+		default:
+			page, err = loadRows(e.q, e.afterQuery, append(after, e.opts.PageSize)...)
+		}
+		if err != nil {
+			return err
+		}
+		if len(e.keys) > 0 && len(page) > 0 {
+			after = keyValues(page[len(page)-1], e.keys)
+			if after == nil {
+				// a NULL key cannot be compared with, the following pages are read by offset
+				e.keys = nil
+			}
+		}
+		for _, row := range page {
+			if e.dropRowID {
+				delete(row, rowIDColumn)
+			}
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		if len(page) < e.opts.PageSize {
+			return nil
+		}
+	}
+}
+
+// keysetColumns returns the columns the rows of a table ordered by orderBy can
+// be paged by, the key of the last row of a page being the lower bound of the
+// next one. It returns nil if the order is not by a key the values of which the
+// driver reads back as they are stored: the primary key must be made of INTEGER,
+// REAL, TEXT, BLOB or ANY columns, and the rowid must not be hidden by a column.
+func keysetColumns(schema *SqliteSchema, orderBy, columns []string) []string {
+	if len(orderBy) == 1 && orderBy[0] == rowIDColumn {
+		for _, column := range columns {
+			if strings.EqualFold(column, rowIDColumn) {
+				return nil
+			}
+		}
+		return orderBy
+	}
+	for _, column := range orderBy {
+		f := schema.field(column)
+		if f == nil {
+			return nil
+		}
+		switch f.Type {
+		case SqliteInteger, SqliteReal, SqliteText, SqliteBlob, SqliteAny:
+		default:
+			return nil
+		}
+	}
+	return orderBy
+}
+
+// keysetQuery returns the query reading a page of a table ordered by keys: the
+// first one, or if after is true the one after the key bound to its parameters,
+// compared as a row value for a composite key.
+func keysetQuery(table string, columns []string, includeRowID bool, keys []string, after bool) string {
+	query := selectQuery(table, columns, includeRowID, nil)
+	if after {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")
+		query += fmt.Sprintf(" WHERE (%s) > (%s)", quoteIdentifiers(keys), placeholders)
+	}
+	return query + " ORDER BY " + quoteIdentifiers(keys) + " LIMIT ?"
+}
+
+// keyValues returns the values of the key columns of a row, or nil if one of them is NULL.
+func keyValues(row map[string]any, keys []string) []any {
+	values := make([]any, 0, len(keys))
+	for _, key := range keys {
+		v := row[key]
+		if v == nil {
+			return nil
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// record converts a row of the table to the Avro record encoded for it.
+// It returns a nil record if the row is left out of the export.
+func (e *tableExport) record(row map[string]any) (map[string]any, error) {
+	index := e.next
+	e.next++
+	convertBigIntegers(e.bigInts, row)
+	if e.opts.MixedTypes == MixedTypesCoerce {
+		if err := coerceRow(e.schema, e.mixed, row); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.opts.ExternalizeBlobs.writeRow(e.schema.Table, e.blobs, index, row); err != nil {
+		return nil, err
+	}
+
+	if err := e.enhancer.Row(row); err != nil {
+		return nil, err
+	}
+	if err := e.opts.transformRow(e.schema, row); err != nil {
+		return nil, err
+	}
+	if err := e.override.applyRow(e.schema, row); err != nil {
+		return nil, err
+	}
+	if err := e.applyNulls(index, row); err != nil {
+		return nil, err
+	}
+	if keep, err := e.checkSizes(index, row); !keep {
+		return nil, err
+	}
+	return toAvroRecord(e.fieldNames, row), nil
+}
+
+// TableToOCFShards exports the data from a specified table to a set of OCF (Object Container File) files.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - table: The name of the table to export.
+//   - dir: The directory path where the OCF files will be saved.
+//   - prefix: A string to be prepended to the table name in the output file names.
+//   - rowsPerShard: The maximum number of records written to each file.
+//   - enhancer: An Enhancer interface for modifying schemas and data (can be nil).
+//
+// Returns:
+//   - []string: A slice of strings containing the paths of the shard files in order.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// The shards are named prefix + table + ".0000.avro", ".0001.avro" and so on, and all
+// embed the same schema. Rows are sorted by the primary key of the table, or by rowid
+// if it has none, so the same rows always land in the same shard. An empty table
+// is exported as a single shard holding no records.
+// If the export fails, no shard is left on disk.
+func TableToOCFShards(db *sql.DB, table, dir, prefix string, rowsPerShard int64, enhancer Enhancer) ([]string, error) {
+	files := []string{}
+	if rowsPerShard <= 0 {
+		return files, fmt.Errorf("rowsPerShard must be positive, got %d", rowsPerShard)
+	}
+
+	savePath, err := filepath.Abs(dir)
+	if err != nil {
+		return files, err
+	}
+	export, err := newTableExport(db, table, ExportOptions{Enhancer: enhancer}, true)
+	if err != nil {
+		return files, err
+	}
+
+	for shard := 0; shard == 0 || int64(shard)*rowsPerShard < int64(len(export.data)); shard++ {
+		start := int64(shard) * rowsPerShard
+		end := min(start+rowsPerShard, int64(len(export.data)))
+		fileName := filepath.Join(savePath, shardFileName(prefix, table, shard))
+		if err := export.writeShard(fileName, export.data[start:end]); err != nil {
+			// the shards already written are removed with the failed one
+			for _, f := range files {
+				os.Remove(f)
+			}
+			return []string{}, err
+		}
+		files = append(files, fileName)
+	}
+	return files, nil
+}
+
+// writeShard writes rows to a new OCF file. A shard that fails is removed.
+func (e *tableExport) writeShard(fileName string, rows []map[string]any) error {
+	encOpts, err := e.encoderOptions()
+	if err != nil {
+		return err
+	}
+	return writeFile(fileName, func(f *os.File) error {
+		enc, err := ocf.NewEncoder(e.avroSchema.String(), f, encOpts...)
+		if err != nil {
+			return err
+		}
+		defer enc.Close()
+
+		ctx := e.opts.context()
+		for _, row := range rows {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			record, err := e.record(row)
+			if err != nil {
+				return err
+			}
+			if record == nil {
+				continue
+			}
+			if err := enc.Encode(record); err != nil {
+				return encodeError(e.avroSchema, e.schema.Table, e.next-1, record, err)
+			}
+		}
+		// the encoder is closed before the file to write its last block
+		return enc.Close()
+	})
+}
+
+// RecordDocMetadataKey is the key of the OCF metadata holding the doc of the
+// Avro record, see ExportOptions.RecordDoc.
+const RecordDocMetadataKey = "avrosqlite.doc"
+
+// recordDoc returns the doc describing the provenance of a table exported now.
+func recordDoc(q Querier, table string) (string, error) {
+	file, err := databaseFile(q)
+	if err != nil {
+		return "", err
+	}
+	exported := time.Now().UTC().Format(time.RFC3339)
+	if file == "" {
+		return fmt.Sprintf("Table %s of an in-memory database, exported at %s", table, exported), nil
+	}
+	return fmt.Sprintf("Table %s of database %s, exported at %s", table, file, exported), nil
+}
+
+// encoderOptions returns the options of the OCF encoder of the table.
+func (e *tableExport) encoderOptions() ([]ocf.EncoderFunc, error) {
+	encOpts, err := e.opts.encoderOptions()
+	if err != nil || e.doc == "" {
+		return encOpts, err
+	}
+	return append(encOpts, ocf.WithMetadata(map[string][]byte{RecordDocMetadataKey: []byte(e.doc)})), nil
+}
+
+// TableToOCFBytes returns the data from a specified table as an in-memory OCF (Object Container File).
+//
+// It is equivalent to TableToOCFWriter writing to a bytes.Buffer and is useful
+// for tests and for sending a table over a message queue without a temp file.
+func TableToOCFBytes(db *sql.DB, table string, enhancer Enhancer) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := TableToOCFWriter(db, table, &buf, enhancer); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// TableToOCFUnion writes the data from several tables as a single OCF (Object Container File) to w.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - tables: The names of the tables to export.
+//   - w: The io.Writer the OCF is written to.
+//   - enhancer: An Enhancer interface for modifying the schema and data (can be nil).
+//
+// Returns:
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// The schema of the OCF is a union of the record schemas of the tables, and each row is
+// written to the branch of its table, one table after the other. Decoding a record into a
+// map[string]any, for example with ReadAvro, returns a map with a single entry keyed by the
+// full name of the record, such as "com.github.britt.avrosqlite.foo", holding the row.
+// Within the row, non-null values of nullable columns are in turn maps keyed by their Avro type.
+//
+// Avro requires the records of a union to have distinct names, so each table can only be
+// given once. OCFToTable cannot load the resulting file, it expects a single record schema.
+func TableToOCFUnion(db *sql.DB, tables []string, w io.Writer, enhancer Enhancer) error {
 	if enhancer == nil {
 		enhancer = &noopEnhancer{}
 	}
 
-	schema, err := ReadSchema(db, table)
+	schemas := make([]*SqliteSchema, 0, len(tables))
+	records := make([]avro.Schema, 0, len(tables))
+	names := make([]map[string]string, 0, len(tables))
+	for _, table := range tables {
+		schema, err := ReadSchema(db, table)
+		if err != nil {
+			return err
+		}
+		err = enhancer.Schema(schema)
+		if err != nil {
+			return err
+		}
+
+		record, err := schema.ToAvroWithOptions(AvroOptions{SanitizeNames: true})
+		if err != nil {
+			return err
+		}
+		fieldNames, err := schema.AvroFieldNames(AvroOptions{SanitizeNames: true})
+		if err != nil {
+			return err
+		}
+		schemas = append(schemas, schema)
+		records = append(records, record)
+		names = append(names, fieldNames)
+	}
+
+	union, err := avro.NewUnionSchema(records)
+	if err != nil {
+		return fmt.Errorf("failed to create union of tables: [%w]", err)
+	}
+
+	enc, err := ocf.NewEncoder(union.String(), w)
+	if err != nil {
+		return err
+	}
+	defer enc.Close()
+
+	for i, schema := range schemas {
+		name := records[i].(*avro.RecordSchema).FullName()
+
+		data, err := LoadData(db, schema.Table)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range data {
+			err = enhancer.Row(row)
+			if err != nil {
+				return err
+			}
+
+			err = enc.Encode(map[string]any{name: toAvroRecord(names[i], row)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return enc.Flush()
+}
+
+// OCFToTable loads an OCF (Object Container File) read from r into a table.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - r: An io.Reader providing the OCF data to be loaded.
+//   - table: The name of the table to load into. If empty, the name of the Avro record is used.
+//
+// Returns:
+//   - int64: The number of records successfully inserted into the database.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// If the table does not exist, it is created from the Avro schema embedded in the
+// OCF using AvroToSqliteSchema. If it does, it is truncated as with LoadAvro and
+// the embedded schema is reconciled with it: fields are loaded into the columns
+// of the same name, columns missing from the OCF take their default and fields
+// with no column are dropped. Dates and times are stored in the form of the type
+// of the field they were written with, whatever the type of their column.
+// See OCFToTableWithOptions.
+// A gzip compressed OCF, such as a .avro.gz file, is decompressed transparently.
+// A zstd compressed OCF fails with ErrZstdUnsupported, it can only be loaded with
+// a LoadOptions.Decompress function supplied to OCFToTableWithOptions.
+func OCFToTable(db *sql.DB, r io.Reader, table string) (int64, error) {
+	return OCFToTableWithOptions(db, r, table, LoadOptions{})
+}
+
+// OCFToTableWithOptions loads an OCF (Object Container File) read from r into a
+// table using the given options. See OCFToTable.
+//
+// The decisions reconciling the embedded schema with an existing table are
+// reported to the Logger of opts. With StrictFields, fields of the OCF with no
+// column are an error instead of being dropped. The WriterSchema of opts is not
+// used, the records are decoded with the embedded schema. The Decompress of opts
+// must be set to load zstd compressed input, see LoadOptions.Decompress.
+func OCFToTableWithOptions(db *sql.DB, r io.Reader, table string, opts LoadOptions) (int64, error) {
+	r, err := decompress(r, opts.Decompress)
+	if err != nil {
+		return 0, err
+	}
+	dec, err := ocf.NewDecoder(r)
+	if err != nil {
+		return 0, err
+	}
+
+	avroSchema, err := avro.Parse(string(dec.Metadata()[ocfSchemaKey]))
+	if err != nil {
+		return 0, err
+	}
+
+	schema, err := AvroToSqliteSchema(avroSchema)
+	if err != nil {
+		return 0, err
+	}
+	if table != "" {
+		schema.Table = table
+		schema.Sql, err = schema.GenerateSQL()
+		if err != nil {
+			return 0, err
+		}
+	}
+	exists, err := tableExists(db, schema.Table)
+	if err != nil {
+		return 0, err
+	}
+	var decoder recordDecoder = &ocfRecordDecoder{dec: dec}
+	if exists {
+		schema, err = reconcileSchema(db, schema.Table, avroSchema, opts)
+		if err != nil {
+			return 0, err
+		}
+		// the columns may not have the types of the fields the records were written with
+		decoder = newWriterTypedDecoder(decoder, avroSchema.(*avro.RecordSchema))
+	}
+
+	result, err := loadRecords(db, schema, decoder, opts)
+	return result.Inserted, err
+}
+
+// OCFBytesToTable loads an in-memory OCF (Object Container File) into a table.
+// It is the counterpart of TableToOCFBytes, see OCFToTable for details.
+func OCFBytesToTable(db *sql.DB, data []byte, table string) (int64, error) {
+	return OCFToTable(db, bytes.NewReader(data), table)
+}
+
+// RecodeOCF copies the records of an OCF (Object Container File) to a new OCF compressed with another codec.
+//
+// Parameters:
+//   - in: An io.Reader providing the OCF to recode. Gzip compressed input is detected and decompressed.
+//   - out: The io.Writer the new OCF is written to.
+//   - codec: The codec of the new OCF: "null", "deflate" or "snappy", the codecs supported by hamba/avro.
+//
+// Returns:
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// The new OCF has the schema and the user metadata of the input. No database is involved.
+func RecodeOCF(in io.Reader, out io.Writer, codec string) error {
+	r, err := decompress(in, nil)
 	if err != nil {
 		return err
 	}
-	err = enhancer.Schema(schema)
+	dec, err := ocf.NewDecoder(r)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to read OCF: [%w]", err)
 	}
-
-	avroSchema, err := schema.ToAvro()
+	// the avro.* keys, such as the schema and the codec, are set by the encoder
+	meta := map[string][]byte{}
+	for key, value := range dec.Metadata() {
+		if !strings.HasPrefix(key, "avro.") {
+			meta[key] = value
+		}
+	}
+	enc, err := ocf.NewEncoder(string(dec.Metadata()[ocfSchemaKey]), out, ocf.WithCodec(ocf.CodecName(codec)), ocf.WithMetadata(meta))
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to create OCF encoder: [%w]", err)
 	}
 
-	f, err := os.Create(fileName)
-	if err != nil {
+	for dec.HasNext() {
+		var record any
+		if err := dec.Decode(&record); err != nil {
+			return err
+		}
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	if err := dec.Error(); err != nil {
 		return err
 	}
-	defer f.Close()
+	return enc.Close()
+}
 
-	enc, err := ocf.NewEncoder(avroSchema.String(), f)
+// OCFShardsToTable loads a set of OCF (Object Container File) files into a single table.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - glob: A filepath.Match pattern matching the files to load, such as "out/foo.*.avro".
+//   - table: The name of the table to load into. If empty, the name of the Avro record is used.
+//
+// Returns:
+//   - int64: The total number of records successfully inserted into the database.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// It is the counterpart of TableToOCFShards. The files are loaded in the order of their
+// sorted names, in a single transaction, and must all embed the same schema.
+// See OCFToTable for details.
+func OCFShardsToTable(db *sql.DB, glob, table string) (int64, error) {
+	fileNames, err := filepath.Glob(glob)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
-	defer enc.Close()
-
-	data, err := LoadData(db, table)
-	if err != nil {
-		return err
+	if len(fileNames) == 0 {
+		return 0, fmt.Errorf("no files match %s", glob)
 	}
+	sort.Strings(fileNames)
 
-	for _, row := range data {
-		err = enhancer.Row(row)
+	decoders := []recordDecoder{}
+	var schemaJSON string
+	for _, fileName := range fileNames {
+		f, err := os.Open(fileName)
 		if err != nil {
-			return err
+			return 0, fmt.Errorf("failed to open OCF file: [%w]", err)
 		}
+		defer f.Close()
 
-		err = enc.Encode(row)
+		r, err := decompress(f, nil)
 		if err != nil {
-			return err
+			return 0, fmt.Errorf("failed to read OCF file %s: [%w]", fileName, err)
+		}
+		dec, err := ocf.NewDecoder(r)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read OCF file %s: [%w]", fileName, err)
+		}
+		shardSchema := string(dec.Metadata()[ocfSchemaKey])
+		if len(decoders) == 0 {
+			schemaJSON = shardSchema
+		} else if shardSchema != schemaJSON {
+			return 0, fmt.Errorf("schema of %s differs from the schema of %s", fileName, fileNames[0])
 		}
+		decoders = append(decoders, &ocfRecordDecoder{dec: dec})
 	}
 
-	if err := enc.Flush(); err != nil {
-		return err
+	avroSchema, err := avro.Parse(schemaJSON)
+	if err != nil {
+		return 0, err
+	}
+	schema, err := AvroToSqliteSchema(avroSchema)
+	if err != nil {
+		return 0, err
+	}
+	if table != "" {
+		schema.Table = table
+		schema.Sql, err = schema.GenerateSQL()
+		if err != nil {
+			return 0, err
+		}
 	}
 
-	if err := f.Sync(); err != nil {
-		return err
+	result, err := loadRecords(db, schema, &shardRecordDecoder{decoders: decoders}, LoadOptions{})
+	return result.Inserted, err
+}
+
+// shardRecordDecoder decodes the records of a sequence of decoders one after the other.
+type shardRecordDecoder struct {
+	decoders []recordDecoder
+}
+
+func (d *shardRecordDecoder) Decode(v any) error {
+	for len(d.decoders) > 0 {
+		err := d.decoders[0].Decode(v)
+		if err != io.EOF {
+			return err
+		}
+		d.decoders = d.decoders[1:]
 	}
+	return io.EOF
+}
 
-	return nil
+// ocfSchemaKey is the OCF header metadata key holding the writer schema.
+const ocfSchemaKey = "avro.schema"
+
+// ocfRecordDecoder adapts an ocf.Decoder to the recordDecoder interface.
+type ocfRecordDecoder struct {
+	dec *ocf.Decoder
+}
+
+func (d *ocfRecordDecoder) Decode(v any) error {
+	if !d.dec.HasNext() {
+		if err := d.dec.Error(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	return d.dec.Decode(v)
 }
 
 // TableToJSON writes the schema of a specified table to a JSON file.
@@ -116,39 +938,439 @@ func TableToOCF(db *sql.DB, table, fileName string, enhancer Enhancer) error {
 // This function reads the schema from the specified table, applies any enhancements,
 // and writes the resulting schema to a JSON file.
 func TableToJSON(db *sql.DB, table, fileName string, enhancer Enhancer) error {
+	return tableToJSON(db, table, fileName, ExportOptions{Enhancer: enhancer})
+}
+
+// tableToJSON writes the schema of a table to a JSON file using the given options. See TableToJSON.
+func tableToJSON(q Querier, table, fileName string, opts ExportOptions) error {
+	return writeFile(fileName, func(f *os.File) error {
+		return tableToJSONWriter(q, table, f, opts)
+	})
+}
+
+// TableToJSONWriter writes the schema of a specified table as JSON to w.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - table: The name of the table whose schema is to be exported.
+//   - w: The io.Writer the JSON is written to.
+//   - enhancer: An Enhancer interface for modifying the schema (can be nil).
+//
+// Returns:
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// This function reads the schema from the specified table, applies any enhancements,
+// and writes the resulting schema to w. It does not close w.
+func TableToJSONWriter(db *sql.DB, table string, w io.Writer, enhancer Enhancer) error {
+	return tableToJSONWriter(db, table, w, ExportOptions{Enhancer: enhancer})
+}
+
+// tableToJSONWriter writes the schema of a table as JSON to w using the given options.
+// See TableToJSONWriter.
+func tableToJSONWriter(q Querier, table string, w io.Writer, opts ExportOptions) error {
+	b, err := tableSchemaJSON(q, table, opts)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// DatabaseSchemaToJSON writes the schemas of all tables in a database to a single JSON file.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - fileName: The path and name of the JSON file to be created.
+//   - enhancer: An Enhancer interface for modifying the schemas (can be nil).
+//
+// Returns:
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// The file holds a JSON array with the SqliteSchema of every table, in the order
+// returned by ListTables. No data is exported, which makes the file a lightweight
+// snapshot of the database structure that can be diffed.
+func DatabaseSchemaToJSON(db *sql.DB, fileName string, enhancer Enhancer) error {
 	if enhancer == nil {
 		enhancer = &noopEnhancer{}
 	}
 
-	schema, err := ReadSchema(db, table)
+	tables, err := ListTables(db)
 	if err != nil {
 		return err
 	}
-	err = enhancer.Schema(schema)
+	schemas := make([]*SqliteSchema, 0, len(tables))
+	for _, table := range tables {
+		schema, err := ReadSchema(db, table)
+		if err != nil {
+			return err
+		}
+		if err := enhancer.Schema(schema); err != nil {
+			return err
+		}
+		schemas = append(schemas, schema)
+	}
+
+	b, err := json.MarshalIndent(schemas, "", "  ")
 	if err != nil {
+		return fmt.Errorf("failed to marshal database schema: [%w]", err)
+	}
+	return writeFile(fileName, func(f *os.File) error {
+		_, err := f.Write(b)
 		return err
+	})
+}
+
+// tableSchemaJSON reads the schema of a table, applies the enhancer and returns it as JSON.
+func tableSchemaJSON(q Querier, table string, opts ExportOptions) ([]byte, error) {
+	enhancer := opts.Enhancer
+	if enhancer == nil {
+		enhancer = &noopEnhancer{}
 	}
 
-	b, err := json.Marshal(schema)
+	schema, err := readSchema(q, table, opts.logger())
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if opts.IncludeRowID {
+		schema.addRowID()
+	}
+	if err := opts.applyNullability(q, schema); err != nil {
+		return nil, err
+	}
+	opts.ExternalizeBlobs.applySchema(schema)
+	if opts.IncludeSequence {
+		schema.Sequence, err = readSequence(q, table)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = enhancer.Schema(schema)
+	if err != nil {
+		return nil, err
 	}
+	opts.Overrides[table].applySchema(schema)
 
-	f, err := os.Create(fileName)
+	return json.Marshal(schema)
+}
+
+// ExportOptions controls how SqliteToAvroWithOptions exports a database
+// and how TableToOCFWithOptions exports a table.
+type ExportOptions struct {
+	// Prefix is prepended to each table name in the output file names.
+	Prefix string
+	// IncludeJSON also saves a JSON version of each table's schema.
+	IncludeJSON bool
+	// Enhancer modifies schemas and data before they are written (can be nil).
+	Enhancer Enhancer
+	// BundlePath, if set, writes every output file as an entry of a single gzipped
+	// tar archive at this path instead of writing them individually.
+	// A relative BundlePath is resolved against the export directory.
+	BundlePath string
+	// IncludeRowID adds the rowid of each row as a rowid INTEGER field.
+	// It is ignored for WITHOUT ROWID tables and tables with a column named rowid.
+	IncludeRowID bool
+	// MixedTypes controls how columns holding values of more than one storage class are exported.
+	MixedTypes MixedTypes
+	// BigIntegers exports INTEGER columns holding integers that do not fit in an
+	// int64 as a union of a long and a string, see DetectBigIntegers. Those
+	// integers are written as strings of their decimal digits, the others as longs.
+	// Loaded back into an INTEGER column, the strings are converted to REAL by SQLite.
+	BigIntegers bool
+	// BlockLength is the number of records written to each OCF block. If 0, the
+	// encoder's default of 100 is used. A block is the unit of compression and the
+	// finest granularity a reader can seek to using the sync markers between blocks:
+	// longer blocks compress better, shorter blocks let readers skip to a record
+	// with less decoding. The sync marker itself is chosen by the encoder.
+	BlockLength int
+	// Overrides holds the schema overrides of each table, keyed by table name.
+	// They are applied after the Enhancer, see LoadSchemaOverrides.
+	Overrides map[string]SchemaOverride
+	// Manifest also writes a manifest.json describing every exported table,
+	// see Manifest. Its path is the last of the returned files. With BundlePath
+	// it is written to the archive instead.
+	Manifest bool
+	// ContinueOnError exports the remaining tables when a table fails instead of
+	// stopping. The returned error is then an *ExportError listing every failed
+	// table, and the returned files are those written successfully.
+	ContinueOnError bool
+	// TableRename maps table names to the names of the Avro records they are
+	// exported as. The output files keep the name of the table.
+	TableRename map[string]string
+	// ColumnRename maps table names to a mapping of their column names to the
+	// Avro field names the columns are exported as. The data is still read from
+	// the original columns. It takes precedence over the renames of Overrides.
+	ColumnRename map[string]map[string]string
+	// ValueTransforms maps column types to functions transforming the values of
+	// columns of that type before they are encoded, for example to redact every
+	// TEXT column. They are applied after the Enhancer, using the types of the
+	// schema it returned. NULL values are not transformed.
+	ValueTransforms map[SqliteType]func(any) (any, error)
+	// ExcludeTables lists tables that are not exported, in addition to the SQLite
+	// system tables always left out. Entries are table names or path.Match patterns,
+	// such as "*_fts_*". Like SQLite identifiers, they are matched regardless of case.
+	ExcludeTables []string
+	// Consistent exports every table in a single read transaction on one connection,
+	// so that all tables reflect the same snapshot of the database even if it is
+	// written to during the export. It requires the database to be in WAL mode,
+	// otherwise writers are blocked until the export completes, and it holds a
+	// read lock for the whole export, which keeps the WAL from being checkpointed
+	// past the snapshot.
+	Consistent bool
+	// PageSize, if positive, reads the rows of each table in pages of at most this
+	// many rows, ordered by the primary key of the table, or by rowid if it has none.
+	// Each page is encoded before the next one is read, so the table is neither
+	// held in memory nor read with a single long-running query: other connections
+	// can write to the database between pages. Each page after the first starts
+	// after the key of the last row read, with WHERE key > ? ORDER BY key LIMIT ?,
+	// which the index of the key answers without scanning the rows before it.
+	// Tables whose key the pages cannot start after, such as a primary key with a
+	// DATE column or holding NULLs, are read with LIMIT and OFFSET instead, which
+	// gets slower with every page. In exchange for not holding a read lock, unless
+	// Consistent is set, rows written during the export may be missed, and with
+	// OFFSET exported twice, as they shift the offsets of the following pages.
+	// With Consistent, every page is read from the same snapshot, and the read
+	// lock is held for the whole export. If 0, the whole table is read at once.
+	PageSize int
+	// MaxTextBytes is the maximum length in bytes of TEXT values, and MaxBlobBytes
+	// that of BLOB values. Longer values are handled as selected by OnOversize.
+	// If 0, the length is not limited.
+	MaxTextBytes int
+	MaxBlobBytes int
+	// OnOversize selects what happens to values longer than MaxTextBytes or MaxBlobBytes.
+	OnOversize OversizePolicy
+	// ReportOversize, if set, is called for each value truncated or row skipped
+	// by OnOversize, with the table, the column and the row of the value.
+	ReportOversize func(*OversizeValueError)
+	// NullabilityOverrides maps table names to a mapping of their column names to
+	// whether the columns are exported as nullable, overriding the NOT NULL
+	// constraints read from the table. Making a column non-nullable saves the
+	// union of its Avro type with null. They are applied before the Enhancer.
+	NullabilityOverrides map[string]map[string]bool
+	// InferNullability exports each column as nullable only if it holds NULL
+	// values, whatever its NOT NULL constraint, which gives tighter schemas for
+	// clean data. It scans every table once before its export. NullabilityOverrides
+	// are applied after it.
+	InferNullability bool
+	// ExternalizeBlobs, if set, writes each value of the BLOB columns to its own
+	// file and exports the path of the file instead, which keeps large BLOBs such
+	// as images out of the OCF. The columns are exported as TEXT, with Avro type
+	// string, and marked with the ExternalBlobProp property in the JSON schema.
+	// The files are not part of the returned files, and loading does not read
+	// them back yet: the paths are loaded as they are.
+	ExternalizeBlobs *ExternalBlobs
+	// NullPolicy selects what happens to NULL values of non-nullable fields,
+	// such as columns made non-nullable by NullabilityOverrides.
+	NullPolicy NullPolicy
+	// Logger receives warnings about the export, such as invalid column defaults,
+	// truncated values or tables skipped by ContinueOnError. If nil, they are discarded.
+	Logger *slog.Logger
+	// IncludeSequence records the AUTOINCREMENT high-water mark of each table, kept
+	// in sqlite_sequence, in its JSON schema and in the manifest. AvroDirToSqlite
+	// and LoadAvro restore it from the schema.
+	IncludeSequence bool
+	// Checkpoint runs CheckpointWAL before the export so that the database file
+	// holds every committed transaction, see CheckpointWAL for when it is needed.
+	// If the checkpoint fails, for example because of other readers, a warning is
+	// logged and the export goes on: its content is complete either way.
+	Checkpoint bool
+	// Codec is the compression codec of the OCF files: "null", "deflate" or
+	// "snappy", the codecs supported by hamba/avro. If empty, files are not compressed.
+	Codec string
+	// Namespace replaces AvroNamespace as the namespace of the Avro records.
+	// The Namespace of a table's SchemaOverride takes precedence over it.
+	Namespace string
+	// RecordDoc sets the doc of the Avro record of each table to its provenance:
+	// the table, the file of the database and the time of the export, for data
+	// catalogs. hamba/avro writes the canonical form of the schema to the OCF
+	// header, which has no doc, so the doc is also written to the OCF metadata
+	// under RecordDocMetadataKey. The schema and its fingerprint are unchanged.
+	RecordDoc bool
+	// Concurrency is the number of tables exported at once by SqliteToAvroWithOptions.
+	// If a table fails, the tables already started are still exported. It is
+	// ignored when Consistent or BundlePath is set. If less than 2, tables are
+	// exported one after the other. Otherwise the Enhancer and the callbacks of
+	// the options are called from several goroutines and must be safe for it.
+	Concurrency int
+	// Context, if set, cancels the export when it is done. The export stops
+	// between rows and returns the error of the context.
+	Context context.Context
+}
+
+// context returns the Context of the options, or context.Background if it is nil.
+func (opts ExportOptions) context() context.Context {
+	if opts.Context == nil {
+		return context.Background()
+	}
+	return opts.Context
+}
+
+// logger returns the Logger of the options, or a logger discarding messages.
+func (opts ExportOptions) logger() *slog.Logger {
+	return orDiscard(opts.Logger)
+}
+
+// NullPolicy controls how NULL values of non-nullable fields are exported.
+type NullPolicy int
+
+const (
+	// NullError stops the export with an error naming the table, the column and the row.
+	NullError NullPolicy = iota
+	// NullDefault replaces NULL values with the default of the field, see SchemaField.AvroDefault.
+	// Fields without a default, such as dates, are still an error.
+	NullDefault
+)
+
+// applyNullability infers the nullability of the columns of the table if
+// InferNullability is set, then applies the NullabilityOverrides of the table
+// to its schema.
+func (opts ExportOptions) applyNullability(q Querier, schema *SqliteSchema) error {
+	if opts.InferNullability {
+		if err := inferNullability(q, schema); err != nil {
+			return err
+		}
+	}
+	overrides, ok := opts.NullabilityOverrides[schema.Table]
+	if !ok {
+		return nil
+	}
+	for i, f := range schema.Fields {
+		if nullable, ok := overrides[f.Name]; ok {
+			schema.Fields[i].Nullable = nullable
+		}
+	}
+	return nil
+}
+
+// inferNullability makes the columns of the table described by schema nullable
+// if they hold NULL values, and non-nullable otherwise. The NULL values of every
+// column are counted in a single scan of the table.
+func inferNullability(q Querier, schema *SqliteSchema) error {
+	counts := make([]string, len(schema.Fields))
+	for i, f := range schema.Fields {
+		counts[i] = fmt.Sprintf("COUNT(*) - COUNT(%s)", quoteIdentifier(f.Name))
+	}
+	rows, err := q.Query(fmt.Sprintf("SELECT %s FROM %s", strings.Join(counts, ", "), quoteTableName(schema.Table)))
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to count NULL values: [%w]", err)
 	}
-	defer f.Close()
+	defer rows.Close()
 
-	if _, err = f.Write(b); err != nil {
-		return err
+	nulls := make([]int64, len(schema.Fields))
+	dest := make([]any, len(nulls))
+	for i := range nulls {
+		dest[i] = &nulls[i]
 	}
-	if err := f.Sync(); err != nil {
+	if rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
 		return err
 	}
+	for i := range schema.Fields {
+		schema.Fields[i].Nullable = nulls[i] > 0
+	}
+	return nil
+}
+
+// applyNulls applies the NullPolicy to the NULL values of the non-nullable fields
+// of row, the index-th row of the table.
+func (e *tableExport) applyNulls(index int64, row map[string]any) error {
+	for _, f := range e.schema.Fields {
+		if f.Nullable || f.Type == SqliteAny || row[f.Name] != nil {
+			continue
+		}
+		if e.opts.NullPolicy == NullDefault {
+			if v := f.AvroDefault(); v != avro.NoDefault && v != nil {
+				if b, ok := v.(string); ok && f.Type == SqliteBlob {
+					v = []byte(b)
+				}
+				e.opts.logger().Warn("NULL value replaced by the default", "table", e.schema.Table, "column", f.Name, "row", index, "value", v)
+				row[f.Name] = v
+				continue
+			}
+		}
+		return fmt.Errorf("column %s of row %d of table %s is NULL but not nullable", f.Name, index, e.schema.Table)
+	}
+	return nil
+}
+
+// exportedTables returns the tables of the database that are not excluded.
+func (opts ExportOptions) exportedTables(q Querier) ([]string, error) {
+	tables, err := listTables(q)
+	if err != nil {
+		return []string{}, err
+	}
+	exported := []string{}
+	for _, table := range tables {
+		excluded, err := matchesTable(table, opts.ExcludeTables)
+		if err != nil {
+			return []string{}, err
+		}
+		if !excluded {
+			exported = append(exported, table)
+		}
+	}
+	return exported, nil
+}
+
+// transformRow applies the ValueTransforms to the values of a row.
+func (opts ExportOptions) transformRow(schema *SqliteSchema, row map[string]any) error {
+	if len(opts.ValueTransforms) == 0 {
+		return nil
+	}
+	for _, f := range schema.Fields {
+		transform, ok := opts.ValueTransforms[f.Type]
+		if !ok || row[f.Name] == nil {
+			continue
+		}
+		v, err := transform(row[f.Name])
+		if err != nil {
+			return fmt.Errorf("failed to transform column %q: [%w]", f.Name, err)
+		}
+		row[f.Name] = v
+	}
 	return nil
 }
 
+// renameOptions adds the column renames of a table to the options used to convert its schema to Avro.
+func (opts ExportOptions) renameOptions(table string, avroOpts AvroOptions) AvroOptions {
+	renames, ok := opts.ColumnRename[table]
+	if !ok {
+		return avroOpts
+	}
+
+	merged := map[string]string{}
+	for column, name := range avroOpts.Renames {
+		merged[column] = name
+	}
+	for column, name := range renames {
+		merged[column] = name
+	}
+	avroOpts.Renames = merged
+	return avroOpts
+}
+
+// encoderOptions returns the OCF encoder options selected by opts.
+func (opts ExportOptions) encoderOptions() ([]ocf.EncoderFunc, error) {
+	encOpts := []ocf.EncoderFunc{}
+	if opts.BlockLength < 0 {
+		return nil, fmt.Errorf("block length must be positive, got %d", opts.BlockLength)
+	}
+	if opts.BlockLength > 0 {
+		encOpts = append(encOpts, ocf.WithBlockLength(opts.BlockLength))
+	}
+	switch ocf.CodecName(opts.Codec) {
+	case "":
+	case ocf.Null, ocf.Deflate, ocf.Snappy:
+		encOpts = append(encOpts, ocf.WithCodec(ocf.CodecName(opts.Codec)))
+	default:
+		return nil, fmt.Errorf("unsupported codec %q, want null, deflate or snappy", opts.Codec)
+	}
+	return encOpts, nil
+}
+
 // SqliteToAvro exports data from a SQLite database to a set of OCF (Object Container File) files.
 //
 // Parameters:
@@ -166,9 +1388,48 @@ func TableToJSON(db *sql.DB, table, fileName string, enhancer Enhancer) error {
 // It optionally includes JSON schema files. The function is not atomic, and errors
 // may result in incomplete sets of files.
 func SqliteToAvro(db *sql.DB, path, prefix string, includeJSON bool, enhancer Enhancer) ([]string, error) {
+	return SqliteToAvroWithOptions(db, path, ExportOptions{
+		Prefix:      prefix,
+		IncludeJSON: includeJSON,
+		Enhancer:    enhancer,
+	})
+}
+
+// SqliteToAvroWithOptions exports data from a SQLite database to a set of OCF (Object Container File) files.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - path: The directory path where the OCF files will be saved.
+//   - opts: The ExportOptions controlling the export.
+//
+// Returns:
+//   - []string: A slice of strings containing the paths of all created files.
+//     When opts.BundlePath is set it only contains the path of the archive.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// See SqliteToAvro for details.
+func SqliteToAvroWithOptions(db *sql.DB, path string, opts ExportOptions) ([]string, error) {
 	files := []string{}
 
-	tables, err := ListTables(db)
+	if opts.Checkpoint {
+		if err := CheckpointWAL(db); err != nil {
+			opts.logger().Warn("exporting without a WAL checkpoint", "error", err)
+		}
+	}
+
+	var q Querier = db
+	if opts.Consistent {
+		// SQLite transactions are deferred: the snapshot is taken by the first read
+		// and kept until the transaction ends
+		tx, err := db.BeginTx(opts.context(), nil)
+		if err != nil {
+			return files, fmt.Errorf("failed to begin read transaction: [%w]", err)
+		}
+		defer tx.Rollback()
+		q = tx
+	}
+
+	tables, err := opts.exportedTables(q)
 	if err != nil {
 		return files, err
 	}
@@ -178,22 +1439,184 @@ func SqliteToAvro(db *sql.DB, path, prefix string, includeJSON bool, enhancer En
 		return files, err
 	}
 
-	for _, table := range tables {
-		fileName := filepath.Join(savePath, fmt.Sprintf("%s%s.avro", prefix, table))
-		err := TableToOCF(db, table, fileName, enhancer)
+	if opts.BundlePath != "" {
+		return bundleTables(q, tables, savePath, opts)
+	}
+
+	concurrency := opts.Concurrency
+	if opts.Consistent {
+		// a transaction runs one statement at a time
+		concurrency = 1
+	}
+	results := exportTables(q, tables, savePath, opts, concurrency)
+
+	manifest := &Manifest{Tables: []ManifestTable{}}
+	manifestPath := filepath.Join(savePath, ManifestFileName)
+	failed := map[string]error{}
+	// wait returns once the tables still running after the i-th one are done
+	wait := func(i int) {
+		for _, result := range results[i+1:] {
+			files = append(files, (<-result).files...)
+		}
+	}
+	for i, table := range tables {
+		result := <-results[i]
+		files = append(files, result.files...)
+		manifest.Tables = append(manifest.Tables, result.manifest.Tables...)
+		err := result.err
 		if err != nil {
-			return files, err
+			if !opts.ContinueOnError || opts.context().Err() != nil {
+				wait(i)
+				return files, err
+			}
+			opts.logger().Warn("table export failed", "table", table, "error", err)
+			failed[table] = err
+			continue
 		}
-		files = append(files, fileName)
-		if includeJSON {
-			jsonFileName := filepath.Join(savePath, fmt.Sprintf("%s%s.json", prefix, table))
-			err := TableToJSON(db, table, jsonFileName, enhancer)
-			if err != nil {
+
+		// the manifest is rewritten as each table completes, so it describes
+		// the tables exported so far if a later table fails
+		if opts.Manifest {
+			if err := manifest.write(manifestPath); err != nil {
+				wait(i)
 				return files, err
 			}
-			files = append(files, jsonFileName)
 		}
 	}
+	if opts.Manifest {
+		files = append(files, manifestPath)
+	}
+	if len(failed) > 0 {
+		return files, &ExportError{Tables: failed}
+	}
+
+	return files, nil
+}
+
+// tableResult is the outcome of the export of a table by exportTables.
+type tableResult struct {
+	files    []string
+	manifest *Manifest
+	err      error
+}
+
+// exportTables exports tables with writeTableFiles, concurrency of them at once, and
+// returns a channel receiving the result of each table, in the order of tables.
+// Once a table fails, the tables not started yet are skipped unless
+// opts.ContinueOnError is set, and the export stops if the context of opts is done.
+func exportTables(q Querier, tables []string, savePath string, opts ExportOptions, concurrency int) []chan tableResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make([]chan tableResult, len(tables))
+	for i := range results {
+		results[i] = make(chan tableResult, 1)
+	}
+
+	var mu sync.Mutex
+	stopped := false
+	sem := make(chan struct{}, concurrency)
+	go func() {
+		for i, table := range tables {
+			sem <- struct{}{}
+			mu.Lock()
+			skip := stopped
+			mu.Unlock()
+			if err := opts.context().Err(); err != nil || skip {
+				<-sem
+				results[i] <- tableResult{manifest: &Manifest{}, err: err}
+				continue
+			}
+			go func(i int, table string) {
+				defer func() { <-sem }()
+				manifest := &Manifest{}
+				files, err := writeTableFiles(q, table, savePath, opts, manifest)
+				if err != nil && !opts.ContinueOnError {
+					mu.Lock()
+					stopped = true
+					mu.Unlock()
+				}
+				results[i] <- tableResult{files: files, manifest: manifest, err: err}
+			}(i, table)
+		}
+	}()
+	return results
+}
+
+// writeTableFiles writes the OCF file of a table, and its JSON schema file if requested,
+// to savePath and adds the table to the manifest. It returns the paths of the files
+// written successfully.
+func writeTableFiles(q Querier, table, savePath string, opts ExportOptions, manifest *Manifest) ([]string, error) {
+	files := []string{}
+
+	fileName := filepath.Join(savePath, ocfFileName(opts.Prefix, table))
+	stats, err := tableToOCFFile(q, table, fileName, opts)
+	if err != nil {
+		return files, err
+	}
+	files = append(files, fileName)
+
+	if opts.IncludeSequence {
+		stats.Sequence, err = readSequence(q, table)
+		if err != nil {
+			return files, err
+		}
+	}
+
+	jsonFile := ""
+	if opts.IncludeJSON {
+		jsonFile = jsonFileName(opts.Prefix, table)
+		err := tableToJSON(q, table, filepath.Join(savePath, jsonFile), opts)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, filepath.Join(savePath, jsonFile))
+	}
 
+	manifest.add(table, ocfFileName(opts.Prefix, table), jsonFile, stats)
 	return files, nil
 }
+
+// ExportError reports the tables that failed to export when ExportOptions.ContinueOnError is set.
+type ExportError struct {
+	// Tables maps the name of each failed table to its error.
+	Tables map[string]error
+}
+
+func (e *ExportError) Error() string {
+	tables := make([]string, 0, len(e.Tables))
+	for table := range e.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	msgs := make([]string, 0, len(tables))
+	for _, table := range tables {
+		msgs = append(msgs, fmt.Sprintf("%s: [%s]", table, e.Tables[table]))
+	}
+	return fmt.Sprintf("failed to export %d tables: %s", len(tables), strings.Join(msgs, ", "))
+}
+
+// Unwrap returns the errors of the failed tables, so they can be inspected with errors.Is and errors.As.
+func (e *ExportError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Tables))
+	for _, err := range e.Tables {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ocfFileName returns the name of the OCF file a table is exported to.
+func ocfFileName(prefix, table string) string {
+	return fmt.Sprintf("%s%s.avro", prefix, table)
+}
+
+// shardFileName returns the name of a shard file written by TableToOCFShards.
+func shardFileName(prefix, table string, shard int) string {
+	return fmt.Sprintf("%s%s.%04d.avro", prefix, table, shard)
+}
+
+// jsonFileName returns the name of the JSON file a table schema is exported to.
+func jsonFileName(prefix, table string) string {
+	return fmt.Sprintf("%s%s.json", prefix, table)
+}
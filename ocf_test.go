@@ -0,0 +1,1464 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hamba/avro"
+	"github.com/hamba/avro/ocf"
+)
+
+func TestTableToOCFBytes_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		table string
+	}{
+		{name: "foo", table: "foo"},
+		{name: "meats", table: "meats"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := TableToOCFBytes(testDB, tt.table, nil)
+			if err != nil {
+				t.Fatalf("TableToOCFBytes() error = %v", err)
+			}
+
+			db := newTestDB(t)
+			count, err := OCFBytesToTable(db, data, tt.table)
+			if err != nil {
+				t.Fatalf("OCFBytesToTable() error = %v", err)
+			}
+			if count != 3 {
+				t.Errorf("OCFBytesToTable() = %v, want %v", count, 3)
+			}
+
+			want, err := LoadData(testDB, tt.table)
+			if err != nil {
+				t.Fatalf("LoadData() error = %v", err)
+			}
+			got, err := LoadData(db, tt.table)
+			if err != nil {
+				t.Fatalf("LoadData() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("LoadData() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestOCFToTable_Truncates(t *testing.T) {
+	data, err := TableToOCFBytes(testDB, "foo", nil)
+	if err != nil {
+		t.Fatalf("TableToOCFBytes() error = %v", err)
+	}
+
+	db := newTestDB(t)
+	for i := 0; i < 2; i++ {
+		if _, err := OCFToTable(db, bytes.NewReader(data), "copy"); err != nil {
+			t.Fatalf("OCFToTable() error = %v", err)
+		}
+	}
+
+	got, err := LoadData(db, "copy")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	if len(got) != 3 {
+		t.Errorf("LoadData() = %v, want 3 rows", got)
+	}
+}
+
+func TestTableToOCFWriterWithOptions_IncludeRowID(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE things (name TEXT);
+		INSERT INTO things VALUES ('a'), ('b'), ('c');
+		DELETE FROM things WHERE name = 'b';
+		CREATE TABLE keyed (name TEXT PRIMARY KEY) WITHOUT ROWID;
+		INSERT INTO keyed VALUES ('a');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		table string
+		want  []any
+	}{
+		{name: "rowid table", table: "things", want: []any{int64(1), int64(3)}},
+		{name: "WITHOUT ROWID table", table: "keyed", want: []any{nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := TableToOCFWriterWithOptions(db, tt.table, &buf, ExportOptions{IncludeRowID: true})
+			if err != nil {
+				t.Fatalf("TableToOCFWriterWithOptions() error = %v", err)
+			}
+
+			got := readOCFValues(t, buf.Bytes(), rowIDColumn)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TableToOCFWriterWithOptions() rowids = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTableToOCFUnion(t *testing.T) {
+	var buf bytes.Buffer
+	if err := TableToOCFUnion(testDB, []string{"foo", "meats"}, &buf, nil); err != nil {
+		t.Fatalf("TableToOCFUnion() error = %v", err)
+	}
+
+	dec, err := ocf.NewDecoder(&buf)
+	if err != nil {
+		t.Fatalf("ocf.NewDecoder() error = %v", err)
+	}
+	got := []string{}
+	var first map[string]any
+	for dec.HasNext() {
+		var record map[string]any
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if first == nil {
+			first = record
+		}
+		for branch := range record {
+			got = append(got, branch)
+		}
+	}
+
+	foo, meats := AvroNamespace+".foo", AvroNamespace+".meats"
+	want := []string{foo, foo, foo, meats, meats, meats}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TableToOCFUnion() branches = %v, want %v", got, want)
+	}
+	wantFirst := map[string]any{foo: map[string]any{"id": map[string]any{"long": int64(1)}, "name": map[string]any{"string": "bar"}}}
+	if !reflect.DeepEqual(first, wantFirst) {
+		t.Errorf("TableToOCFUnion() first record = %v, want %v", first, wantFirst)
+	}
+
+	if err := TableToOCFUnion(testDB, []string{"foo", "foo"}, &bytes.Buffer{}, nil); err == nil {
+		t.Errorf("TableToOCFUnion() with a repeated table error = nil, want error")
+	}
+}
+
+func TestTableToJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	if err := TableToJSONWriter(testDB, "meats", &buf, nil); err != nil {
+		t.Fatalf("TableToJSONWriter() error = %v", err)
+	}
+
+	got := &SqliteSchema{}
+	if err := json.Unmarshal(buf.Bytes(), got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	want, err := ReadSchema(testDB, "meats")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	if got.Table != want.Table || got.Sql != want.Sql || len(got.Fields) != len(want.Fields) {
+		t.Fatalf("TableToJSONWriter() = %+v, want %+v", got, want)
+	}
+	for i, f := range got.Fields {
+		if f.Name != want.Fields[i].Name || f.Type != want.Fields[i].Type || f.Nullable != want.Fields[i].Nullable {
+			t.Errorf("TableToJSONWriter() field %d = %+v, want %+v", i, f, want.Fields[i])
+		}
+	}
+
+	if err := TableToJSONWriter(testDB, "missing", &buf, nil); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("TableToJSONWriter() error = %v, want %v", err, ErrTableNotFound)
+	}
+}
+
+func TestDatabaseSchemaToJSON(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "schema.json")
+	if err := DatabaseSchemaToJSON(testDB, fileName, nil); err != nil {
+		t.Fatalf("DatabaseSchemaToJSON() error = %v", err)
+	}
+
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	var got []SqliteSchema
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+
+	tables, err := ListTables(testDB)
+	if err != nil {
+		t.Fatalf("ListTables() error = %v", err)
+	}
+	if len(got) != len(tables) {
+		t.Fatalf("DatabaseSchemaToJSON() wrote %d schemas, want %d", len(got), len(tables))
+	}
+	for _, table := range []string{"foo", "meats"} {
+		want, err := ReadSchema(testDB, table)
+		if err != nil {
+			t.Fatalf("ReadSchema() error = %v", err)
+		}
+		var schema *SqliteSchema
+		for i := range got {
+			if got[i].Table == table {
+				schema = &got[i]
+			}
+		}
+		if schema == nil {
+			t.Fatalf("DatabaseSchemaToJSON() is missing table %s", table)
+		}
+		if schema.Sql != want.Sql || len(schema.Fields) != len(want.Fields) {
+			t.Fatalf("DatabaseSchemaToJSON() table %s = %+v, want %+v", table, schema, want)
+		}
+		for i, f := range schema.Fields {
+			if f.Name != want.Fields[i].Name || f.Type != want.Fields[i].Type {
+				t.Errorf("DatabaseSchemaToJSON() table %s field %d = %+v, want %+v", table, i, f, want.Fields[i])
+			}
+		}
+	}
+}
+
+func TestTableToOCFWriterWithOptions_BlockLength(t *testing.T) {
+	tests := []struct {
+		name        string
+		blockLength int
+		want        int
+		wantErr     bool
+	}{
+		{name: "default", blockLength: 0, want: 1},
+		{name: "one record per block", blockLength: 1, want: 3},
+		{name: "two records per block", blockLength: 2, want: 2},
+		{name: "negative", blockLength: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := TableToOCFWriterWithOptions(testDB, "meats", &buf, ExportOptions{BlockLength: tt.blockLength})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TableToOCFWriterWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var header ocf.Header
+			if err := avro.NewDecoderForSchema(ocf.HeaderSchema, bytes.NewReader(buf.Bytes())).Decode(&header); err != nil {
+				t.Fatalf("Decode() header error = %v", err)
+			}
+			// the sync marker follows the header and every block
+			if got := bytes.Count(buf.Bytes(), header.Sync[:]) - 1; got != tt.want {
+				t.Errorf("TableToOCFWriterWithOptions() blocks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// failingEnhancer fails to enhance the schema of one table.
+type failingEnhancer struct {
+	noopEnhancer
+	table string
+}
+
+func (e *failingEnhancer) Schema(s *SqliteSchema) error {
+	if s.Table == e.table {
+		return errors.New("boom")
+	}
+	return nil
+}
+
+// rowFailingEnhancer fails on a row after letting the first ones through.
+type rowFailingEnhancer struct {
+	noopEnhancer
+	after int
+	rows  int
+}
+
+func (e *rowFailingEnhancer) Row(map[string]any) error {
+	e.rows++
+	if e.rows > e.after {
+		return errors.New("boom")
+	}
+	return nil
+}
+
+func TestExport_RemovesPartialFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		export func(dir string) error
+	}{
+		{name: "file", export: func(dir string) error {
+			opts := ExportOptions{Enhancer: &rowFailingEnhancer{after: 2}, BlockLength: 1}
+			return TableToOCFWithOptions(testDB, "foo", filepath.Join(dir, "foo.avro"), opts)
+		}},
+		{name: "shards", export: func(dir string) error {
+			_, err := TableToOCFShards(testDB, "foo", dir, "", 1, &rowFailingEnhancer{after: 2})
+			return err
+		}},
+		{name: "query", export: func(dir string) error {
+			return QueryToOCF(testDB, "SELECT * FROM foo", nil, "foo", filepath.Join(dir, "foo.avro"), &rowFailingEnhancer{after: 2})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := tt.export(dir); err == nil {
+				t.Fatal("export error = nil, want error")
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("os.ReadDir() error = %v", err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("failed export left %d files, want none", len(entries))
+			}
+		})
+	}
+}
+
+func TestSqliteToAvroWithOptions_ContinueOnError(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE a (id INTEGER); CREATE TABLE b (id INTEGER); CREATE TABLE c (id INTEGER);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		continueOnError bool
+		want            []string
+	}{
+		{name: "stop", continueOnError: false, want: []string{"a.avro"}},
+		{name: "continue", continueOnError: true, want: []string{"a.avro", "c.avro"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files, err := SqliteToAvroWithOptions(db, dir, ExportOptions{
+				Enhancer:        &failingEnhancer{table: "b"},
+				ContinueOnError: tt.continueOnError,
+			})
+			if err == nil {
+				t.Fatalf("SqliteToAvroWithOptions() error = nil, want error")
+			}
+
+			got := []string{}
+			for _, f := range files {
+				got = append(got, filepath.Base(f))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SqliteToAvroWithOptions() files = %v, want %v", got, tt.want)
+			}
+
+			var exportErr *ExportError
+			if errors.As(err, &exportErr) != tt.continueOnError {
+				t.Fatalf("SqliteToAvroWithOptions() error = %v, want an *ExportError %v", err, tt.continueOnError)
+			}
+			if tt.continueOnError {
+				if _, ok := exportErr.Tables["b"]; !ok || len(exportErr.Tables) != 1 {
+					t.Errorf("ExportError.Tables = %v, want only b", exportErr.Tables)
+				}
+			}
+		})
+	}
+}
+
+func TestOCFToTable_DatesAndTimes(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE events (d DATE, dt DATETIME, ts TIMESTAMP);
+		INSERT INTO events VALUES ('2024-02-29', '2024-02-29 13:14:15', '2024-02-29 13:14:15.123456');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		column   string
+		wantType string
+		want     time.Time
+		wantText string
+	}{
+		{name: "DATE", column: "d", wantType: "date", want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), wantText: "2024-02-29"},
+		{name: "DATETIME", column: "dt", wantType: "timestamp-micros", want: time.Date(2024, 2, 29, 13, 14, 15, 0, time.UTC), wantText: "2024-02-29 13:14:15"},
+		{name: "TIMESTAMP", column: "ts", wantType: "timestamp-micros", want: time.Date(2024, 2, 29, 13, 14, 15, 123456000, time.UTC), wantText: "2024-02-29 13:14:15.123456"},
+	}
+
+	data, err := TableToOCFBytes(db, "events", nil)
+	if err != nil {
+		t.Fatalf("TableToOCFBytes() error = %v", err)
+	}
+	dec, err := ocf.NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ocf.NewDecoder() error = %v", err)
+	}
+	avroSchema, err := avro.Parse(string(dec.Metadata()[ocfSchemaKey]))
+	if err != nil {
+		t.Fatalf("avro.Parse() error = %v", err)
+	}
+
+	copied := newTestDB(t)
+	if _, err := OCFBytesToTable(copied, data, "events"); err != nil {
+		t.Fatalf("OCFBytesToTable() error = %v", err)
+	}
+	rows, err := LoadData(copied, "events")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field := avroSchema.(*avro.RecordSchema).Fields()[i]
+			logical := field.Type().(*avro.UnionSchema).Types()[1].(*avro.PrimitiveSchema).Logical()
+			if logical == nil || string(logical.Type()) != tt.wantType {
+				t.Errorf("TableToOCFBytes() %s logical type = %v, want %v", tt.column, logical, tt.wantType)
+			}
+
+			if got := rows[0][tt.column]; got != tt.want {
+				t.Errorf("LoadData() %s = %v, want %v", tt.column, got, tt.want)
+			}
+
+			var text string
+			err := copied.QueryRow(fmt.Sprintf("SELECT %s || '' FROM events", tt.column)).Scan(&text)
+			if err != nil {
+				t.Fatalf("QueryRow() error = %v", err)
+			}
+			if text != tt.wantText {
+				t.Errorf("stored %s = %v, want %v", tt.column, text, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestTableToOCFWriterWithOptions_Rename(t *testing.T) {
+	var buf bytes.Buffer
+	err := TableToOCFWriterWithOptions(testDB, "foo", &buf, ExportOptions{
+		TableRename:  map[string]string{"foo": "bars"},
+		ColumnRename: map[string]map[string]string{"foo": {"name": "label"}},
+	})
+	if err != nil {
+		t.Fatalf("TableToOCFWriterWithOptions() error = %v", err)
+	}
+
+	dec, err := ocf.NewDecoder(&buf)
+	if err != nil {
+		t.Fatalf("ocf.NewDecoder() error = %v", err)
+	}
+	schema, err := avro.Parse(string(dec.Metadata()[ocfSchemaKey]))
+	if err != nil {
+		t.Fatalf("avro.Parse() error = %v", err)
+	}
+	record := schema.(*avro.RecordSchema)
+	if record.Name() != "bars" {
+		t.Errorf("TableToOCFWriterWithOptions() record = %v, want %v", record.Name(), "bars")
+	}
+
+	got := []map[string]any{}
+	for dec.HasNext() {
+		var row map[string]any
+		if err := dec.Decode(&row); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		got = append(got, row)
+	}
+	want := []map[string]any{
+		{"id": int64(1), "label": "bar"},
+		{"id": int64(2), "label": "bat"},
+		{"id": int64(3), "label": "baz"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TableToOCFWriterWithOptions() records = %v, want %v", got, want)
+	}
+}
+
+func TestOCFToTable_AnyColumn(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE things (id INTEGER PRIMARY KEY, value ANY) STRICT;
+		INSERT INTO things VALUES (1, 42), (2, 1.5), (3, '7'), (4, X'00FF'), (5, NULL);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	data, err := TableToOCFBytes(db, "things", nil)
+	if err != nil {
+		t.Fatalf("TableToOCFBytes() error = %v", err)
+	}
+	copied := newTestDB(t)
+	if _, err := OCFBytesToTable(copied, data, "things"); err != nil {
+		t.Fatalf("OCFBytesToTable() error = %v", err)
+	}
+
+	rows, err := copied.Query("SELECT typeof(value) FROM things ORDER BY id")
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	defer rows.Close()
+	classes := []string{}
+	for rows.Next() {
+		var class string
+		if err := rows.Scan(&class); err != nil {
+			t.Fatalf("Scan() error = %v", err)
+		}
+		classes = append(classes, class)
+	}
+	wantClasses := []string{"integer", "real", "text", "blob", "null"}
+	if !reflect.DeepEqual(classes, wantClasses) {
+		t.Errorf("storage classes = %v, want %v", classes, wantClasses)
+	}
+
+	got, err := LoadData(copied, "things")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	want := []any{int64(42), 1.5, "7", []byte{0x00, 0xff}, nil}
+	for i, row := range got {
+		if !reflect.DeepEqual(row["value"], want[i]) {
+			t.Errorf("LoadData() value %d = %#v, want %#v", i, row["value"], want[i])
+		}
+	}
+}
+
+func TestTableToOCFWriterWithOptions_ValueTransforms(t *testing.T) {
+	var buf bytes.Buffer
+	err := TableToOCFWriterWithOptions(testDB, "meats", &buf, ExportOptions{
+		ValueTransforms: map[SqliteType]func(any) (any, error){
+			SqliteText: func(v any) (any, error) {
+				return strings.ToUpper(v.(string)), nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("TableToOCFWriterWithOptions() error = %v", err)
+	}
+
+	if got, want := readOCFValues(t, buf.Bytes(), "name"), []any{"BEEF", "PORK", "CHICKEN"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TableToOCFWriterWithOptions() names = %v, want %v", got, want)
+	}
+	if got, want := readOCFValues(t, buf.Bytes(), "id"), []any{int64(1), int64(2), int64(3)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TableToOCFWriterWithOptions() ids = %v, want %v", got, want)
+	}
+
+	err = TableToOCFWriterWithOptions(testDB, "meats", io.Discard, ExportOptions{
+		ValueTransforms: map[SqliteType]func(any) (any, error){
+			SqliteText: func(any) (any, error) { return nil, errors.New("redaction failed") },
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "redaction failed") {
+		t.Errorf("TableToOCFWriterWithOptions() error = %v, want the transform error", err)
+	}
+}
+
+func TestTableToOCFShards(t *testing.T) {
+	dir := t.TempDir()
+	files, err := TableToOCFShards(testDB, "foo", dir, "test_", 2, nil)
+	if err != nil {
+		t.Fatalf("TableToOCFShards() error = %v", err)
+	}
+	want := []string{filepath.Join(dir, "test_foo.0000.avro"), filepath.Join(dir, "test_foo.0001.avro")}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("TableToOCFShards() = %v, want %v", files, want)
+	}
+
+	wantNames := [][]any{{"bar", "bat"}, {"baz"}}
+	schemas := []string{}
+	for i, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("os.ReadFile() error = %v", err)
+		}
+		if got := readOCFValues(t, data, "name"); !reflect.DeepEqual(got, wantNames[i]) {
+			t.Errorf("TableToOCFShards() shard %d names = %v, want %v", i, got, wantNames[i])
+		}
+		dec, err := ocf.NewDecoder(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("ocf.NewDecoder() error = %v", err)
+		}
+		schemas = append(schemas, string(dec.Metadata()[ocfSchemaKey]))
+	}
+	if schemas[0] != schemas[1] {
+		t.Errorf("TableToOCFShards() schemas differ: %v and %v", schemas[0], schemas[1])
+	}
+
+	if _, err := TableToOCFShards(testDB, "foo", dir, "test_", 0, nil); err == nil {
+		t.Error("TableToOCFShards() with 0 rows per shard error = nil")
+	}
+}
+
+func TestOCFShardsToTable(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := TableToOCFShards(testDB, "foo", dir, "", 2, nil); err != nil {
+		t.Fatalf("TableToOCFShards() error = %v", err)
+	}
+
+	db := newTestDB(t)
+	got, err := OCFShardsToTable(db, filepath.Join(dir, "foo.*.avro"), "")
+	if err != nil {
+		t.Fatalf("OCFShardsToTable() error = %v", err)
+	}
+	if got != 3 {
+		t.Errorf("OCFShardsToTable() = %v, want %v", got, 3)
+	}
+	rows, err := LoadData(db, "foo")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	names := []any{}
+	for _, row := range rows {
+		names = append(names, row["name"])
+	}
+	if want := []any{"bar", "bat", "baz"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("OCFShardsToTable() names = %v, want %v", names, want)
+	}
+
+	// a shard of another table does not share the schema
+	if err := TableToOCF(testDB, "meats", filepath.Join(dir, "foo.0002.avro"), nil); err != nil {
+		t.Fatalf("TableToOCF() error = %v", err)
+	}
+	if _, err := OCFShardsToTable(db, filepath.Join(dir, "foo.*.avro"), ""); err == nil {
+		t.Error("OCFShardsToTable() with mismatched schemas error = nil")
+	}
+
+	if _, err := OCFShardsToTable(db, filepath.Join(dir, "missing.*.avro"), ""); err == nil {
+		t.Error("OCFShardsToTable() with no matching files error = nil")
+	}
+}
+
+func TestOCFToTable_Collections(t *testing.T) {
+	schema := avro.MustParse(`{
+		"type": "record",
+		"name": "posts",
+		"fields": [
+			{"name": "id", "type": "long"},
+			{"name": "tags", "type": {"type": "array", "items": "string"}},
+			{"name": "counts", "type": ["null", {"type": "map", "values": "long"}]}
+		]
+	}`)
+	var buf bytes.Buffer
+	enc, err := ocf.NewEncoder(schema.String(), &buf)
+	if err != nil {
+		t.Fatalf("ocf.NewEncoder() error = %v", err)
+	}
+	records := []map[string]any{
+		{"id": int64(1), "tags": []any{"go", "sqlite"}, "counts": map[string]any{"map": map[string]any{"views": int64(3)}}},
+		{"id": int64(2), "tags": []any{}, "counts": nil},
+	}
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	db := newTestDB(t)
+	if _, err := OCFToTable(db, &buf, ""); err != nil {
+		t.Fatalf("OCFToTable() error = %v", err)
+	}
+	got, err := LoadData(db, "posts")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	want := []map[string]any{
+		{"id": int64(1), "tags": `["go","sqlite"]`, "counts": `{"views":3}`},
+		{"id": int64(2), "tags": `[]`, "counts": nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadData() = %v, want %v", got, want)
+	}
+}
+
+func TestSqliteToAvroWithOptions_ExcludeTables(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE notes (id INTEGER, body TEXT);
+		CREATE INDEX notes_id ON notes (id);
+		CREATE TABLE secrets (value TEXT);
+		CREATE TABLE notes_fts_data (block BLOB);
+		CREATE TABLE notes_fts_idx (term TEXT);
+		INSERT INTO notes VALUES (1, 'hello');
+		ANALYZE;`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	files, err := SqliteToAvroWithOptions(db, dir, ExportOptions{ExcludeTables: []string{"secrets", "*_fts_*"}})
+	if err != nil {
+		t.Fatalf("SqliteToAvroWithOptions() error = %v", err)
+	}
+	if want := []string{filepath.Join(dir, "notes.avro")}; !reflect.DeepEqual(files, want) {
+		t.Errorf("SqliteToAvroWithOptions() = %v, want %v", files, want)
+	}
+
+	files, err = SqliteToAvroWithOptions(db, t.TempDir(), ExportOptions{ExcludeTables: []string{"SECRETS", "*_FTS_*", "Notes"}})
+	if err != nil {
+		t.Fatalf("SqliteToAvroWithOptions() error = %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("SqliteToAvroWithOptions() with differently cased exclusions = %v, want none", files)
+	}
+
+	if _, err := SqliteToAvroWithOptions(db, dir, ExportOptions{ExcludeTables: []string{"[notes"}}); err == nil {
+		t.Error("SqliteToAvroWithOptions() with an invalid pattern error = nil")
+	}
+}
+
+// writingEnhancer adds a row to every table through another connection
+// when the schema of the first table is enhanced.
+type writingEnhancer struct {
+	noopEnhancer
+	db     *sql.DB
+	tables []string
+	done   bool
+}
+
+func (e *writingEnhancer) Schema(s *SqliteSchema) error {
+	if e.done {
+		return nil
+	}
+	e.done = true
+	for _, table := range e.tables {
+		if _, err := e.db.Exec(fmt.Sprintf("INSERT INTO %s VALUES (2)", table)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSqliteToAvroWithOptions_Consistent(t *testing.T) {
+	tests := []struct {
+		name       string
+		consistent bool
+		want       []int
+	}{
+		{name: "consistent", consistent: true, want: []int{1, 1}},
+		{name: "not consistent", consistent: false, want: []int{2, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbPath := filepath.Join(t.TempDir(), "test.db")
+			db, err := sql.Open("sqlite3", "file:"+dbPath+"?_journal_mode=WAL")
+			if err != nil {
+				t.Fatalf("sql.Open() error = %v", err)
+			}
+			defer db.Close()
+			_, err = db.Exec(`CREATE TABLE a (id INTEGER); CREATE TABLE b (id INTEGER);
+				INSERT INTO a VALUES (1); INSERT INTO b VALUES (1);`)
+			if err != nil {
+				t.Fatalf("db.Exec() error = %v", err)
+			}
+			writer, err := sql.Open("sqlite3", "file:"+dbPath)
+			if err != nil {
+				t.Fatalf("sql.Open() error = %v", err)
+			}
+			defer writer.Close()
+
+			dir := t.TempDir()
+			_, err = SqliteToAvroWithOptions(db, dir, ExportOptions{
+				Enhancer:   &writingEnhancer{db: writer, tables: []string{"a", "b"}},
+				Consistent: tt.consistent,
+			})
+			if err != nil {
+				t.Fatalf("SqliteToAvroWithOptions() error = %v", err)
+			}
+
+			got := []int{}
+			for _, table := range []string{"a", "b"} {
+				data, err := os.ReadFile(filepath.Join(dir, table+".avro"))
+				if err != nil {
+					t.Fatalf("os.ReadFile() error = %v", err)
+				}
+				got = append(got, len(readOCFValues(t, data, "id")))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("exported row counts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSqliteToAvro_FTS5 needs the FTS5 module, built into go-sqlite3 with
+// the sqlite_fts5 build tag: go test -tags sqlite_fts5
+func TestSqliteToAvro_FTS5(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec("CREATE VIRTUAL TABLE docs USING fts5(title, body)")
+	if err != nil && strings.Contains(err.Error(), "no such module") {
+		t.Skip("FTS5 is not available, run with -tags sqlite_fts5")
+	}
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	_, err = db.Exec("INSERT INTO docs VALUES ('Avro', 'a data serialization system'), ('SQLite', 'a small database engine')")
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	schema, err := ReadSchema(db, "docs")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	if !schema.Virtual || len(schema.Fields) != 2 {
+		t.Errorf("ReadSchema() = %+v, want a virtual table with 2 columns", schema)
+	}
+
+	dir := t.TempDir()
+	files, err := SqliteToAvro(db, dir, "", true, nil)
+	if err != nil {
+		t.Fatalf("SqliteToAvro() error = %v", err)
+	}
+	// the shadow tables, such as docs_content, are not exported
+	if want := []string{filepath.Join(dir, "docs.avro"), filepath.Join(dir, "docs.json")}; !reflect.DeepEqual(files, want) {
+		t.Errorf("SqliteToAvro() = %v, want %v", files, want)
+	}
+
+	restored := newTestDB(t)
+	if _, err := AvroDirToSqlite(restored, dir, ""); err != nil {
+		t.Fatalf("AvroDirToSqlite() error = %v", err)
+	}
+	var title string
+	if err := restored.QueryRow("SELECT title FROM docs WHERE docs MATCH 'database'").Scan(&title); err != nil {
+		t.Fatalf("QueryRow() error = %v", err)
+	}
+	if title != "SQLite" {
+		t.Errorf("MATCH 'database' = %v, want %v", title, "SQLite")
+	}
+}
+
+func TestTableToOCFWriterWithOptions_NullabilityOverrides(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE people (name TEXT, age INTEGER DEFAULT 5, note TEXT NOT NULL);
+		INSERT INTO people VALUES ('a', 1, 'x'), (NULL, NULL, 'y');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	tighten := map[string]map[string]bool{"people": {"name": false, "age": false}}
+
+	tests := []struct {
+		name         string
+		opts         ExportOptions
+		wantErr      string
+		wantNames    []any
+		wantAges     []any
+		wantNullable map[string]bool
+	}{
+		{
+			name:    "tighten with NullError",
+			opts:    ExportOptions{NullabilityOverrides: tighten},
+			wantErr: "column name of row 1 of table people is NULL",
+		},
+		{
+			name:         "tighten with NullDefault",
+			opts:         ExportOptions{NullabilityOverrides: tighten, NullPolicy: NullDefault},
+			wantNames:    []any{"a", ""},
+			wantAges:     []any{int64(1), int64(5)},
+			wantNullable: map[string]bool{"name": false, "age": false, "note": false},
+		},
+		{
+			name:         "relax",
+			opts:         ExportOptions{NullabilityOverrides: map[string]map[string]bool{"people": {"note": true}}},
+			wantNames:    []any{"a", nil},
+			wantAges:     []any{int64(1), nil},
+			wantNullable: map[string]bool{"name": true, "age": true, "note": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := TableToOCFWriterWithOptions(db, "people", &buf, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("TableToOCFWriterWithOptions() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TableToOCFWriterWithOptions() error = %v", err)
+			}
+
+			if got := readOCFValues(t, buf.Bytes(), "name"); !reflect.DeepEqual(got, tt.wantNames) {
+				t.Errorf("TableToOCFWriterWithOptions() names = %v, want %v", got, tt.wantNames)
+			}
+			if got := readOCFValues(t, buf.Bytes(), "age"); !reflect.DeepEqual(got, tt.wantAges) {
+				t.Errorf("TableToOCFWriterWithOptions() ages = %v, want %v", got, tt.wantAges)
+			}
+			dec, err := ocf.NewDecoder(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("ocf.NewDecoder() error = %v", err)
+			}
+			schema, err := avro.Parse(string(dec.Metadata()[ocfSchemaKey]))
+			if err != nil {
+				t.Fatalf("avro.Parse() error = %v", err)
+			}
+			for _, field := range schema.(*avro.RecordSchema).Fields() {
+				if got := isNullable(field.Type()); got != tt.wantNullable[field.Name()] {
+					t.Errorf("field %s nullable = %v, want %v", field.Name(), got, tt.wantNullable[field.Name()])
+				}
+			}
+		})
+	}
+}
+
+func TestTableToOCFWriterWithOptions_InferNullability(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE people (name TEXT, age INTEGER, note TEXT NOT NULL);
+		INSERT INTO people VALUES ('a', 1, 'x'), ('b', NULL, 'y');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		opts         ExportOptions
+		wantNullable map[string]bool
+	}{
+		{
+			name:         "declared",
+			opts:         ExportOptions{},
+			wantNullable: map[string]bool{"name": true, "age": true, "note": false},
+		},
+		{
+			name:         "inferred",
+			opts:         ExportOptions{InferNullability: true},
+			wantNullable: map[string]bool{"name": false, "age": true, "note": false},
+		},
+		{
+			name: "inferred and overridden",
+			opts: ExportOptions{
+				InferNullability:     true,
+				NullabilityOverrides: map[string]map[string]bool{"people": {"name": true}},
+			},
+			wantNullable: map[string]bool{"name": true, "age": true, "note": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := TableToOCFWriterWithOptions(db, "people", &buf, tt.opts); err != nil {
+				t.Fatalf("TableToOCFWriterWithOptions() error = %v", err)
+			}
+			if got, want := readOCFValues(t, buf.Bytes(), "age"), []any{int64(1), nil}; !reflect.DeepEqual(got, want) {
+				t.Errorf("TableToOCFWriterWithOptions() ages = %v, want %v", got, want)
+			}
+
+			dec, err := ocf.NewDecoder(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("ocf.NewDecoder() error = %v", err)
+			}
+			schema, err := avro.Parse(string(dec.Metadata()[ocfSchemaKey]))
+			if err != nil {
+				t.Fatalf("avro.Parse() error = %v", err)
+			}
+			for _, field := range schema.(*avro.RecordSchema).Fields() {
+				if got := isNullable(field.Type()); got != tt.wantNullable[field.Name()] {
+					t.Errorf("field %s nullable = %v, want %v", field.Name(), got, tt.wantNullable[field.Name()])
+				}
+			}
+		})
+	}
+}
+
+func TestTableToOCFWriterWithOptions_ExternalizeBlobs(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE images (id INTEGER, data BLOB, thumb BLOB NOT NULL DEFAULT x'00');
+		INSERT INTO images VALUES (1, x'0102', x'03'), (2, NULL, x'04');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	name := func(table, column string, index int64) string {
+		return fmt.Sprintf("%s-%s-%d", table, column, index)
+	}
+	tests := []struct {
+		name       string
+		blobs      *ExternalBlobs
+		wantData   []any
+		wantThumbs []any
+	}{
+		{
+			name:       "default names",
+			blobs:      &ExternalBlobs{Dir: dir},
+			wantData:   []any{filepath.Join(dir, "images.data.0.bin"), nil},
+			wantThumbs: []any{filepath.Join(dir, "images.thumb.0.bin"), filepath.Join(dir, "images.thumb.1.bin")},
+		},
+		{
+			name:       "custom names",
+			blobs:      &ExternalBlobs{Dir: dir, Name: name},
+			wantData:   []any{filepath.Join(dir, "images-data-0"), nil},
+			wantThumbs: []any{filepath.Join(dir, "images-thumb-0"), filepath.Join(dir, "images-thumb-1")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := ExportOptions{ExternalizeBlobs: tt.blobs}
+			if err := TableToOCFWriterWithOptions(db, "images", &buf, opts); err != nil {
+				t.Fatalf("TableToOCFWriterWithOptions() error = %v", err)
+			}
+			if got := readOCFValues(t, buf.Bytes(), "data"); !reflect.DeepEqual(got, tt.wantData) {
+				t.Errorf("TableToOCFWriterWithOptions() data = %v, want %v", got, tt.wantData)
+			}
+			if got := readOCFValues(t, buf.Bytes(), "thumb"); !reflect.DeepEqual(got, tt.wantThumbs) {
+				t.Errorf("TableToOCFWriterWithOptions() thumbs = %v, want %v", got, tt.wantThumbs)
+			}
+
+			files := map[any][]byte{tt.wantData[0]: {1, 2}, tt.wantThumbs[0]: {3}, tt.wantThumbs[1]: {4}}
+			for fileName, want := range files {
+				got, err := os.ReadFile(fileName.(string))
+				if err != nil {
+					t.Fatalf("os.ReadFile() error = %v", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("file %s = %v, want %v", fileName, got, want)
+				}
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := tableToJSONWriter(db, "images", &buf, ExportOptions{ExternalizeBlobs: &ExternalBlobs{Dir: dir}}); err != nil {
+		t.Fatalf("tableToJSONWriter() error = %v", err)
+	}
+	schema := &SqliteSchema{}
+	if err := json.Unmarshal(buf.Bytes(), schema); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	for _, f := range schema.Fields[1:] {
+		if f.Type != SqliteText || f.Props[ExternalBlobProp] != true {
+			t.Errorf("field %s = %+v, want an externalized TEXT field", f.Name, f)
+		}
+	}
+}
+
+func TestTableToOCFWriterWithOptions_PageSize(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO items VALUES (5, 'e'), (3, 'c'), (1, 'a'), (4, 'd'), (2, 'b');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		pageSize int
+		wantErr  bool
+	}{
+		{name: "pages of 2", pageSize: 2},
+		{name: "pages of 5", pageSize: 5},
+		{name: "single page", pageSize: 100},
+		{name: "negative", pageSize: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := TableToOCFWriterWithOptions(db, "items", &buf, ExportOptions{PageSize: tt.pageSize})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TableToOCFWriterWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			want := []any{"a", "b", "c", "d", "e"}
+			if got := readOCFValues(t, buf.Bytes(), "name"); !reflect.DeepEqual(got, want) {
+				t.Errorf("TableToOCFWriterWithOptions() names = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestTableToOCFWriterWithOptions_PageSizeKeyset(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE single (id INTEGER PRIMARY KEY, v TEXT);
+		INSERT INTO single VALUES (50, 'e'), (3, 'b'), (-1, 'a'), (40, 'd'), (7, 'c');
+		CREATE TABLE composite (a TEXT NOT NULL, b INTEGER NOT NULL, v TEXT, PRIMARY KEY (a, b));
+		INSERT INTO composite VALUES ('y', 1, 'd'), ('x', 2, 'b'), ('x', 1, 'a'), ('y', 0, 'c'), ('z', 9, 'e');
+		CREATE TABLE unkeyed (v TEXT);
+		INSERT INTO unkeyed VALUES ('a'), ('b'), ('c'), ('d'), ('e');
+		CREATE TABLE nulls (k TEXT PRIMARY KEY, v TEXT);
+		INSERT INTO nulls VALUES (NULL, 'a'), (NULL, 'b'), ('k1', 'c'), ('k2', 'd'), ('k3', 'e');
+		CREATE TABLE dates (d DATE PRIMARY KEY, v TEXT);
+		INSERT INTO dates VALUES ('2024-01-05', 'e'), ('2024-01-01', 'a'), ('2024-01-03', 'c'), ('2024-01-02', 'b'), ('2024-01-04', 'd');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	tests := []struct {
+		table  string
+		keyset bool
+	}{
+		{table: "single", keyset: true},
+		{table: "composite", keyset: true},
+		{table: "unkeyed", keyset: true},
+		// the NULL keys of the first page switch the following pages to OFFSET
+		{table: "nulls", keyset: true},
+		{table: "dates", keyset: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.table, func(t *testing.T) {
+			opts := ExportOptions{PageSize: 2}
+			export, err := newTableExport(db, tt.table, opts, false)
+			if err != nil {
+				t.Fatalf("newTableExport() error = %v", err)
+			}
+			if got := len(export.keys) > 0; got != tt.keyset {
+				t.Errorf("newTableExport() keys = %v, want keyset %v", export.keys, tt.keyset)
+			}
+
+			var buf bytes.Buffer
+			if err := TableToOCFWriterWithOptions(db, tt.table, &buf, opts); err != nil {
+				t.Fatalf("TableToOCFWriterWithOptions() error = %v", err)
+			}
+			want := []any{"a", "b", "c", "d", "e"}
+			if got := readOCFValues(t, buf.Bytes(), "v"); !reflect.DeepEqual(got, want) {
+				t.Errorf("TableToOCFWriterWithOptions() values = %v, want %v", got, want)
+			}
+			if got := readOCFValues(t, buf.Bytes(), rowIDColumn); !reflect.DeepEqual(got, []any{nil, nil, nil, nil, nil}) {
+				t.Errorf("TableToOCFWriterWithOptions() exported the rowid %v", got)
+			}
+		})
+	}
+}
+
+func TestTableToOCFWriterWithOptions_RecordDoc(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO items VALUES (1, 'a')"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	header := func(opts ExportOptions) map[string][]byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := TableToOCFWriterWithOptions(db, "items", &buf, opts); err != nil {
+			t.Fatalf("TableToOCFWriterWithOptions() error = %v", err)
+		}
+		dec, err := ocf.NewDecoder(&buf)
+		if err != nil {
+			t.Fatalf("ocf.NewDecoder() error = %v", err)
+		}
+		return dec.Metadata()
+	}
+
+	withDoc, withoutDoc := header(ExportOptions{RecordDoc: true}), header(ExportOptions{})
+	if _, ok := withoutDoc[RecordDocMetadataKey]; ok {
+		t.Errorf("TableToOCFWriterWithOptions() without RecordDoc wrote doc %q", withoutDoc[RecordDocMetadataKey])
+	}
+	doc := string(withDoc[RecordDocMetadataKey])
+	if !strings.Contains(doc, "Table items of database ") || !strings.Contains(doc, "test.db") {
+		t.Errorf("TableToOCFWriterWithOptions() doc = %q, want the table and the database file", doc)
+	}
+	if got, want := string(withDoc[ocfSchemaKey]), string(withoutDoc[ocfSchemaKey]); got != want {
+		t.Errorf("TableToOCFWriterWithOptions() schema with RecordDoc = %s, want %s", got, want)
+	}
+
+	export, err := newTableExport(db, "items", ExportOptions{RecordDoc: true}, false)
+	if err != nil {
+		t.Fatalf("newTableExport() error = %v", err)
+	}
+	if got := export.avroSchema.(*avro.RecordSchema).Doc(); !strings.HasPrefix(got, "Table items of database ") {
+		t.Errorf("newTableExport() record doc = %q, want the provenance of table items", got)
+	}
+}
+
+func TestOCFToTableWithOptions_Reconcile(t *testing.T) {
+	src := newTestDB(t)
+	if _, err := src.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, Name TEXT, extra REAL); INSERT INTO items VALUES (1, 'a', 0.5)"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	data, err := TableToOCFBytes(src, "items", nil)
+	if err != nil {
+		t.Fatalf("TableToOCFBytes() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		ddl     string
+		strict  bool
+		want    []map[string]any
+		wantLog []string
+		wantErr bool
+	}{
+		{
+			name:    "extra fields dropped",
+			ddl:     "CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)",
+			want:    []map[string]any{{"id": int64(1), "name": "a"}},
+			wantLog: []string{"field of the OCF with no column dropped", "field=extra"},
+		},
+		{
+			name:    "missing fields defaulted",
+			ddl:     "CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, extra REAL, status TEXT NOT NULL DEFAULT 'new')",
+			want:    []map[string]any{{"id": int64(1), "name": "a", "extra": 0.5, "status": "new"}},
+			wantLog: []string{"column missing from the OCF takes its default", "column=status"},
+		},
+		{
+			name:    "extra fields with strict fields",
+			ddl:     "CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)",
+			strict:  true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			if _, err := db.Exec(tt.ddl + "; INSERT INTO items (id, name) VALUES (9, 'old')"); err != nil {
+				t.Fatalf("db.Exec() error = %v", err)
+			}
+			var logs bytes.Buffer
+			opts := LoadOptions{StrictFields: tt.strict, Logger: slog.New(slog.NewTextHandler(&logs, nil))}
+			_, err := OCFToTableWithOptions(db, bytes.NewReader(data), "", opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OCFToTableWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got, err := LoadData(db, "items")
+			if err != nil {
+				t.Fatalf("LoadData() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadData() = %v, want %v", got, tt.want)
+			}
+			for _, want := range tt.wantLog {
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("OCFToTableWithOptions() logged %q, want %q", logs.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestOCFToTable_ReconcileTypes(t *testing.T) {
+	src := newTestDB(t)
+	_, err := src.Exec(`CREATE TABLE events (id INTEGER, d DATE, at DATETIME, n INTEGER);
+		INSERT INTO events VALUES (1, '2024-01-02', '2024-01-02 03:04:05', 7)`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	data, err := TableToOCFBytes(src, "events", nil)
+	if err != nil {
+		t.Fatalf("TableToOCFBytes() error = %v", err)
+	}
+
+	// the values are stored as they were written, not as time.Time, whatever the
+	// types of the columns of the existing table
+	db := newTestDB(t)
+	if _, err := db.Exec("CREATE TABLE events (id INTEGER, d TEXT, at TEXT, n TEXT)"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	if _, err := OCFBytesToTable(db, data, ""); err != nil {
+		t.Fatalf("OCFBytesToTable() error = %v", err)
+	}
+	got, err := LoadData(db, "events")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	want := []map[string]any{{"id": int64(1), "d": "2024-01-02", "at": "2024-01-02 03:04:05", "n": "7"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadData() = %v, want %v", got, want)
+	}
+}
+
+func TestTableToOCFWriterWithOptions_Logger(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE counts (name TEXT, n INTEGER NOT NULL DEFAULT 'many');
+		INSERT INTO counts VALUES ('a', 1);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	if err := TableToOCFWriterWithOptions(db, "counts", io.Discard, ExportOptions{Logger: logger}); err != nil {
+		t.Fatalf("TableToOCFWriterWithOptions() error = %v", err)
+	}
+	for _, want := range []string{"level=WARN", `msg="invalid column default replaced"`, "table=counts", "column=n", "default='many'", "value=0"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("TableToOCFWriterWithOptions() logged %q, want it to contain %s", logs.String(), want)
+		}
+	}
+}
+
+func TestRecodeOCF(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, price REAL, data BLOB);
+		INSERT INTO items VALUES (1, 'bolt', 0.5, x'00ff'), (2, NULL, NULL, NULL);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	data, err := TableToOCFBytes(db, "items", nil)
+	if err != nil {
+		t.Fatalf("TableToOCFBytes() error = %v", err)
+	}
+	want, err := LoadData(db, "items")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+
+	for _, codec := range []string{"deflate", "snappy", "null"} {
+		t.Run(codec, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := RecodeOCF(bytes.NewReader(data), &buf, codec); err != nil {
+				t.Fatalf("RecodeOCF() error = %v", err)
+			}
+			dec, err := ocf.NewDecoder(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("ocf.NewDecoder() error = %v", err)
+			}
+			if got := string(dec.Metadata()["avro.codec"]); got != codec {
+				t.Errorf("RecodeOCF() codec = %v, want %v", got, codec)
+			}
+
+			restored := newTestDB(t)
+			if _, err := OCFBytesToTable(restored, buf.Bytes(), ""); err != nil {
+				t.Fatalf("OCFBytesToTable() error = %v", err)
+			}
+			rows, err := LoadData(restored, "items")
+			if err != nil {
+				t.Fatalf("LoadData() error = %v", err)
+			}
+			if !reflect.DeepEqual(rows, want) {
+				t.Errorf("LoadData() = %v, want %v", rows, want)
+			}
+		})
+	}
+
+	if err := RecodeOCF(bytes.NewReader(data), io.Discard, "zstd"); err == nil {
+		t.Error("RecodeOCF() with an unsupported codec error = nil")
+	}
+}
+
+func TestSqliteToAvroWithOptions_Checkpoint(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	// without a busy timeout a blocked checkpoint fails at once
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?_journal_mode=WAL&_busy_timeout=0")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`CREATE TABLE a (id INTEGER); INSERT INTO a VALUES (1), (2);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	walSize := func() int64 {
+		info, err := os.Stat(dbPath + "-wal")
+		if err != nil {
+			t.Fatalf("os.Stat() error = %v", err)
+		}
+		return info.Size()
+	}
+	if walSize() == 0 {
+		t.Fatal("WAL is empty, want pending writes")
+	}
+
+	var logs bytes.Buffer
+	opts := ExportOptions{Checkpoint: true, Logger: slog.New(slog.NewTextHandler(&logs, nil))}
+	dir := t.TempDir()
+	if _, err := SqliteToAvroWithOptions(db, dir, opts); err != nil {
+		t.Fatalf("SqliteToAvroWithOptions() error = %v", err)
+	}
+	if got := walSize(); got != 0 {
+		t.Errorf("WAL size after the export = %v, want 0", got)
+	}
+	// the database file alone now holds the rows
+	immutable, err := sql.Open("sqlite3", "file:"+dbPath+"?immutable=1")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer immutable.Close()
+	var count int
+	if err := immutable.QueryRow("SELECT count(*) FROM a").Scan(&count); err != nil {
+		t.Fatalf("db.QueryRow() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("rows in the database file = %v, want %v", count, 2)
+	}
+
+	// a reader holding a snapshot of the WAL blocks the checkpoint
+	if _, err := db.Exec("INSERT INTO a VALUES (3)"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	reader, err := sql.Open("sqlite3", "file:"+dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer reader.Close()
+	tx, err := reader.Begin()
+	if err != nil {
+		t.Fatalf("db.Begin() error = %v", err)
+	}
+	defer tx.Rollback()
+	if err := tx.QueryRow("SELECT count(*) FROM a").Scan(&count); err != nil {
+		t.Fatalf("tx.QueryRow() error = %v", err)
+	}
+	if _, err := SqliteToAvroWithOptions(db, dir, opts); err != nil {
+		t.Fatalf("SqliteToAvroWithOptions() error = %v", err)
+	}
+	if !strings.Contains(logs.String(), "exporting without a WAL checkpoint") {
+		t.Errorf("SqliteToAvroWithOptions() logged %q, want a checkpoint warning", logs.String())
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "a.avro"))
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	if got := len(readOCFValues(t, data, "id")); got != 3 {
+		t.Errorf("exported rows = %v, want %v", got, 3)
+	}
+}
+
+// TestSqliteToAvro_RTree checks the virtual tables with the R*Tree module,
+// which go-sqlite3 builds in by default, unlike FTS5.
+func TestSqliteToAvro_RTree(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE VIRTUAL TABLE boxes USING rtree(id, min_x, max_x, min_y, max_y);
+		INSERT INTO boxes VALUES (1, 0, 10, 0, 10), (2, 20, 30, 20, 30);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	schema, err := ReadSchema(db, "boxes")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	if !schema.Virtual || len(schema.Fields) != 5 {
+		t.Errorf("ReadSchema() = %+v, want a virtual table with 5 columns", schema)
+	}
+
+	dir := t.TempDir()
+	files, err := SqliteToAvro(db, dir, "", true, nil)
+	if err != nil {
+		t.Fatalf("SqliteToAvro() error = %v", err)
+	}
+	// the shadow tables, boxes_node, boxes_parent and boxes_rowid, are not exported
+	if want := []string{filepath.Join(dir, "boxes.avro"), filepath.Join(dir, "boxes.json")}; !reflect.DeepEqual(files, want) {
+		t.Errorf("SqliteToAvro() = %v, want %v", files, want)
+	}
+
+	restored := newTestDB(t)
+	if _, err := AvroDirToSqlite(restored, dir, ""); err != nil {
+		t.Fatalf("AvroDirToSqlite() error = %v", err)
+	}
+	var id int64
+	if err := restored.QueryRow("SELECT id FROM boxes WHERE min_x >= 15 AND max_y <= 35").Scan(&id); err != nil {
+		t.Fatalf("QueryRow() error = %v", err)
+	}
+	if id != 2 {
+		t.Errorf("box in range = %v, want %v", id, 2)
+	}
+	tables, err := ListTables(restored)
+	if err != nil {
+		t.Fatalf("ListTables() error = %v", err)
+	}
+	if want := []string{"boxes"}; !reflect.DeepEqual(tables, want) {
+		t.Errorf("ListTables() = %v, want %v", tables, want)
+	}
+}
@@ -0,0 +1,178 @@
+package avrosqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path/filepath"
+)
+
+// Option configures an export run by Export, ExportTable, OpenAndExport and
+// OpenAndExportTable. Options are applied in order to an empty ExportOptions.
+type Option func(*ExportOptions)
+
+// exportOptions returns the ExportOptions set by opts.
+func exportOptions(opts []Option) ExportOptions {
+	exportOpts := ExportOptions{}
+	for _, opt := range opts {
+		opt(&exportOpts)
+	}
+	return exportOpts
+}
+
+// WithPrefix prepends prefix to each table name in the output file names.
+func WithPrefix(prefix string) Option {
+	return func(opts *ExportOptions) {
+		opts.Prefix = prefix
+	}
+}
+
+// WithJSON also saves a JSON version of each table's schema.
+func WithJSON() Option {
+	return func(opts *ExportOptions) {
+		opts.IncludeJSON = true
+	}
+}
+
+// WithEnhancer modifies schemas and data with enhancer before they are written.
+func WithEnhancer(enhancer Enhancer) Option {
+	return func(opts *ExportOptions) {
+		opts.Enhancer = enhancer
+	}
+}
+
+// WithCodec compresses the OCF files with codec: "null", "deflate" or "snappy".
+func WithCodec(codec string) Option {
+	return func(opts *ExportOptions) {
+		opts.Codec = codec
+	}
+}
+
+// WithConcurrency exports n tables at once, see ExportOptions.Concurrency.
+func WithConcurrency(n int) Option {
+	return func(opts *ExportOptions) {
+		opts.Concurrency = n
+	}
+}
+
+// WithNamespace sets the namespace of the Avro records.
+func WithNamespace(namespace string) Option {
+	return func(opts *ExportOptions) {
+		opts.Namespace = namespace
+	}
+}
+
+// WithRecordDoc sets the doc of each Avro record to its provenance, see ExportOptions.RecordDoc.
+func WithRecordDoc() Option {
+	return func(opts *ExportOptions) {
+		opts.RecordDoc = true
+	}
+}
+
+// WithContext cancels the export when ctx is done.
+func WithContext(ctx context.Context) Option {
+	return func(opts *ExportOptions) {
+		opts.Context = ctx
+	}
+}
+
+// WithExcludeTables skips the tables matching the path.Match patterns.
+// Patterns are added to those of previous options.
+func WithExcludeTables(patterns ...string) Option {
+	return func(opts *ExportOptions) {
+		opts.ExcludeTables = append(opts.ExcludeTables, patterns...)
+	}
+}
+
+// Export exports a SQLite database to a set of OCF (Object Container File) files.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - path: The directory path where the OCF files will be saved.
+//   - opts: Options controlling the export.
+//
+// Returns:
+//   - []string: A slice of strings containing the paths of all created files.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// It is SqliteToAvroWithOptions configured with options, for example
+// Export(db, dir, WithPrefix("backup_"), WithCodec("deflate"), WithConcurrency(4)).
+func Export(db *sql.DB, path string, opts ...Option) ([]string, error) {
+	return SqliteToAvroWithOptions(db, path, exportOptions(opts))
+}
+
+// ExportTable exports a table to an OCF (Object Container File) file.
+// It is TableToOCFWithOptions configured with options.
+func ExportTable(db *sql.DB, table, fileName string, opts ...Option) error {
+	return TableToOCFWithOptions(db, table, fileName, exportOptions(opts))
+}
+
+// WithExportOptions replaces the options of the export with opts.
+// Options given after it are applied on top of opts.
+func WithExportOptions(opts ExportOptions) Option {
+	return func(o *ExportOptions) {
+		*o = opts
+	}
+}
+
+// OpenAndExport exports the SQLite database at dbPath to a set of OCF files.
+//
+// Parameters:
+//   - dbPath: The path of the SQLite database file.
+//   - outDir: The directory path where the OCF files will be saved.
+//   - prefix: A string to be prepended to each table name in the output file names.
+//   - opts: Options controlling the export.
+//
+// Returns:
+//   - []string: A slice of strings containing the paths of all created files.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// The database is opened read-only, so the export cannot modify it, and closed
+// when the export is done. See SqliteToAvroWithOptions.
+func OpenAndExport(dbPath, outDir, prefix string, opts ...Option) ([]string, error) {
+	exportOpts := exportOptions(opts)
+	exportOpts.Prefix = prefix
+
+	db, err := openReadOnly(dbPath)
+	if err != nil {
+		return []string{}, err
+	}
+	defer db.Close()
+
+	return SqliteToAvroWithOptions(db, outDir, exportOpts)
+}
+
+// OpenAndExportTable exports a table of the SQLite database at dbPath to an OCF file.
+// The database is opened read-only and closed when the export is done.
+// See TableToOCFWithOptions.
+func OpenAndExportTable(dbPath, table, fileName string, opts ...Option) error {
+	exportOpts := exportOptions(opts)
+
+	db, err := openReadOnly(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return TableToOCFWithOptions(db, table, fileName, exportOpts)
+}
+
+// openReadOnly opens the SQLite database at path in read-only mode.
+func openReadOnly(path string) (*sql.DB, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	dsn := (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs), RawQuery: "mode=ro"}).String()
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: [%w]", path, err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database %s: [%w]", path, err)
+	}
+	return db, nil
+}
@@ -0,0 +1,157 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hamba/avro"
+	"github.com/hamba/avro/ocf"
+)
+
+func TestOpenAndExport(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE foo (id INTEGER, name TEXT)",
+		"INSERT INTO foo (id, name) VALUES (1, 'bar'), (2, 'baz')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Exec(%q) error = %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	outDir := filepath.Join(dir, "out")
+	if err := os.Mkdir(outDir, 0o755); err != nil {
+		t.Fatalf("os.Mkdir() error = %v", err)
+	}
+	files, err := OpenAndExport(dbPath, outDir, "test_", WithJSON())
+	if err != nil {
+		t.Fatalf("OpenAndExport() error = %v", err)
+	}
+	want := []string{filepath.Join(outDir, "test_foo.avro"), filepath.Join(outDir, "test_foo.json")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("OpenAndExport() = %v, want %v", files, want)
+	}
+
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	if got := readOCFValues(t, data, "name"); !reflect.DeepEqual(got, []any{"bar", "baz"}) {
+		t.Errorf("OpenAndExport() names = %v, want %v", got, []any{"bar", "baz"})
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE foo (id INTEGER)"); err != nil {
+		t.Fatalf("Exec() error = %v", err)
+	}
+	db.Close()
+
+	ro, err := openReadOnly(dbPath)
+	if err != nil {
+		t.Fatalf("openReadOnly() error = %v", err)
+	}
+	defer ro.Close()
+	if _, err := ro.Exec("INSERT INTO foo (id) VALUES (1)"); err == nil {
+		t.Error("openReadOnly() allowed a write")
+	}
+
+	if _, err := openReadOnly(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Error("openReadOnly() of a missing file error = nil")
+	}
+}
+
+func TestExport(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE a (id INTEGER); CREATE TABLE b (id INTEGER); CREATE TABLE c (id INTEGER);
+		CREATE TABLE skipped (id INTEGER);
+		INSERT INTO a VALUES (1); INSERT INTO b VALUES (1), (2); INSERT INTO c VALUES (1), (2), (3);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	files, err := Export(db, dir,
+		WithPrefix("backup_"),
+		WithCodec("deflate"),
+		WithConcurrency(2),
+		WithNamespace("com.example"),
+		WithExcludeTables("skip*"),
+		WithJSON(),
+	)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	want := []string{}
+	for _, table := range []string{"a", "b", "c"} {
+		want = append(want, filepath.Join(dir, "backup_"+table+".avro"), filepath.Join(dir, "backup_"+table+".json"))
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("Export() = %v, want %v", files, want)
+	}
+
+	for i, table := range []string{"a", "b", "c"} {
+		data, err := os.ReadFile(filepath.Join(dir, "backup_"+table+".avro"))
+		if err != nil {
+			t.Fatalf("os.ReadFile() error = %v", err)
+		}
+		dec, err := ocf.NewDecoder(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("ocf.NewDecoder() error = %v", err)
+		}
+		if got := string(dec.Metadata()["avro.codec"]); got != "deflate" {
+			t.Errorf("Export() %s codec = %v, want %v", table, got, "deflate")
+		}
+		schema, err := avro.Parse(string(dec.Metadata()[ocfSchemaKey]))
+		if err != nil {
+			t.Fatalf("avro.Parse() error = %v", err)
+		}
+		if got := schema.(*avro.RecordSchema).FullName(); got != "com.example."+table {
+			t.Errorf("Export() %s record = %v, want %v", table, got, "com.example."+table)
+		}
+		if got := len(readOCFValues(t, data, "id")); got != i+1 {
+			t.Errorf("Export() %s rows = %v, want %v", table, got, i+1)
+		}
+	}
+
+	if _, err := Export(db, t.TempDir(), WithCodec("zstd")); err == nil {
+		t.Error("Export() with an unsupported codec error = nil")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Export(db, t.TempDir(), WithContext(ctx), WithConcurrency(3)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Export() with a canceled context error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestExportTable(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "foo.avro")
+	if err := ExportTable(testDB, "foo", fileName, WithCodec("snappy"), WithNamespace("com.example")); err != nil {
+		t.Fatalf("ExportTable() error = %v", err)
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	if got := readOCFValues(t, data, "name"); !reflect.DeepEqual(got, []any{"bar", "bat", "baz"}) {
+		t.Errorf("ExportTable() names = %v, want %v", got, []any{"bar", "bat", "baz"})
+	}
+}
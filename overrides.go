@@ -0,0 +1,178 @@
+package avrosqlite
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hamba/avro"
+)
+
+// SchemaOverride holds hints applied to the schema of a table during export.
+// It is a declarative alternative to an Enhancer for the common cases and is
+// usually loaded from a JSON file with LoadSchemaOverrides.
+type SchemaOverride struct {
+	// Namespace replaces AvroNamespace as the namespace of the table's Avro record.
+	Namespace string `json:"namespace,omitempty"`
+	// Skip lists the columns left out of the export.
+	Skip []string `json:"skip,omitempty"`
+	// Renames maps column names to the Avro field names they are exported as.
+	Renames map[string]string `json:"renames,omitempty"`
+	// LogicalTypes maps column names to the Avro logical type of their values,
+	// for example "timestamp-millis" for a column holding milliseconds since the epoch.
+	LogicalTypes map[string]avro.LogicalType `json:"logical_types,omitempty"`
+	// Enums maps column names to the symbols of the Avro enum their values are exported as.
+	Enums map[string][]string `json:"enums,omitempty"`
+}
+
+// LoadSchemaOverrides reads the schema overrides of each table from a JSON file.
+// The file holds an object keyed by table name, for example
+//
+//	{"events": {"skip": ["secret"], "logical_types": {"created_at": "timestamp-millis"}}}
+func LoadSchemaOverrides(path string) (map[string]SchemaOverride, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := map[string]SchemaOverride{}
+	if err := json.Unmarshal(b, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse schema overrides %s: [%w]", path, err)
+	}
+	return overrides, nil
+}
+
+// applySchema removes the skipped columns from schema.
+func (o SchemaOverride) applySchema(schema *SqliteSchema) {
+	if len(o.Skip) == 0 {
+		return
+	}
+	skip := map[string]bool{}
+	for _, name := range o.Skip {
+		skip[name] = true
+	}
+
+	fields := []SchemaField{}
+	for _, f := range schema.Fields {
+		if !skip[f.Name] {
+			fields = append(fields, f)
+		}
+	}
+	schema.Fields = fields
+}
+
+// avroOptions applies the override to the options used to convert the schema to Avro.
+func (o SchemaOverride) avroOptions(opts AvroOptions) AvroOptions {
+	if o.Namespace != "" {
+		opts.Namespace = o.Namespace
+	}
+	if len(o.Renames) > 0 {
+		opts.Renames = o.Renames
+	}
+	if len(o.LogicalTypes) > 0 || len(o.Enums) > 0 {
+		mapper := opts.TypeMapper
+		if mapper == nil {
+			mapper = DefaultTypeMapper{}
+		}
+		opts.TypeMapper = overrideTypeMapper{mapper: mapper, override: o}
+	}
+	return opts
+}
+
+// namespace returns the namespace of the table's Avro record.
+func (o SchemaOverride) namespace() string {
+	if o.Namespace != "" {
+		return o.Namespace
+	}
+	return AvroNamespace
+}
+
+// applyRow converts the values of the columns with a logical type or enum to
+// the Go types hamba/avro encodes them from.
+func (o SchemaOverride) applyRow(schema *SqliteSchema, row map[string]any) error {
+	for _, f := range schema.Fields {
+		v := row[f.Name]
+		if v == nil {
+			continue
+		}
+
+		if lt, ok := o.LogicalTypes[f.Name]; ok {
+			converted, err := toLogicalValue(lt, v)
+			if err != nil {
+				return fmt.Errorf("failed to convert column %s to %s: [%w]", f.Name, lt, err)
+			}
+			row[f.Name] = converted
+		} else if _, ok := o.Enums[f.Name]; ok && f.Nullable {
+			// a non-null value of a nullable enum is encoded as the enum branch of the union
+			row[f.Name] = map[string]any{o.namespace() + "." + sanitizeAvroName(f.Name): v}
+		}
+	}
+	return nil
+}
+
+// toLogicalValue converts an integer read from SQLite to the Go type of a logical type.
+// Values that already have that type are returned as is.
+func toLogicalValue(lt avro.LogicalType, v any) (any, error) {
+	switch v.(type) {
+	case time.Time, time.Duration:
+		return v, nil
+	}
+	i, ok := v.(int64)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %v (%T)", v, v)
+	}
+
+	switch lt {
+	case avro.Date:
+		return time.Unix(i*24*60*60, 0).UTC(), nil
+	case avro.TimeMillis:
+		return time.Duration(i) * time.Millisecond, nil
+	case avro.TimeMicros:
+		return time.Duration(i) * time.Microsecond, nil
+	case avro.TimestampMillis:
+		return time.UnixMilli(i).UTC(), nil
+	case avro.TimestampMicros:
+		return time.UnixMicro(i).UTC(), nil
+	}
+	return nil, fmt.Errorf("unsupported logical type %s", lt)
+}
+
+// logicalTypeBases maps the supported logical types to the primitive type they annotate.
+var logicalTypeBases = map[avro.LogicalType]avro.Type{
+	avro.Date:            avro.Int,
+	avro.TimeMillis:      avro.Int,
+	avro.TimeMicros:      avro.Long,
+	avro.TimestampMillis: avro.Long,
+	avro.TimestampMicros: avro.Long,
+}
+
+// overrideTypeMapper is a TypeMapper applying the logical types and enums of a SchemaOverride.
+type overrideTypeMapper struct {
+	mapper   TypeMapper
+	override SchemaOverride
+}
+
+func (m overrideTypeMapper) ToAvro(field SchemaField) (avro.Schema, error) {
+	var schema avro.Schema
+	if lt, ok := m.override.LogicalTypes[field.Name]; ok {
+		base, ok := logicalTypeBases[lt]
+		if !ok {
+			return nil, fmt.Errorf("unsupported logical type %s for column %s", lt, field.Name)
+		}
+		schema = avro.NewPrimitiveSchema(base, avro.NewPrimitiveLogicalSchema(lt))
+	} else if symbols, ok := m.override.Enums[field.Name]; ok {
+		enum, err := avro.NewEnumSchema(sanitizeAvroName(field.Name), m.override.namespace(), symbols)
+		if err != nil {
+			return nil, err
+		}
+		schema = enum
+	} else {
+		return m.mapper.ToAvro(field)
+	}
+
+	if field.Nullable {
+		return avro.NewUnionSchema([]avro.Schema{nullSchema, schema})
+	}
+	return schema, nil
+}
@@ -0,0 +1,92 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hamba/avro"
+	"github.com/hamba/avro/ocf"
+)
+
+func TestLoadSchemaOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overrides.json")
+	err := os.WriteFile(path, []byte(`{
+		"events": {
+			"namespace": "com.example",
+			"skip": ["secret"],
+			"renames": {"at": "created_at"},
+			"logical_types": {"at": "timestamp-millis"},
+			"enums": {"kind": ["CLICK", "VIEW"]}
+		}
+	}`), 0644)
+	if err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+
+	overrides, err := LoadSchemaOverrides(path)
+	if err != nil {
+		t.Fatalf("LoadSchemaOverrides() error = %v", err)
+	}
+	want := map[string]SchemaOverride{
+		"events": {
+			Namespace:    "com.example",
+			Skip:         []string{"secret"},
+			Renames:      map[string]string{"at": "created_at"},
+			LogicalTypes: map[string]avro.LogicalType{"at": avro.TimestampMillis},
+			Enums:        map[string][]string{"kind": {"CLICK", "VIEW"}},
+		},
+	}
+	if !reflect.DeepEqual(overrides, want) {
+		t.Fatalf("LoadSchemaOverrides() = %v, want %v", overrides, want)
+	}
+
+	db := newTestDB(t)
+	_, err = db.Exec(`CREATE TABLE events (id INTEGER, at INTEGER, kind TEXT, secret TEXT);
+		INSERT INTO events VALUES (1, 1700000000000, 'VIEW', 'hunter2');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = TableToOCFWriterWithOptions(db, "events", &buf, ExportOptions{Overrides: overrides})
+	if err != nil {
+		t.Fatalf("TableToOCFWriterWithOptions() error = %v", err)
+	}
+
+	dec, err := ocf.NewDecoder(&buf)
+	if err != nil {
+		t.Fatalf("ocf.NewDecoder() error = %v", err)
+	}
+	schema, err := avro.Parse(string(dec.Metadata()[ocfSchemaKey]))
+	if err != nil {
+		t.Fatalf("avro.Parse() error = %v", err)
+	}
+	record := schema.(*avro.RecordSchema)
+	if record.FullName() != "com.example.events" {
+		t.Errorf("TableToOCFWriterWithOptions() record = %v, want %v", record.FullName(), "com.example.events")
+	}
+	at := record.Fields()[1].Type().(*avro.UnionSchema).Types()[1].(*avro.PrimitiveSchema)
+	if at.Logical() == nil || at.Logical().Type() != avro.TimestampMillis {
+		t.Errorf("TableToOCFWriterWithOptions() created_at = %v, want a timestamp-millis", at)
+	}
+
+	if !dec.HasNext() {
+		t.Fatalf("HasNext() = false, want a record: %v", dec.Error())
+	}
+	var got map[string]any
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	wantRecord := map[string]any{
+		"id":         int64(1),
+		"created_at": time.UnixMilli(1700000000000).UTC(),
+		"kind":       map[string]any{"com.example.kind": "VIEW"},
+	}
+	if !reflect.DeepEqual(got, wantRecord) {
+		t.Errorf("Decode() = %v, want %v", got, wantRecord)
+	}
+}
@@ -0,0 +1,96 @@
+package avrosqlite
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// OversizePolicy controls what happens to TEXT and BLOB values longer than
+// ExportOptions.MaxTextBytes or ExportOptions.MaxBlobBytes.
+type OversizePolicy int
+
+const (
+	// OversizeError stops the export with an *OversizeValueError.
+	OversizeError OversizePolicy = iota
+	// OversizeTruncate cuts the value to the maximum length. Text is cut at the
+	// last complete UTF-8 character that fits, so it may end up a few bytes shorter.
+	OversizeTruncate
+	// OversizeSkip leaves the whole row out of the export.
+	OversizeSkip
+)
+
+// OversizeValueError describes a value longer than the maximum length of its kind.
+// It is returned by the export with OversizeError and passed to
+// ExportOptions.ReportOversize with the other policies.
+type OversizeValueError struct {
+	Table  string
+	Column string
+	// Row is the index of the row in the table, counting from 0.
+	Row int64
+	// Bytes is the length of the value and Limit the maximum length.
+	Bytes int
+	Limit int
+}
+
+func (e *OversizeValueError) Error() string {
+	return fmt.Sprintf("value of column %s of row %d of table %s is %d bytes long, more than %d", e.Column, e.Row, e.Table, e.Bytes, e.Limit)
+}
+
+// checkSizes applies the oversize policy to the TEXT and BLOB values of row,
+// the index-th row of the table. It reports whether the row is kept.
+func (e *tableExport) checkSizes(index int64, row map[string]any) (bool, error) {
+	if e.opts.MaxTextBytes <= 0 && e.opts.MaxBlobBytes <= 0 {
+		return true, nil
+	}
+	for _, f := range e.schema.Fields {
+		var size, limit int
+		switch v := row[f.Name].(type) {
+		case string:
+			size, limit = len(v), e.opts.MaxTextBytes
+		case []byte:
+			size, limit = len(v), e.opts.MaxBlobBytes
+		default:
+			continue
+		}
+		if limit <= 0 || size <= limit {
+			continue
+		}
+
+		oversize := &OversizeValueError{Table: e.schema.Table, Column: f.Name, Row: index, Bytes: size, Limit: limit}
+		switch e.opts.OnOversize {
+		case OversizeTruncate:
+			row[f.Name] = truncateValue(row[f.Name], limit)
+		case OversizeSkip:
+		default:
+			return false, oversize
+		}
+		if e.opts.OnOversize == OversizeTruncate {
+			e.opts.logger().Warn("oversize value truncated", "table", oversize.Table, "column", oversize.Column, "row", oversize.Row, "bytes", oversize.Bytes, "limit", oversize.Limit)
+		} else {
+			e.opts.logger().Warn("row with an oversize value skipped", "table", oversize.Table, "column", oversize.Column, "row", oversize.Row, "bytes", oversize.Bytes, "limit", oversize.Limit)
+		}
+		if e.opts.ReportOversize != nil {
+			e.opts.ReportOversize(oversize)
+		}
+		if e.opts.OnOversize == OversizeSkip {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// truncateValue cuts a string or a []byte to at most limit bytes, without
+// splitting a UTF-8 encoded character of a string.
+func truncateValue(v any, limit int) any {
+	switch v := v.(type) {
+	case string:
+		// v[limit] exists since v is longer than limit
+		for limit > 0 && !utf8.RuneStart(v[limit]) {
+			limit--
+		}
+		return v[:limit]
+	case []byte:
+		return v[:limit]
+	}
+	return v
+}
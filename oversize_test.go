@@ -0,0 +1,93 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTableToOCFWriterWithOptions_Oversize(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE notes (id INTEGER, body TEXT, data BLOB);
+		INSERT INTO notes VALUES (1, 'short', x'00'), (2, 'héllo world', x'0102030405');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		policy      OversizePolicy
+		wantErr     bool
+		wantIDs     []any
+		wantBodies  []any
+		wantData    []any
+		wantReports []OversizeValueError
+	}{
+		{
+			name:    "error",
+			policy:  OversizeError,
+			wantErr: true,
+		},
+		{
+			name:       "truncate",
+			policy:     OversizeTruncate,
+			wantIDs:    []any{int64(1), int64(2)},
+			wantBodies: []any{"short", "héll"},
+			wantData:   []any{[]byte{0}, []byte{1, 2, 3}},
+			wantReports: []OversizeValueError{
+				{Table: "notes", Column: "body", Row: 1, Bytes: 12, Limit: 5},
+				{Table: "notes", Column: "data", Row: 1, Bytes: 5, Limit: 3},
+			},
+		},
+		{
+			name:       "skip",
+			policy:     OversizeSkip,
+			wantIDs:    []any{int64(1)},
+			wantBodies: []any{"short"},
+			wantData:   []any{[]byte{0}},
+			wantReports: []OversizeValueError{
+				{Table: "notes", Column: "body", Row: 1, Bytes: 12, Limit: 5},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reports := []OversizeValueError{}
+			var buf bytes.Buffer
+			err := TableToOCFWriterWithOptions(db, "notes", &buf, ExportOptions{
+				MaxTextBytes:   5,
+				MaxBlobBytes:   3,
+				OnOversize:     tt.policy,
+				ReportOversize: func(e *OversizeValueError) { reports = append(reports, *e) },
+			})
+			if tt.wantErr {
+				var oversize *OversizeValueError
+				if !errors.As(err, &oversize) {
+					t.Fatalf("TableToOCFWriterWithOptions() error = %v, want an *OversizeValueError", err)
+				}
+				want := OversizeValueError{Table: "notes", Column: "body", Row: 1, Bytes: 12, Limit: 5}
+				if *oversize != want {
+					t.Errorf("TableToOCFWriterWithOptions() error = %+v, want %+v", *oversize, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TableToOCFWriterWithOptions() error = %v", err)
+			}
+
+			if got := readOCFValues(t, buf.Bytes(), "id"); !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Errorf("id = %v, want %v", got, tt.wantIDs)
+			}
+			if got := readOCFValues(t, buf.Bytes(), "body"); !reflect.DeepEqual(got, tt.wantBodies) {
+				t.Errorf("body = %v, want %v", got, tt.wantBodies)
+			}
+			if got := readOCFValues(t, buf.Bytes(), "data"); !reflect.DeepEqual(got, tt.wantData) {
+				t.Errorf("data = %v, want %v", got, tt.wantData)
+			}
+			if !reflect.DeepEqual(reports, tt.wantReports) {
+				t.Errorf("ReportOversize() calls = %+v, want %+v", reports, tt.wantReports)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package avrosqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hamba/avro"
+	"github.com/hamba/avro/ocf"
+)
+
+// QueryToOCF writes the results of a query to an OCF (Object Container File) file.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - query: The SELECT statement to export, such as a join or an aggregate.
+//   - args: The arguments of the query's placeholders (can be nil).
+//   - recordName: The name of the Avro record the rows are exported as.
+//   - fileName: The name of the OCF file to be created.
+//   - enhancer: An Enhancer interface for modifying the schema and data (can be nil).
+//
+// Returns:
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// The schema is inferred from the columns of the result with SchemaFromColumnTypes:
+// a column taken directly from a table has the declared type of that table column.
+// An expression, such as COUNT(*), has no declared type: it is exported as the
+// storage class of its values in the first rows of the result if they all have the
+// same one, and as an any column otherwise. A later value of another storage class
+// fails the export with an *EncodeError. Every column is nullable, since SQLite does
+// not report the nullability of results. Only the rows the types are inferred from
+// are held in memory, the others are streamed to the file.
+// Columns must have unique names, use aliases to rename duplicates of a join.
+func QueryToOCF(db *sql.DB, query string, args []any, recordName, fileName string, enhancer Enhancer) error {
+	return writeFile(fileName, func(f *os.File) error {
+		return queryToOCF(db, query, args, recordName, f, enhancer)
+	})
+}
+
+// queryInferenceRows is the number of rows of a query result the types of its
+// columns without a declared type are inferred from.
+const queryInferenceRows = 1000
+
+// queryToOCF writes the results of a query as an OCF to w. See QueryToOCF.
+func queryToOCF(q Querier, query string, args []any, recordName string, w io.Writer, enhancer Enhancer) error {
+	if enhancer == nil {
+		enhancer = &noopEnhancer{}
+	}
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to run query: [%w]", err)
+	}
+	defer rows.Close()
+
+	cts, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	schema, err := SchemaFromColumnTypes(cts)
+	if err != nil {
+		return err
+	}
+	schema.Table = recordName
+
+	// the first rows are read before the schema is converted, so that the type
+	// of columns without a declared type can be inferred from their values
+	sample := []map[string]any{}
+	reader, err := newRowReader(rows)
+	if err != nil {
+		return err
+	}
+	done := false
+	for len(sample) < queryInferenceRows {
+		row, err := reader.Next()
+		if err == io.EOF {
+			done = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+		sample = append(sample, row)
+	}
+	inferUntypedColumns(schema, sample)
+
+	if err := enhancer.Schema(schema); err != nil {
+		return err
+	}
+	avroSchema, err := schema.ToAvroWithOptions(AvroOptions{SanitizeNames: true})
+	if err != nil {
+		return err
+	}
+	names, err := schema.AvroFieldNames(AvroOptions{SanitizeNames: true})
+	if err != nil {
+		return err
+	}
+
+	enc, err := ocf.NewEncoder(avroSchema.String(), w)
+	if err != nil {
+		return err
+	}
+	defer enc.Close()
+
+	write := func(i int64, row map[string]any) error {
+		if err := enhancer.Row(row); err != nil {
+			return err
+		}
+		record := toAvroRecord(names, row)
+		if err := enc.Encode(record); err != nil {
+			return encodeError(avroSchema, recordName, i, record, err)
+		}
+		return nil
+	}
+	var i int64
+	for _, row := range sample {
+		if err := write(i, row); err != nil {
+			return err
+		}
+		i++
+	}
+	for !done {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := write(i, row); err != nil {
+			return err
+		}
+		i++
+	}
+	return enc.Flush()
+}
+
+// SchemaFromColumnTypes returns the schema of the columns of a query result,
+// as returned by sql.Rows.ColumnTypes. Each column is mapped to a field of its
+// declared type, which for a column taken directly from a table or a view is
+// the type declared for that column, see NormalizeSqliteType. Columns without a
+// declared type, such as expressions and aggregates, are mapped to any fields. Fields are nullable
+// unless the driver reports the column as not nullable. The Table of the
+// returned schema is empty. An error wrapping ErrUnsupportedType is returned
+// if a declared type has no Avro equivalent.
+func SchemaFromColumnTypes(cts []*sql.ColumnType) (*SqliteSchema, error) {
+	schema := &SqliteSchema{Fields: make([]SchemaField, 0, len(cts))}
+	for _, ct := range cts {
+		// expressions have no declared type
+		dataType := SqliteAny
+		if name := ct.DatabaseTypeName(); name != "" {
+			dataType = NormalizeSqliteType(name)
+		}
+		if _, err := SqliteTypeToAvroSchema(dataType, false); err != nil {
+			return nil, fmt.Errorf("failed to convert column %q: [%w]", ct.Name(), err)
+		}
+		nullable, ok := ct.Nullable()
+		schema.Fields = append(schema.Fields, SchemaField{
+			Name:     ct.Name(),
+			Type:     dataType,
+			Nullable: nullable || !ok,
+			Default:  avro.NoDefault,
+		})
+	}
+	return schema, nil
+}
+
+// inferUntypedColumns replaces the any type of the fields of schema with the
+// storage class of their values in data, when all of their non-null values
+// have the same storage class. Fields without values are left as any.
+// data is a sample of the rows, such as the first rows of a query result.
+func inferUntypedColumns(schema *SqliteSchema, data []map[string]any) {
+	for i, f := range schema.Fields {
+		if f.Type != SqliteAny {
+			continue
+		}
+		var class SqliteType
+		for _, row := range data {
+			c, ok := valueStorageClass(row[f.Name])
+			if !ok {
+				continue
+			}
+			if class != "" && c != class {
+				class = ""
+				break
+			}
+			class = c
+		}
+		if class != "" {
+			schema.Fields[i].Type = class
+		}
+	}
+}
+
+// valueStorageClass returns the storage class of a value read from a column
+// without a declared type. It returns false for NULL.
+func valueStorageClass(v any) (SqliteType, bool) {
+	switch v.(type) {
+	case int64:
+		return SqliteInteger, true
+	case float64:
+		return SqliteReal, true
+	case string:
+		return SqliteText, true
+	case []byte:
+		return SqliteBlob, true
+	}
+	return "", false
+}
@@ -0,0 +1,273 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hamba/avro"
+	"github.com/hamba/avro/ocf"
+)
+
+func TestQueryToOCF(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE meats (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, meat_id INTEGER REFERENCES meats (id), pounds REAL);
+		INSERT INTO meats VALUES (1, 'beef'), (2, 'pork');
+		INSERT INTO orders VALUES (1, 1, 2.5), (2, 1, 1.0), (3, 2, 4.0);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		args       []any
+		wantSchema string
+		want       []map[string]any
+	}{
+		{
+			name:       "join",
+			query:      "SELECT orders.id, meats.name AS meat, orders.pounds FROM orders JOIN meats ON meats.id = orders.meat_id WHERE orders.pounds > ? ORDER BY orders.id",
+			args:       []any{1.0},
+			wantSchema: `{"name":"com.github.britt.avrosqlite.report","type":"record","fields":[{"name":"id","type":["null","long"]},{"name":"meat","type":["null","string"]},{"name":"pounds","type":["null","double"]}]}`,
+			want: []map[string]any{
+				{"id": int64(1), "meat": "beef", "pounds": 2.5},
+				{"id": int64(3), "meat": "pork", "pounds": 4.0},
+			},
+		},
+		{
+			name:       "aggregate",
+			query:      "SELECT meats.name, COUNT(*) AS orders FROM orders JOIN meats ON meats.id = orders.meat_id GROUP BY meats.name ORDER BY meats.name",
+			wantSchema: `{"name":"com.github.britt.avrosqlite.report","type":"record","fields":[{"name":"name","type":["null","string"]},{"name":"orders","type":["null","long"]}]}`,
+			want: []map[string]any{
+				{"name": "beef", "orders": int64(2)},
+				{"name": "pork", "orders": int64(1)},
+			},
+		},
+		{
+			name:       "mixed expression",
+			query:      "SELECT CASE WHEN pounds > 2 THEN 'heavy' ELSE pounds END AS weight FROM orders ORDER BY id",
+			wantSchema: `{"name":"com.github.britt.avrosqlite.report","type":"record","fields":[{"name":"weight","type":["null","long","double","string","bytes"]}]}`,
+			want: []map[string]any{
+				{"weight": "heavy"},
+				{"weight": 1.0},
+				{"weight": "heavy"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "report.avro")
+			if err := QueryToOCF(db, tt.query, tt.args, "report", fileName, nil); err != nil {
+				t.Fatalf("QueryToOCF() error = %v", err)
+			}
+
+			f, err := os.Open(fileName)
+			if err != nil {
+				t.Fatalf("os.Open() error = %v", err)
+			}
+			defer f.Close()
+			dec, err := ocf.NewDecoder(f)
+			if err != nil {
+				t.Fatalf("ocf.NewDecoder() error = %v", err)
+			}
+			if got := string(dec.Metadata()["avro.schema"]); got != tt.wantSchema {
+				t.Errorf("QueryToOCF() schema = %v, want %v", got, tt.wantSchema)
+			}
+			got := []map[string]any{}
+			for dec.HasNext() {
+				var record map[string]any
+				if err := dec.Decode(&record); err != nil {
+					t.Fatalf("Decode() error = %v", err)
+				}
+				got = append(got, record)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("QueryToOCF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if err := QueryToOCF(db, "SELECT * FROM missing", nil, "report", filepath.Join(t.TempDir(), "report.avro"), nil); err == nil {
+		t.Error("QueryToOCF() with an invalid query error = nil")
+	}
+}
+
+func TestQueryToOCF_InferenceRows(t *testing.T) {
+	db := newTestDB(t)
+	rows := queryInferenceRows + 10
+	numbers := fmt.Sprintf("WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < %d) ", rows)
+
+	// the rows after those the type is inferred from are streamed
+	var buf bytes.Buffer
+	if err := queryToOCF(db, numbers+"SELECT i * 2 AS doubled FROM n", nil, "numbers", &buf, nil); err != nil {
+		t.Fatalf("queryToOCF() error = %v", err)
+	}
+	got := readOCFValues(t, buf.Bytes(), "doubled")
+	if len(got) != rows {
+		t.Fatalf("queryToOCF() wrote %d rows, want %d", len(got), rows)
+	}
+	if want := int64(2 * rows); got[rows-1] != want {
+		t.Errorf("queryToOCF() last value = %v, want %v", got[rows-1], want)
+	}
+
+	// a value of another storage class after them cannot be encoded
+	query := numbers + fmt.Sprintf("SELECT CASE WHEN i > %d THEN 'many' ELSE i END AS mixed FROM n", queryInferenceRows)
+	err := queryToOCF(db, query, nil, "numbers", io.Discard, nil)
+	var encodeErr *EncodeError
+	if !errors.As(err, &encodeErr) {
+		t.Fatalf("queryToOCF() error = %v, want an *EncodeError", err)
+	}
+	if encodeErr.Field != "mixed" || encodeErr.Row != queryInferenceRows {
+		t.Errorf("queryToOCF() error field %s row %d, want field mixed row %d", encodeErr.Field, encodeErr.Row, queryInferenceRows)
+	}
+}
+
+// fakeColumn is a result column of fakeColumnsConn.
+type fakeColumn struct {
+	name       string
+	typeName   string
+	nullable   bool
+	nullableOK bool
+}
+
+// fakeColumnsConn is a database/sql driver connection whose queries return no
+// rows and the columns it was created with, to build synthetic sql.ColumnTypes.
+type fakeColumnsConn struct {
+	columns []fakeColumn
+}
+
+func (c *fakeColumnsConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *fakeColumnsConn) Driver() driver.Driver                        { return nil }
+func (c *fakeColumnsConn) Prepare(string) (driver.Stmt, error)          { return c, nil }
+func (c *fakeColumnsConn) Close() error                                 { return nil }
+func (c *fakeColumnsConn) Begin() (driver.Tx, error)                    { return nil, errors.New("not supported") }
+func (c *fakeColumnsConn) NumInput() int                                { return -1 }
+func (c *fakeColumnsConn) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+func (c *fakeColumnsConn) Query([]driver.Value) (driver.Rows, error) {
+	return &fakeColumnsRows{c.columns}, nil
+}
+
+type fakeColumnsRows struct {
+	columns []fakeColumn
+}
+
+func (r *fakeColumnsRows) Columns() []string {
+	names := []string{}
+	for _, c := range r.columns {
+		names = append(names, c.name)
+	}
+	return names
+}
+func (r *fakeColumnsRows) Close() error              { return nil }
+func (r *fakeColumnsRows) Next([]driver.Value) error { return io.EOF }
+func (r *fakeColumnsRows) ColumnTypeDatabaseTypeName(i int) string {
+	return r.columns[i].typeName
+}
+func (r *fakeColumnsRows) ColumnTypeNullable(i int) (bool, bool) {
+	return r.columns[i].nullable, r.columns[i].nullableOK
+}
+
+// columnTypes returns the sql.ColumnTypes of a result with the given columns.
+func columnTypes(t *testing.T, columns []fakeColumn) []*sql.ColumnType {
+	t.Helper()
+	db := sql.OpenDB(&fakeColumnsConn{columns: columns})
+	t.Cleanup(func() { db.Close() })
+	rows, err := db.Query("SELECT")
+	if err != nil {
+		t.Fatalf("db.Query() error = %v", err)
+	}
+	defer rows.Close()
+	cts, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatalf("rows.ColumnTypes() error = %v", err)
+	}
+	return cts
+}
+
+func TestSchemaFromColumnTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns []fakeColumn
+		want    []SchemaField
+		wantErr error
+	}{
+		{
+			name: "declared types",
+			columns: []fakeColumn{
+				{name: "id", typeName: "INTEGER", nullable: false, nullableOK: true},
+				{name: "name", typeName: "TEXT", nullable: true, nullableOK: true},
+				{name: "active", typeName: "BOOL", nullable: true, nullableOK: true},
+			},
+			want: []SchemaField{
+				{Name: "id", Type: SqliteInteger, Nullable: false, Default: avro.NoDefault},
+				{Name: "name", Type: SqliteText, Nullable: true, Default: avro.NoDefault},
+				{Name: "active", Type: SqliteBoolean, Nullable: true, Default: avro.NoDefault},
+			},
+		},
+		{
+			name: "unknown nullability and empty type name",
+			columns: []fakeColumn{
+				{name: "total", typeName: "", nullableOK: false},
+			},
+			want: []SchemaField{
+				{Name: "total", Type: SqliteAny, Nullable: true, Default: avro.NoDefault},
+			},
+		},
+		{
+			name: "types mapped by affinity",
+			columns: []fakeColumn{
+				{name: "shape", typeName: "GEOMETRY", nullable: true, nullableOK: true},
+				{name: "title", typeName: "VARCHAR(255)", nullable: true, nullableOK: true},
+			},
+			want: []SchemaField{
+				{Name: "shape", Type: SqliteAny, Nullable: true, Default: avro.NoDefault},
+				{Name: "title", Type: SqliteText, Nullable: true, Default: avro.NoDefault},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SchemaFromColumnTypes(columnTypes(t, tt.columns))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SchemaFromColumnTypes() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got.Fields, tt.want) {
+				t.Errorf("SchemaFromColumnTypes() = %+v, want %+v", got.Fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestInferUntypedColumns(t *testing.T) {
+	schema := &SqliteSchema{Fields: []SchemaField{
+		{Name: "count", Type: SqliteAny, Nullable: true},
+		{Name: "mixed", Type: SqliteAny, Nullable: true},
+		{Name: "empty", Type: SqliteAny, Nullable: true},
+		{Name: "name", Type: SqliteText, Nullable: true},
+	}}
+	inferUntypedColumns(schema, []map[string]any{
+		{"count": int64(1), "mixed": "a", "empty": nil, "name": "x"},
+		{"count": nil, "mixed": 2.5, "empty": nil, "name": int64(3)},
+	})
+	want := []SqliteType{SqliteInteger, SqliteAny, SqliteAny, SqliteText}
+	for i, f := range schema.Fields {
+		if f.Type != want[i] {
+			t.Errorf("inferUntypedColumns() %s = %v, want %v", f.Name, f.Type, want[i])
+		}
+	}
+}
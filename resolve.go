@@ -0,0 +1,415 @@
+package avrosqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/hamba/avro"
+	"github.com/hamba/avro/ocf"
+)
+
+// LoadAvroWithReaderSchema loads Avro data written with another schema into a SQLite database.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - schema: A pointer to the SqliteSchema containing the table structure.
+//   - writerSchema: The Avro schema the data was written with.
+//   - r: An io.Reader providing the Avro data to be loaded.
+//
+// Returns:
+//   - int64: The number of records successfully inserted into the database.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// The data is decoded with writerSchema and resolved to the Avro form of schema,
+// the reader schema, following the Avro schema resolution rules
+// (https://avro.apache.org/docs/1.8.2/spec.html#Schema+Resolution): fields of the
+// writer that are not in the reader are dropped, numbers are promoted (int to long,
+// long to double, ...) and strings and bytes are converted to each other. A field
+// of the reader missing from the writer is read from the field named after one
+// of its Aliases, as when a column was renamed, or else takes its default,
+// nullable columns defaulting to NULL. An error is returned before reading any
+// data if a field cannot be resolved. The table is created or truncated as with LoadAvro.
+func LoadAvroWithReaderSchema(db *sql.DB, schema *SqliteSchema, writerSchema avro.Schema, r io.Reader) (int64, error) {
+	readerSchema, err := schema.ToAvroWithOptions(AvroOptions{SanitizeNames: true})
+	if err != nil {
+		return 0, err
+	}
+	writer, ok := writerSchema.(*avro.RecordSchema)
+	if !ok {
+		return 0, fmt.Errorf("writer schema must be a record, got %s", writerSchema.Type())
+	}
+	reader := readerSchema.(*avro.RecordSchema)
+	names, err := schema.loadFieldNames()
+	if err != nil {
+		return 0, err
+	}
+	aliases := schema.avroAliases(names)
+	if err := checkResolvable(reader, writer, aliases); err != nil {
+		return 0, err
+	}
+
+	dec, err := avro.NewDecoder(writer.String(), r)
+	if err != nil {
+		return 0, err
+	}
+	result, err := loadRecords(db, schema, &resolvingDecoder{decoder: dec, reader: reader, writer: writer, aliases: aliases}, LoadOptions{})
+	return result.Inserted, err
+}
+
+// OCFFilesToTable loads OCF (Object Container File) files written with evolving schemas into a single table.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - files: The paths of the OCF files to load, oldest first.
+//   - table: The name of the table to load into. If empty, the name of the Avro record is used.
+//
+// Returns:
+//   - int64: The total number of records successfully inserted into the database.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// The table is built from the widest schema embedded in the files, the one with
+// the most fields, the last of them winning ties, so that the newest schema is
+// used when the files are given oldest first. The records of every file are
+// resolved to it as with LoadAvroWithReaderSchema. See OCFFilesToTableWithSchema
+// to choose the schema of the table.
+func OCFFilesToTable(db *sql.DB, files []string, table string) (int64, error) {
+	return OCFFilesToTableWithSchema(db, files, table, nil)
+}
+
+// OCFFilesToTableWithSchema loads OCF (Object Container File) files into a single
+// table built from the given reader schema.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - files: The paths of the OCF files to load, in the order they are loaded.
+//   - table: The name of the table to load into. If empty, the name of the Avro record is used.
+//   - readerSchema: The Avro record schema of the table. If nil, the widest schema of the files is used.
+//
+// Returns:
+//   - int64: The total number of records successfully inserted into the database.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// The records of every file are resolved to readerSchema as with LoadAvroWithReaderSchema.
+// An error naming the file is returned before loading any data if the schema of
+// a file cannot be resolved to readerSchema. The files are loaded in a single
+// transaction, the table being created or truncated as with LoadAvro.
+func OCFFilesToTableWithSchema(db *sql.DB, files []string, table string, readerSchema avro.Schema) (int64, error) {
+	if len(files) == 0 {
+		return 0, fmt.Errorf("no OCF files to load")
+	}
+
+	decoders := make([]*ocf.Decoder, 0, len(files))
+	writers := make([]*avro.RecordSchema, 0, len(files))
+	for _, fileName := range files {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open OCF file: [%w]", err)
+		}
+		defer f.Close()
+
+		r, err := decompress(f, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read OCF file %s: [%w]", fileName, err)
+		}
+		dec, err := ocf.NewDecoder(r)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read OCF file %s: [%w]", fileName, err)
+		}
+		writerSchema, err := avro.Parse(string(dec.Metadata()[ocfSchemaKey]))
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse the schema of OCF file %s: [%w]", fileName, err)
+		}
+		writer, ok := writerSchema.(*avro.RecordSchema)
+		if !ok {
+			return 0, fmt.Errorf("schema of OCF file %s must be a record, got %s", fileName, writerSchema.Type())
+		}
+		decoders = append(decoders, dec)
+		writers = append(writers, writer)
+	}
+
+	if readerSchema == nil {
+		widest := writers[0]
+		for _, writer := range writers[1:] {
+			if len(writer.Fields()) >= len(widest.Fields()) {
+				widest = writer
+			}
+		}
+		readerSchema = widest
+	}
+	schema, err := AvroToSqliteSchema(readerSchema)
+	if err != nil {
+		return 0, err
+	}
+	if table != "" {
+		schema.Table = table
+		schema.Sql, err = schema.GenerateSQL()
+		if err != nil {
+			return 0, err
+		}
+	}
+	// records are resolved to the schema of the table, which may differ from readerSchema
+	// in its types, such as int columns being stored as long
+	tableSchema, err := schema.ToAvroWithOptions(AvroOptions{SanitizeNames: true})
+	if err != nil {
+		return 0, err
+	}
+	reader := tableSchema.(*avro.RecordSchema)
+
+	resolving := make([]recordDecoder, 0, len(decoders))
+	for i, dec := range decoders {
+		if err := checkResolvable(reader, writers[i], nil); err != nil {
+			return 0, fmt.Errorf("schema of OCF file %s cannot be resolved: [%w]", files[i], err)
+		}
+		resolving = append(resolving, &resolvingDecoder{decoder: &ocfRecordDecoder{dec: dec}, reader: reader, writer: writers[i]})
+	}
+
+	result, err := loadRecords(db, schema, &shardRecordDecoder{decoders: resolving}, LoadOptions{})
+	return result.Inserted, err
+}
+
+// checkResolvable returns an error if records written with writer cannot be read
+// with reader, the fields of reader being also looked up in writer by the names
+// aliases maps them to. Unlike avro.SchemaCompatibility the names of the records may differ.
+func checkResolvable(reader, writer *avro.RecordSchema, aliases map[string][]string) error {
+	compat := avro.NewSchemaCompatibility()
+	for _, field := range reader.Fields() {
+		writerField := aliasedField(writer, field.Name(), aliases[field.Name()])
+		if writerField == nil {
+			if !field.HasDefault() && !isNullable(field.Type()) {
+				return fmt.Errorf("field %s is missing from the writer schema and has no default", field.Name())
+			}
+			continue
+		}
+		if err := compat.Compatible(field.Type(), writerField.Type()); err != nil {
+			return fmt.Errorf("field %s cannot be resolved: [%w]", field.Name(), err)
+		}
+	}
+	return nil
+}
+
+// resolvingDecoder decodes records written with the writer schema and resolves
+// them to the reader schema. See LoadAvroWithReaderSchema.
+type resolvingDecoder struct {
+	decoder recordDecoder
+	reader  *avro.RecordSchema
+	writer  *avro.RecordSchema
+	// aliases maps the fields of reader to their previous names
+	aliases map[string][]string
+}
+
+func (d *resolvingDecoder) Decode(v any) error {
+	var written map[string]any
+	if err := d.decoder.Decode(&written); err != nil {
+		return err
+	}
+
+	out, ok := v.(*map[string]any)
+	if !ok {
+		return fmt.Errorf("cannot decode record into %T", v)
+	}
+	record := make(map[string]any, len(d.reader.Fields()))
+	for _, field := range d.reader.Fields() {
+		writerField := aliasedField(d.writer, field.Name(), d.aliases[field.Name()])
+		switch {
+		case writerField != nil:
+			record[field.Name()] = resolveValue(field.Type(), writerField.Type(), written[writerField.Name()])
+		case field.HasDefault():
+			record[field.Name()] = field.Default()
+		default:
+			record[field.Name()] = nil
+		}
+	}
+	*out = record
+	return nil
+}
+
+// resolveValue converts a value decoded with the writer schema to the Go value
+// it is decoded as with the reader schema.
+func resolveValue(reader, writer avro.Schema, v any) any {
+	if writer.Type() == avro.Union {
+		v = unionValue(v)
+	}
+	if v == nil {
+		return nil
+	}
+
+	branch := reader
+	union, ok := reader.(*avro.UnionSchema)
+	nullable := ok && union.Nullable()
+	if nullable {
+		_, typ := union.Indices()
+		branch = union.Types()[typ]
+	}
+	switch branch.Type() {
+	case avro.Long:
+		if n, ok := v.(int); ok {
+			v = int64(n)
+		}
+	case avro.Float:
+		switch n := v.(type) {
+		case int:
+			v = float32(n)
+		case int64:
+			v = float32(n)
+		}
+	case avro.Double:
+		switch n := v.(type) {
+		case int:
+			v = float64(n)
+		case int64:
+			v = float64(n)
+		case float32:
+			v = float64(n)
+		}
+	case avro.String:
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+	case avro.Bytes:
+		if s, ok := v.(string); ok {
+			v = []byte(s)
+		}
+	case avro.Array, avro.Map:
+		// collections in a union are decoded as {"map": value}
+		if nullable {
+			v = map[string]any{string(branch.Type()): v}
+		}
+	}
+	return v
+}
+
+// reconcileSchema returns the schema of an existing table the records written with
+// writer are loaded into: the columns of the table holding a field of writer, as
+// matched by LoadOptions.recordKeys. The other columns take their default, and
+// the fields of writer with no column are dropped, or an error with StrictFields.
+func reconcileSchema(q Querier, table string, writer avro.Schema, opts LoadOptions) (*SqliteSchema, error) {
+	record, ok := writer.(*avro.RecordSchema)
+	if !ok {
+		return nil, fmt.Errorf("avro schema must be a record, got %s", writer.Type())
+	}
+	logger := opts.logger()
+	existing, err := readSchema(q, table, logger)
+	if err != nil {
+		return nil, err
+	}
+	written := make(map[string]any, len(record.Fields()))
+	for _, field := range record.Fields() {
+		written[field.Name()] = nil
+	}
+	keys, err := opts.recordKeys(existing, existing.columnNames(), written)
+	if err != nil {
+		return nil, err
+	}
+
+	reconciled := *existing
+	reconciled.Fields = []SchemaField{}
+	loaded := map[string]bool{}
+	for _, f := range existing.Fields {
+		key := keys[f.Name]
+		if _, ok := written[key]; !ok {
+			if !f.Generated {
+				logger.Info("column missing from the OCF takes its default", "table", table, "column", f.Name, "default", f.Default)
+			}
+			continue
+		}
+		loaded[key] = true
+		reconciled.Fields = append(reconciled.Fields, f)
+	}
+	for _, field := range record.Fields() {
+		if loaded[field.Name()] {
+			continue
+		}
+		if opts.StrictFields {
+			return nil, fmt.Errorf("field %s of the OCF has no column in table %s", field.Name(), table)
+		}
+		logger.Warn("field of the OCF with no column dropped", "table", table, "field", field.Name())
+	}
+	return &reconciled, nil
+}
+
+// writerTypedDecoder converts the dates and times of the records decoded by
+// decoder to the text SQLite stores them as, following the types of the fields
+// of the writer schema. Without it they are converted following the types of the
+// columns they are loaded into, and a date loaded into a TEXT column of an
+// existing table would be bound as a time.Time.
+type writerTypedDecoder struct {
+	decoder recordDecoder
+	// types are the types of the date and time fields of the writer schema
+	types map[string]SqliteType
+}
+
+// newWriterTypedDecoder returns a writerTypedDecoder of the records written with writer.
+func newWriterTypedDecoder(decoder recordDecoder, writer *avro.RecordSchema) recordDecoder {
+	types := map[string]SqliteType{}
+	for _, field := range writer.Fields() {
+		t, _, err := avroSchemaToSqliteType(field.Type())
+		if err != nil {
+			continue
+		}
+		switch t {
+		case SqliteDate, SqliteDatetime, SqliteTimestamp:
+			types[field.Name()] = t
+		}
+	}
+	if len(types) == 0 {
+		return decoder
+	}
+	return &writerTypedDecoder{decoder: decoder, types: types}
+}
+
+func (d *writerTypedDecoder) Decode(v any) error {
+	if err := d.decoder.Decode(v); err != nil {
+		return err
+	}
+	record, ok := v.(*map[string]any)
+	if !ok {
+		return fmt.Errorf("cannot decode record into %T", v)
+	}
+	for name, t := range d.types {
+		if tm, ok := unionValue((*record)[name]).(time.Time); ok {
+			(*record)[name] = toSqliteValue(t, tm)
+		}
+	}
+	return nil
+}
+
+// recordField returns the field of a record with the given name, or nil.
+func recordField(record *avro.RecordSchema, name string) *avro.Field {
+	for _, field := range record.Fields() {
+		if field.Name() == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// aliasedField returns the field of a record with the given name, or else with
+// the first of its aliases the record has a field of, or nil.
+func aliasedField(record *avro.RecordSchema, name string, aliases []string) *avro.Field {
+	if field := recordField(record, name); field != nil {
+		return field
+	}
+	for _, alias := range aliases {
+		if field := recordField(record, alias); field != nil {
+			return field
+		}
+	}
+	return nil
+}
+
+// isNullable reports whether schema is a union including null.
+func isNullable(schema avro.Schema) bool {
+	union, ok := schema.(*avro.UnionSchema)
+	if !ok {
+		return false
+	}
+	for _, typ := range union.Types() {
+		if typ.Type() == avro.Null {
+			return true
+		}
+	}
+	return false
+}
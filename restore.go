@@ -0,0 +1,206 @@
+package avrosqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hamba/avro/ocf"
+)
+
+// AvroDirToSqlite restores the tables exported by SqliteToAvro to a SQLite database.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - dir: The directory holding the exported files.
+//   - prefix: The prefix of the exported file names.
+//
+// Returns:
+//   - []string: The names of the restored tables, in the order they were loaded.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// Every table must have been exported with its JSON schema (ExportOptions.IncludeJSON),
+// which holds its creation SQL and foreign keys. Tables are created and loaded so that
+// parent tables come before the tables referencing them. Tables whose foreign keys form
+// a cycle are loaded last with foreign key enforcement turned off, then checked with
+// PRAGMA foreign_key_check. As with LoadAvro, existing tables are truncated.
+func AvroDirToSqlite(db *sql.DB, dir, prefix string) ([]string, error) {
+	restored := []string{}
+
+	schemaFiles, err := filepath.Glob(filepath.Join(dir, globEscape(prefix)+"*.json"))
+	if err != nil {
+		return restored, err
+	}
+	schemas := map[string]*SqliteSchema{}
+	ocfFiles := map[string]string{}
+	for _, schemaFile := range schemaFiles {
+		if filepath.Base(schemaFile) == ManifestFileName {
+			continue
+		}
+		schema, err := readSchemaFile(schemaFile)
+		if err != nil {
+			return restored, err
+		}
+		schemas[schema.Table] = schema
+		ocfFiles[schema.Table] = strings.TrimSuffix(schemaFile, ".json") + ".avro"
+	}
+	if len(schemas) == 0 {
+		return restored, fmt.Errorf("no JSON schema files found in %s", dir)
+	}
+
+	ordered, cyclic := foreignKeyOrder(schemas)
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return restored, err
+	}
+	defer conn.Close()
+	cq := &connQuerier{conn: conn}
+
+	for _, table := range ordered {
+		if err := restoreTable(cq, schemas[table], ocfFiles[table]); err != nil {
+			return restored, err
+		}
+		restored = append(restored, table)
+	}
+	if len(cyclic) == 0 {
+		return restored, nil
+	}
+
+	var enforced bool
+	if err := conn.QueryRowContext(context.Background(), "PRAGMA foreign_keys").Scan(&enforced); err != nil {
+		return restored, err
+	}
+	if enforced {
+		if _, err := cq.Exec("PRAGMA foreign_keys = OFF"); err != nil {
+			return restored, err
+		}
+		defer cq.Exec("PRAGMA foreign_keys = ON")
+	}
+	for _, table := range cyclic {
+		if err := restoreTable(cq, schemas[table], ocfFiles[table]); err != nil {
+			return restored, err
+		}
+		restored = append(restored, table)
+	}
+	for _, table := range cyclic {
+		if err := checkForeignKeys(cq, table); err != nil {
+			return restored, err
+		}
+	}
+	return restored, nil
+}
+
+// readSchemaFile reads a JSON schema file written by TableToJSON.
+func readSchemaFile(fileName string) (*SqliteSchema, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema file: [%w]", err)
+	}
+	schema := &SqliteSchema{}
+	if err := json.Unmarshal(b, schema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema file %s: [%w]", fileName, err)
+	}
+	return schema, nil
+}
+
+// restoreTable creates or truncates a table and loads the OCF file into it in a transaction.
+func restoreTable(cq *connQuerier, schema *SqliteSchema, ocfFile string) error {
+	f, err := os.Open(ocfFile)
+	if err != nil {
+		return fmt.Errorf("failed to open OCF file: [%w]", err)
+	}
+	defer f.Close()
+
+	r, err := decompress(f, nil)
+	if err != nil {
+		return fmt.Errorf("failed to read OCF file %s: [%w]", ocfFile, err)
+	}
+	dec, err := ocf.NewDecoder(r)
+	if err != nil {
+		return fmt.Errorf("failed to read OCF file %s: [%w]", ocfFile, err)
+	}
+	_, err = loadRecordsInTx(cq, schema, &ocfRecordDecoder{dec: dec}, LoadOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to restore table %s: [%w]", schema.Table, err)
+	}
+	return nil
+}
+
+// checkForeignKeys returns an error if rows of table reference missing parent rows.
+func checkForeignKeys(q Querier, table string) error {
+	rows, err := q.Query(fmt.Sprintf("PRAGMA foreign_key_check(%s)", quoteIdentifier(table)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return fmt.Errorf("table %s has rows violating its foreign keys", table)
+	}
+	return rows.Err()
+}
+
+// foreignKeyOrder sorts tables so that every table comes after the tables it
+// references. Tables that are part of, or depend on, a cycle of foreign keys
+// cannot be ordered and are returned separately. References to tables that are
+// not in schemas and to the table itself are ignored. Ties are broken by name.
+func foreignKeyOrder(schemas map[string]*SqliteSchema) ([]string, []string) {
+	parents := map[string]map[string]bool{}
+	children := map[string][]string{}
+	for table, schema := range schemas {
+		parents[table] = map[string]bool{}
+		for _, fk := range schema.ForeignKeys {
+			if _, ok := schemas[fk.ParentTable]; !ok || fk.ParentTable == table || parents[table][fk.ParentTable] {
+				continue
+			}
+			parents[table][fk.ParentTable] = true
+			children[fk.ParentTable] = append(children[fk.ParentTable], table)
+		}
+	}
+
+	ready := []string{}
+	for table := range schemas {
+		if len(parents[table]) == 0 {
+			ready = append(ready, table)
+		}
+	}
+	ordered := []string{}
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		table := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, table)
+		for _, child := range children[table] {
+			delete(parents[child], table)
+			if len(parents[child]) == 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+
+	cyclic := []string{}
+	for table := range schemas {
+		if len(parents[table]) > 0 {
+			cyclic = append(cyclic, table)
+		}
+	}
+	sort.Strings(cyclic)
+	return ordered, cyclic
+}
+
+// globEscape escapes the characters of s that have a meaning in a filepath.Match pattern.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[\`, c) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,269 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAvroDirToSqlite(t *testing.T) {
+	src := newTestDB(t)
+	_, err := src.Exec(`CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE addresses (id INTEGER PRIMARY KEY, person_id INTEGER REFERENCES people (id), city TEXT);
+		INSERT INTO people VALUES (1, 'Ann'), (2, 'Bob');
+		INSERT INTO addresses VALUES (1, 2, 'Oslo'), (2, 1, 'Lima');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	dir := t.TempDir()
+	if _, err := SqliteToAvro(src, dir, "backup_", true, nil); err != nil {
+		t.Fatalf("SqliteToAvro() error = %v", err)
+	}
+
+	dst, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "restored.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer dst.Close()
+
+	// addresses sorts before people, but references it
+	got, err := AvroDirToSqlite(dst, dir, "backup_")
+	if err != nil {
+		t.Fatalf("AvroDirToSqlite() error = %v", err)
+	}
+	if want := []string{"people", "addresses"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AvroDirToSqlite() = %v, want %v", got, want)
+	}
+
+	for _, table := range []string{"people", "addresses"} {
+		want, err := LoadData(src, table)
+		if err != nil {
+			t.Fatalf("LoadData() error = %v", err)
+		}
+		rows, err := LoadData(dst, table)
+		if err != nil {
+			t.Fatalf("LoadData() error = %v", err)
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Errorf("LoadData(%s) = %v, want %v", table, rows, want)
+		}
+	}
+	schema, err := ReadSchema(dst, "addresses")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	if len(schema.ForeignKeys) != 1 || schema.ForeignKeys[0].ParentTable != "people" {
+		t.Errorf("ReadSchema() ForeignKeys = %+v, want a reference to people", schema.ForeignKeys)
+	}
+}
+
+func Test_foreignKeyOrder(t *testing.T) {
+	references := func(parents ...string) *SqliteSchema {
+		schema := &SqliteSchema{}
+		for _, parent := range parents {
+			schema.ForeignKeys = append(schema.ForeignKeys, ForeignKey{Columns: []string{"id"}, ParentTable: parent})
+		}
+		return schema
+	}
+	schemas := map[string]*SqliteSchema{
+		"orders":    references("customers", "external"),
+		"customers": references(),
+		"lines":     references("orders", "lines"),
+		"eggs":      references("chickens"),
+		"chickens":  references("eggs"),
+		"nests":     references("chickens"),
+	}
+
+	ordered, cyclic := foreignKeyOrder(schemas)
+	if want := []string{"customers", "orders", "lines"}; !reflect.DeepEqual(ordered, want) {
+		t.Errorf("foreignKeyOrder() ordered = %v, want %v", ordered, want)
+	}
+	if want := []string{"chickens", "eggs", "nests"}; !reflect.DeepEqual(cyclic, want) {
+		t.Errorf("foreignKeyOrder() cyclic = %v, want %v", cyclic, want)
+	}
+}
+
+func TestAvroDirToSqlite_Cycle(t *testing.T) {
+	src := newTestDB(t)
+	_, err := src.Exec(`CREATE TABLE chickens (id INTEGER PRIMARY KEY, egg_id INTEGER REFERENCES eggs (id));
+		CREATE TABLE eggs (id INTEGER PRIMARY KEY, chicken_id INTEGER REFERENCES chickens (id));
+		INSERT INTO chickens VALUES (1, 1);
+		INSERT INTO eggs VALUES (1, 1);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	dir := t.TempDir()
+	if _, err := SqliteToAvro(src, dir, "", true, nil); err != nil {
+		t.Fatalf("SqliteToAvro() error = %v", err)
+	}
+
+	dst, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "restored.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer dst.Close()
+
+	got, err := AvroDirToSqlite(dst, dir, "")
+	if err != nil {
+		t.Fatalf("AvroDirToSqlite() error = %v", err)
+	}
+	if want := []string{"chickens", "eggs"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AvroDirToSqlite() = %v, want %v", got, want)
+	}
+
+	// enforcement is restored once the tables are loaded
+	if _, err := dst.Exec("INSERT INTO eggs VALUES (2, 42)"); err == nil {
+		t.Error("inserting an egg of a missing chicken succeeded, want a foreign key error")
+	}
+}
+
+func TestAvroDirToSqlite_Sequence(t *testing.T) {
+	src := newTestDB(t)
+	_, err := src.Exec(`CREATE TABLE tickets (id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT);
+		INSERT INTO tickets (title) VALUES ('a'), ('b'), ('c');
+		DELETE FROM tickets WHERE id = 3;`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	dir := t.TempDir()
+	opts := ExportOptions{IncludeJSON: true, IncludeSequence: true, Manifest: true}
+	if _, err := SqliteToAvroWithOptions(src, dir, opts); err != nil {
+		t.Fatalf("SqliteToAvroWithOptions() error = %v", err)
+	}
+	manifest, err := ReadManifest(filepath.Join(dir, ManifestFileName))
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	if seq := manifest.Tables[0].Sequence; seq == nil || *seq != 3 {
+		t.Errorf("ReadManifest() sequence = %v, want 3", seq)
+	}
+
+	dst := newTestDB(t)
+	if _, err := AvroDirToSqlite(dst, dir, ""); err != nil {
+		t.Fatalf("AvroDirToSqlite() error = %v", err)
+	}
+	// the largest restored id is 2, but 3 was used before the export
+	res, err := dst.Exec("INSERT INTO tickets (title) VALUES ('d')")
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	if id, _ := res.LastInsertId(); id != 4 {
+		t.Errorf("next id after restore = %v, want %v", id, 4)
+	}
+}
+
+func TestLoadAvro_SequenceWithoutAutoincrement(t *testing.T) {
+	seq := int64(3)
+	schema := &SqliteSchema{
+		Table:    "tickets",
+		Sql:      "CREATE TABLE tickets (id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT)",
+		Fields:   []SchemaField{{Name: "id", Type: SqliteInteger, PrimaryKey: 1}, {Name: "title", Type: SqliteText, Nullable: true}},
+		Sequence: &seq,
+	}
+	data := encodeAvro(t, schema, []map[string]any{{"id": int64(1), "title": "a"}})
+
+	// the existing table has no AUTOINCREMENT key, but another table of the
+	// database has one, so sqlite_sequence exists
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE tickets (id INTEGER PRIMARY KEY, title TEXT);
+		CREATE TABLE other (id INTEGER PRIMARY KEY AUTOINCREMENT);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	if _, err := LoadAvro(db, schema, bytes.NewReader(data)); err != nil {
+		t.Fatalf("LoadAvro() error = %v", err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_sequence WHERE name = 'tickets'").Scan(&count); err != nil {
+		t.Fatalf("db.QueryRow() error = %v", err)
+	}
+	if count != 0 {
+		t.Errorf("sqlite_sequence rows of tickets = %v, want 0", count)
+	}
+}
+
+func TestAvroDirToSqlite_Triggers(t *testing.T) {
+	src := newTestDB(t)
+	_, err := src.Exec(`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE audit (item_id INTEGER);
+		CREATE TRIGGER items_audit AFTER INSERT ON items BEGIN INSERT INTO audit VALUES (NEW.id); END;
+		INSERT INTO items VALUES (1, 'a'), (2, 'b');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	schema, err := ReadSchema(src, "items")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	want := []string{"CREATE TRIGGER items_audit AFTER INSERT ON items BEGIN INSERT INTO audit VALUES (NEW.id); END"}
+	if !reflect.DeepEqual(schema.Triggers, want) {
+		t.Errorf("ReadSchema() Triggers = %v, want %v", schema.Triggers, want)
+	}
+
+	dir := t.TempDir()
+	if _, err := SqliteToAvro(src, dir, "", true, nil); err != nil {
+		t.Fatalf("SqliteToAvro() error = %v", err)
+	}
+	dst := newTestDB(t)
+	if _, err := AvroDirToSqlite(dst, dir, ""); err != nil {
+		t.Fatalf("AvroDirToSqlite() error = %v", err)
+	}
+
+	count := func() int {
+		var n int
+		if err := dst.QueryRow("SELECT count(*) FROM audit").Scan(&n); err != nil {
+			t.Fatalf("db.QueryRow() error = %v", err)
+		}
+		return n
+	}
+	// the trigger did not fire for the restored rows
+	if got := count(); got != 2 {
+		t.Errorf("audit rows after restore = %v, want %v", got, 2)
+	}
+	if _, err := dst.Exec("INSERT INTO items VALUES (3, 'c')"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	if got := count(); got != 3 {
+		t.Errorf("audit rows after an insert = %v, want %v", got, 3)
+	}
+
+	// loading again into the existing table keeps the single trigger
+	restored, err := ReadSchema(dst, "items")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	if _, err := LoadAvro(dst, restored, bytes.NewReader(encodeAvro(t, restored, nil))); err != nil {
+		t.Fatalf("LoadAvro() error = %v", err)
+	}
+	if !reflect.DeepEqual(restored.Triggers, want) {
+		t.Errorf("ReadSchema() Triggers = %v, want %v", restored.Triggers, want)
+	}
+}
+
+func TestAvroDirToSqlite_RowID(t *testing.T) {
+	src := newTestDB(t)
+	_, err := src.Exec(`CREATE TABLE notes (body TEXT);
+		INSERT INTO notes (rowid, body) VALUES (7, 'a'), (42, 'b');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	dir := t.TempDir()
+	if _, err := SqliteToAvroWithOptions(src, dir, ExportOptions{IncludeJSON: true, IncludeRowID: true}); err != nil {
+		t.Fatalf("SqliteToAvroWithOptions() error = %v", err)
+	}
+
+	dst := newTestDB(t)
+	if _, err := AvroDirToSqlite(dst, dir, ""); err != nil {
+		t.Fatalf("AvroDirToSqlite() error = %v", err)
+	}
+	rows, err := loadRows(dst, "SELECT rowid, body FROM notes ORDER BY rowid")
+	if err != nil {
+		t.Fatalf("loadRows() error = %v", err)
+	}
+	want := []map[string]any{{"rowid": int64(7), "body": "a"}, {"rowid": int64(42), "body": "b"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("AvroDirToSqlite() rows = %v, want %v", rows, want)
+	}
+}
@@ -0,0 +1,176 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hamba/avro/ocf"
+)
+
+// VerifyRoundTrip checks that a table survives an export and an import unchanged.
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//   - table: The name of the table to verify.
+//
+// Returns:
+//   - error: An error describing the divergences found, nil if there are none.
+//
+// The table is exported to an in-memory OCF as TableToOCFBytes does, then loaded
+// into a throwaway in-memory database. The schema fingerprints of the source and
+// restored tables are compared, then their rows. Rows are compared regardless of
+// their order using the SQL literal of each value, as returned by quote(), so a
+// value changing storage class, such as a boolean restored as an integer or a
+// REAL restored as TEXT, is a divergence.
+func VerifyRoundTrip(db *sql.DB, table string) error {
+	return verifyRoundTrip(db, table, ExportOptions{})
+}
+
+// verifyRoundTrip checks that a table exported with opts is restored unchanged.
+// See VerifyRoundTrip.
+func verifyRoundTrip(db *sql.DB, table string, opts ExportOptions) error {
+	schema, err := ReadSchema(db, table)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if _, err := tableToOCF(db, table, &buf, opts); err != nil {
+		return fmt.Errorf("failed to export table %s: [%w]", table, err)
+	}
+
+	restored, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return err
+	}
+	defer restored.Close()
+	// every connection to :memory: is a separate database
+	restored.SetMaxOpenConns(1)
+
+	dec, err := ocf.NewDecoder(&buf)
+	if err != nil {
+		return err
+	}
+	if _, err := loadRecords(restored, schema, &ocfRecordDecoder{dec: dec}, LoadOptions{}); err != nil {
+		return fmt.Errorf("failed to import table %s: [%w]", table, err)
+	}
+
+	restoredSchema, err := ReadSchema(restored, table)
+	if err != nil {
+		return err
+	}
+	want, err := schema.Fingerprint()
+	if err != nil {
+		return err
+	}
+	got, err := restoredSchema.Fingerprint()
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("schema of table %s differs after the round trip: fingerprint %x, want %x", table, got, want)
+	}
+
+	columns := schema.columnNames()
+	source, err := quotedRows(db, table, columns)
+	if err != nil {
+		return err
+	}
+	dest, err := quotedRows(restored, table, columns)
+	if err != nil {
+		return err
+	}
+	return compareRows(table, columns, source, dest)
+}
+
+// quotedRows returns the rows of a table as the SQL literals of their values,
+// keyed by the hash of the row and counted, so that duplicate rows are kept.
+func quotedRows(q Querier, table string, columns []string) (map[[32]byte]*quotedRow, error) {
+	selected := make([]string, 0, len(columns))
+	for _, col := range columns {
+		selected = append(selected, fmt.Sprintf("quote(%s)", quoteIdentifier(col)))
+	}
+	rows, err := q.Query(fmt.Sprintf("SELECT %s FROM %s", strings.Join(selected, ", "), quoteTableName(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[[32]byte]*quotedRow{}
+	values := make([]string, len(columns))
+	ptrs := make([]any, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		for _, v := range values {
+			// values are length prefixed so that they cannot run into each other
+			fmt.Fprintf(h, "%d:%s", len(v), v)
+		}
+		var key [32]byte
+		copy(key[:], h.Sum(nil))
+		if row, ok := out[key]; ok {
+			row.count++
+			continue
+		}
+		out[key] = &quotedRow{values: append([]string{}, values...), count: 1}
+	}
+	return out, rows.Err()
+}
+
+// quotedRow is a row of SQL literals and the number of times it was found.
+type quotedRow struct {
+	values []string
+	count  int
+}
+
+// maxReportedDiffs is the number of differing rows described by compareRows.
+const maxReportedDiffs = 3
+
+// compareRows returns an error describing the rows of source that are not found
+// as many times in dest, and the rows of dest that are not in source.
+func compareRows(table string, columns []string, source, dest map[[32]byte]*quotedRow) error {
+	describe := func(row *quotedRow) string {
+		pairs := make([]string, 0, len(columns))
+		for i, col := range columns {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", col, row.values[i]))
+		}
+		return strings.Join(pairs, ", ")
+	}
+
+	diffs := []string{}
+	for key, row := range source {
+		if other, ok := dest[key]; !ok || other.count != row.count {
+			diffs = append(diffs, fmt.Sprintf("source row (%s) found %d times, restored %d times", describe(row), row.count, restoredCount(other)))
+		}
+	}
+	for key, row := range dest {
+		if _, ok := source[key]; !ok {
+			diffs = append(diffs, fmt.Sprintf("restored row (%s) not found in the source", describe(row)))
+		}
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	sort.Strings(diffs)
+	shown := diffs
+	if len(shown) > maxReportedDiffs {
+		shown = shown[:maxReportedDiffs]
+	}
+	return fmt.Errorf("rows of table %s differ after the round trip (%d differences): %s", table, len(diffs), strings.Join(shown, "; "))
+}
+
+// restoredCount returns the number of times a row was found, 0 if row is nil.
+func restoredCount(row *quotedRow) int {
+	if row == nil {
+		return 0
+	}
+	return row.count
+}
@@ -0,0 +1,51 @@
+package avrosqlite
+
+import (
+	"strings"
+	"testing"
+)
+
+// mutatingEnhancer changes the name of the row with the given id.
+type mutatingEnhancer struct {
+	noopEnhancer
+	id int64
+}
+
+func (e *mutatingEnhancer) Row(row map[string]any) error {
+	if row["id"] == e.id {
+		row["name"] = "mangled"
+	}
+	return nil
+}
+
+func TestVerifyRoundTrip(t *testing.T) {
+	for _, table := range []string{"foo", "meats"} {
+		t.Run(table, func(t *testing.T) {
+			if err := VerifyRoundTrip(testDB, table); err != nil {
+				t.Errorf("VerifyRoundTrip() error = %v", err)
+			}
+		})
+	}
+
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE readings (id INTEGER, value REAL, flag BOOLEAN, data BLOB);
+		INSERT INTO readings VALUES (1, 1.5, 1, x'00ff'), (1, 1.5, 1, x'00ff'), (2, NULL, 0, NULL);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	if err := VerifyRoundTrip(db, "readings"); err != nil {
+		t.Errorf("VerifyRoundTrip() error = %v", err)
+	}
+}
+
+func TestVerifyRoundTrip_Divergence(t *testing.T) {
+	err := verifyRoundTrip(testDB, "foo", ExportOptions{Enhancer: &mutatingEnhancer{id: 2}})
+	if err == nil {
+		t.Fatal("verifyRoundTrip() error = nil, want an error")
+	}
+	for _, want := range []string{"id=2", "name='bat'", "name='mangled'"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("verifyRoundTrip() error = %v, want it to contain %s", err, want)
+		}
+	}
+}
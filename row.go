@@ -0,0 +1,128 @@
+package avrosqlite
+
+import (
+	"database/sql"
+)
+
+// Row is a single row of a table together with the schema describing its columns.
+// Its typed accessors check the declared SqliteType of a column before returning
+// its value, so callers don't have to type switch on map[string]any values.
+type Row struct {
+	Schema *SqliteSchema
+	Values map[string]any
+}
+
+// LoadRows retrieves all data from the specified SQLite table as Rows sharing the table's schema.
+func LoadRows(db *sql.DB, table string) ([]Row, error) {
+	rows := []Row{}
+	schema, err := ReadSchema(db, table)
+	if err != nil {
+		return rows, err
+	}
+	data, err := LoadData(db, table)
+	if err != nil {
+		return rows, err
+	}
+
+	for _, values := range data {
+		rows = append(rows, Row{Schema: schema, Values: values})
+	}
+	return rows, nil
+}
+
+// IsNull reports whether the value of the column is NULL or the column does not exist.
+func (r Row) IsNull(col string) bool {
+	return r.Values[col] == nil
+}
+
+// Int64 returns the value of an INTEGER column.
+// It returns false if the column is not an INTEGER column or its value is NULL.
+func (r Row) Int64(col string) (int64, bool) {
+	if !r.hasType(col, SqliteInteger) {
+		return 0, false
+	}
+	switch v := r.Values[col].(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// Float64 returns the value of a REAL column.
+// It returns false if the column is not a REAL column or its value is NULL.
+func (r Row) Float64(col string) (float64, bool) {
+	if !r.hasType(col, SqliteReal) {
+		return 0, false
+	}
+	switch v := r.Values[col].(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// String returns the value of a TEXT column.
+// It returns false if the column is not a TEXT column or its value is NULL.
+func (r Row) String(col string) (string, bool) {
+	if !r.hasType(col, SqliteText) {
+		return "", false
+	}
+	switch v := r.Values[col].(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	}
+	return "", false
+}
+
+// Bytes returns the value of a BLOB column.
+// It returns false if the column is not a BLOB column or its value is NULL.
+func (r Row) Bytes(col string) ([]byte, bool) {
+	if !r.hasType(col, SqliteBlob) {
+		return nil, false
+	}
+	switch v := r.Values[col].(type) {
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	}
+	return nil, false
+}
+
+// Bool returns the value of a BOOLEAN column.
+// It returns false if the column is not a BOOLEAN column or its value is NULL.
+func (r Row) Bool(col string) (bool, bool) {
+	if !r.hasType(col, SqliteBoolean) {
+		return false, false
+	}
+	switch v := r.Values[col].(type) {
+	case bool:
+		return v, true
+	case int64:
+		return v != 0, true
+	}
+	return false, false
+}
+
+// hasType reports whether the column exists in the schema with the given type.
+func (r Row) hasType(col string, t SqliteType) bool {
+	if r.Schema == nil {
+		return false
+	}
+	for _, f := range r.Schema.Fields {
+		if f.Name == col {
+			return f.Type == t
+		}
+	}
+	return false
+}
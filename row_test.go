@@ -0,0 +1,65 @@
+package avrosqlite
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadRows(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec("CREATE TABLE things (id INTEGER, price REAL, name TEXT, data BLOB, active BOOLEAN)")
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	_, err = db.Exec("INSERT INTO things VALUES (1, 2.5, 'King', X'00FF', 1), (NULL, NULL, NULL, NULL, NULL)")
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	rows, err := LoadRows(db, "things")
+	if err != nil {
+		t.Fatalf("LoadRows() error = %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("LoadRows() = %v rows, want %v", len(rows), 2)
+	}
+	row, null := rows[0], rows[1]
+
+	tests := []struct {
+		name   string
+		get    func(Row) (any, bool)
+		want   any
+		wantOk bool
+	}{
+		{name: "Int64", get: func(r Row) (any, bool) { return r.Int64("id") }, want: int64(1), wantOk: true},
+		{name: "Float64", get: func(r Row) (any, bool) { return r.Float64("price") }, want: 2.5, wantOk: true},
+		{name: "String", get: func(r Row) (any, bool) { return r.String("name") }, want: "King", wantOk: true},
+		{name: "Bytes", get: func(r Row) (any, bool) { return r.Bytes("data") }, want: []byte{0x00, 0xff}, wantOk: true},
+		{name: "Bool", get: func(r Row) (any, bool) { return r.Bool("active") }, want: true, wantOk: true},
+		{name: "wrong type", get: func(r Row) (any, bool) { return r.String("id") }, want: "", wantOk: false},
+		{name: "missing column", get: func(r Row) (any, bool) { return r.Int64("age") }, want: int64(0), wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.get(row)
+			if ok != tt.wantOk {
+				t.Errorf("Row.%s() ok = %v, want %v", tt.name, ok, tt.wantOk)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Row.%s() = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+
+	for _, col := range []string{"id", "price", "name", "data", "active"} {
+		if row.IsNull(col) {
+			t.Errorf("Row.IsNull(%v) = %v, want %v", col, true, false)
+		}
+		if !null.IsNull(col) {
+			t.Errorf("Row.IsNull(%v) = %v, want %v", col, false, true)
+		}
+	}
+	if _, ok := null.Int64("id"); ok {
+		t.Errorf("Row.Int64() ok = %v for NULL, want %v", ok, false)
+	}
+}
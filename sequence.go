@@ -0,0 +1,89 @@
+package avrosqlite
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// autoincrementPattern matches the AUTOINCREMENT keyword of a CREATE TABLE statement.
+var autoincrementPattern = regexp.MustCompile(`(?i)\bAUTOINCREMENT\b`)
+
+// sequenceTable returns the quoted name of the sqlite_sequence table of the
+// schema holding a table, and whether it exists. It only exists once a table
+// with an AUTOINCREMENT primary key has been created in the schema.
+func sequenceTable(q Querier, table string) (string, bool, error) {
+	seqTable := qualifiedName(table, "sqlite_sequence")
+	exists, err := tableExists(q, seqTable)
+	return quoteTableName(seqTable), exists, err
+}
+
+// readSequence returns the AUTOINCREMENT high-water mark of a table recorded in
+// sqlite_sequence, or nil if the table has none.
+func readSequence(q Querier, table string) (*int64, error) {
+	seqTable, ok, err := sequenceTable(q, table)
+	if err != nil || !ok {
+		return nil, err
+	}
+	_, name := splitTableName(table)
+	rows, err := q.Query(fmt.Sprintf("SELECT seq FROM %s WHERE name = ?", seqTable), name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the sequence of table %s: [%w]", table, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var seq int64
+	if err := rows.Scan(&seq); err != nil {
+		return nil, err
+	}
+	return &seq, nil
+}
+
+// restoreSequence raises the AUTOINCREMENT high-water mark of a table to seq,
+// so that new rows are not given ids that were already used. It does nothing
+// if the table has no AUTOINCREMENT primary key.
+func restoreSequence(q Querier, table string, seq int64) error {
+	seqTable, ok, err := sequenceTable(q, table)
+	if err != nil || !ok {
+		return err
+	}
+	// sqlite_sequence exists as soon as any table of the schema has an
+	// AUTOINCREMENT key, a row for another table would be an orphan
+	ok, err = hasAutoincrement(q, table)
+	if err != nil || !ok {
+		return err
+	}
+	_, name := splitTableName(table)
+	// inserting rows with explicit ids already raised the sequence to the largest id
+	res, err := q.Exec(fmt.Sprintf("UPDATE %s SET seq = max(seq, ?) WHERE name = ?", seqTable), seq, name)
+	if err != nil {
+		return fmt.Errorf("failed to restore the sequence of table %s: [%w]", table, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	if _, err := q.Exec(fmt.Sprintf("INSERT INTO %s (name, seq) VALUES (?, ?)", seqTable), name, seq); err != nil {
+		return fmt.Errorf("failed to restore the sequence of table %s: [%w]", table, err)
+	}
+	return nil
+}
+
+// hasAutoincrement reports whether a table was created with an AUTOINCREMENT
+// primary key, the only tables SQLite keeps a sequence of.
+func hasAutoincrement(q Querier, table string) (bool, error) {
+	schema, name := splitTableName(table)
+	rows, err := q.Query(fmt.Sprintf("SELECT sql FROM %s WHERE type='table' AND name=?", masterTable(schema)), name)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the statement of table %s: [%w]", table, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+	var ddl string
+	if err := rows.Scan(&ddl); err != nil {
+		return false, err
+	}
+	return autoincrementPattern.MatchString(ddl), nil
+}
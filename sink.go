@@ -0,0 +1,291 @@
+package avrosqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// RecordSink is the destination of the rows decoded from Avro records by
+// LoadAvroToSink. SQLiteSink, the sink of LoadAvro, inserts them into a table;
+// other sinks can write them to another database, print them or send them on
+// a channel.
+//
+// Begin is called once before the first row, and Commit once after the last
+// one if every row was written. Close is always called last, after Commit or
+// instead of it if the load failed, in which case the rows written since Begin
+// should be discarded if the destination allows it.
+type RecordSink interface {
+	// Begin prepares the sink for the rows of the table described by schema.
+	// columns are the loaded columns of the schema, in order: its columns, or
+	// those selected by LoadOptions.Fields, without the generated columns.
+	Begin(schema *SqliteSchema, columns []string) error
+	// WriteRow writes a row keyed by column name. It holds the loaded columns,
+	// with their values converted as they are stored in SQLite. Each row is a
+	// new map, which the sink may keep.
+	WriteRow(row map[string]any) error
+	// Commit completes the load once every row was written.
+	Commit() error
+	// Close releases the resources of the sink.
+	Close() error
+}
+
+// LoadAvroToSink decodes Avro data and writes each record to a RecordSink.
+//
+// Parameters:
+//   - sink: The RecordSink the rows are written to.
+//   - schema: A pointer to the SqliteSchema describing the rows.
+//   - r: An io.Reader providing the Avro data to be loaded.
+//   - opts: The LoadOptions controlling the decoding of the records.
+//
+// Returns:
+//   - int64: The number of rows written to the sink.
+//   - error: An error if any occurred during the process, nil otherwise.
+//
+// The records are decoded and converted to rows as with LoadAvroWithOptions,
+// which loads them with a SQLiteSink. Only the options about decoding apply:
+// Fields, WriterSchema, Flatten, Decompress, ExactFieldNames and Logger.
+func LoadAvroToSink(sink RecordSink, schema *SqliteSchema, r io.Reader, opts LoadOptions) (int64, error) {
+	decoder, _, err := opts.recordDecoder(schema, r)
+	if err != nil {
+		return 0, err
+	}
+	return writeRecords(sink, schema, decoder, opts)
+}
+
+// writeRecords writes every record produced by decoder to sink as a row of the
+// table described by schema, and returns the number of rows written.
+func writeRecords(sink RecordSink, schema *SqliteSchema, decoder recordDecoder, opts LoadOptions) (written int64, err error) {
+	defer func() {
+		if closeErr := sink.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	fields, err := opts.projectFields(schema)
+	if err != nil {
+		return 0, err
+	}
+
+	columns := []string{}
+	types := map[string]SqliteType{}
+	jsonFields := map[string]SchemaField{}
+	for _, f := range fields {
+		// generated columns cannot be inserted into
+		if f.Generated {
+			opts.logger().Info("values of generated column ignored", "table", schema.Table, "column", f.Name)
+			continue
+		}
+		columns = append(columns, f.Name)
+		types[f.Name] = f.Type
+		if f.JSON {
+			jsonFields[f.Name] = f
+		}
+	}
+	if err := sink.Begin(schema, columns); err != nil {
+		return 0, err
+	}
+
+	// keys maps columns to the fields of the records holding them, once resolved from the first record
+	var keys map[string]string
+	for {
+		// decode each record into a new map, the decoder adds to an existing one
+		var st map[string]any
+		err := decoder.Decode(&st)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode record %d: [%w]", written, err)
+		}
+
+		if keys == nil {
+			keys, err = opts.recordKeys(schema, columns, st)
+			if err != nil {
+				return written, err
+			}
+		}
+
+		row := make(map[string]any, len(columns))
+		for _, f := range columns {
+			v, ok := st[keys[f]]
+			if !ok && len(opts.Fields) > 0 {
+				return written, fmt.Errorf("record has no field %s", f)
+			}
+			if field, ok := jsonFields[f]; ok {
+				// a map in a union is decoded as {"map": value}, like any other
+				// union branch, so it cannot be told apart from a map of one key
+				// without knowing the field is nullable
+				if field.Nullable {
+					v = unionValue(v)
+				}
+				text, err := toJSONText(v)
+				if err != nil {
+					return written, fmt.Errorf("failed to encode field %s as JSON: [%w]", f, err)
+				}
+				row[f] = text
+				continue
+			}
+			row[f] = toSqliteValue(types[f], unionValue(v))
+		}
+
+		if err := sink.WriteRow(row); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, sink.Commit()
+}
+
+// SQLiteSink is the RecordSink inserting rows into a SQLite table, the sink of
+// LoadAvro. Begin creates the table if it does not exist, with the loaded
+// columns only if they are not all the columns of the schema, and, unless the
+// Mode of its options is Upsert, truncates it if it does. Commit restores the
+// AUTOINCREMENT sequence and the triggers of the schema.
+//
+// SQLiteSink runs its statements with its Querier and does not open a
+// transaction of its own: Commit does not commit and Close does not roll back,
+// it only closes the INSERT statement prepared by Begin.
+// Use a *sql.Tx, or a *sql.DB for LoadAvroWithOptions to manage the transaction.
+type SQLiteSink struct {
+	q    Querier
+	opts LoadOptions
+
+	schema    *SqliteSchema
+	columns   []string
+	insertSql string
+	// insert is the prepared INSERT statement, nil if the Querier cannot prepare statements
+	insert *sql.Stmt
+	// created and truncated report what Begin did to the table
+	created   bool
+	truncated bool
+}
+
+// NewSQLiteSink returns a SQLiteSink running its statements with q.
+// The Mode and retries of opts apply to the table. The loaded columns are
+// given to Begin, so the Fields of opts are not used.
+func NewSQLiteSink(q Querier, opts LoadOptions) *SQLiteSink {
+	return &SQLiteSink{q: q, opts: opts}
+}
+
+// Begin creates or truncates the table of the schema and prepares the INSERT
+// statement of its loaded columns.
+func (s *SQLiteSink) Begin(schema *SqliteSchema, columns []string) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("no columns of table %s to load", schema.Table)
+	}
+	fields := make([]SchemaField, 0, len(columns))
+	for _, column := range columns {
+		f := schema.field(column)
+		if f == nil {
+			return fmt.Errorf("column %s is not in the schema of %s", column, schema.Table)
+		}
+		fields = append(fields, *f)
+	}
+	projected := false
+	for _, f := range schema.Fields {
+		if !f.Generated && !slices.Contains(columns, f.Name) {
+			projected = true
+		}
+	}
+
+	var err error
+	upsertClause := ""
+	if s.opts.Mode == Upsert {
+		upsertClause, err = upsertSQL(schema, fields)
+		if err != nil {
+			return err
+		}
+	}
+
+	// detect if the table exists
+	exists, err := tableExists(s.q, schema.Table)
+	if err != nil {
+		return err
+	}
+	// create a table in the database
+	if !exists {
+		ddl := schema.Sql
+		if !projected {
+			// a hand-edited schema may create a table its records cannot be inserted into
+			if err := schema.checkSql(); err != nil {
+				return err
+			}
+		} else {
+			loaded := *schema
+			loaded.Fields = fields
+			ddl, err = loaded.GenerateSQL()
+			if err != nil {
+				return err
+			}
+		}
+		err := s.opts.retryBusy(func() error {
+			_, err := s.q.Exec(ddl)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		s.created = true
+	} else if s.opts.Mode != Upsert {
+		err := s.opts.retryBusy(func() error {
+			_, err := s.q.Exec(fmt.Sprintf("DELETE FROM %s", quoteTableName(schema.Table)))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		s.truncated = true
+	}
+
+	// generate an insert statement
+	s.schema = schema
+	s.columns = columns
+	s.insertSql = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quoteTableName(schema.Table), quoteIdentifiers(columns), strings.Repeat("?, ", len(columns)-1)+"?") + upsertClause
+	// the statement is prepared once for all the rows
+	if p, ok := s.q.(preparer); ok {
+		s.insert, err = p.Prepare(s.insertSql)
+		if err != nil {
+			return fmt.Errorf("failed to prepare insert statement: [%w]", err)
+		}
+	}
+	return nil
+}
+
+// WriteRow inserts a row into the table.
+func (s *SQLiteSink) WriteRow(row map[string]any) error {
+	args := make([]any, 0, len(s.columns))
+	for _, column := range s.columns {
+		args = append(args, row[column])
+	}
+	return s.opts.retryBusy(func() error {
+		if s.insert != nil {
+			_, err := s.insert.Exec(args...)
+			return err
+		}
+		_, err := s.q.Exec(s.insertSql, args...)
+		return err
+	})
+}
+
+// Commit restores the AUTOINCREMENT sequence and creates the triggers of the schema.
+func (s *SQLiteSink) Commit() error {
+	if s.schema.Sequence != nil {
+		if err := restoreSequence(s.q, s.schema.Table, *s.schema.Sequence); err != nil {
+			return err
+		}
+	}
+	return createTriggers(s.q, s.schema)
+}
+
+// Close closes the prepared INSERT statement.
+func (s *SQLiteSink) Close() error {
+	if s.insert == nil {
+		return nil
+	}
+	err := s.insert.Close()
+	s.insert = nil
+	return err
+}
@@ -0,0 +1,186 @@
+package avrosqlite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// captureSink is a RecordSink keeping the rows written to it in memory and
+// recording the calls it receives.
+type captureSink struct {
+	calls   []string
+	rows    []map[string]any
+	failRow int
+}
+
+func (s *captureSink) Begin(schema *SqliteSchema, columns []string) error {
+	s.calls = append(s.calls, fmt.Sprintf("Begin %s %v", schema.Table, columns))
+	return nil
+}
+
+func (s *captureSink) WriteRow(row map[string]any) error {
+	if s.failRow > 0 && len(s.rows)+1 == s.failRow {
+		return errors.New("row rejected")
+	}
+	s.rows = append(s.rows, row)
+	return nil
+}
+
+func (s *captureSink) Commit() error {
+	s.calls = append(s.calls, "Commit")
+	return nil
+}
+
+func (s *captureSink) Close() error {
+	s.calls = append(s.calls, "Close")
+	return nil
+}
+
+func TestLoadAvroToSink(t *testing.T) {
+	schema := &SqliteSchema{
+		Table: "items",
+		Sql:   "CREATE TABLE items (id INTEGER, name TEXT, price REAL)",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger},
+			{Name: "name", Type: SqliteText, Nullable: true},
+			{Name: "price", Type: SqliteReal, Nullable: true},
+		},
+	}
+	data := encodeAvro(t, schema, []map[string]any{
+		{"id": int64(1), "name": "a", "price": 9.5},
+		{"id": int64(2), "name": nil, "price": nil},
+	})
+
+	sink := &captureSink{}
+	got, err := LoadAvroToSink(sink, schema, bytes.NewReader(data), LoadOptions{})
+	if err != nil {
+		t.Fatalf("LoadAvroToSink() error = %v", err)
+	}
+	if got != 2 {
+		t.Errorf("LoadAvroToSink() = %v, want %v", got, 2)
+	}
+	if want := []string{"Begin items [id name price]", "Commit", "Close"}; !reflect.DeepEqual(sink.calls, want) {
+		t.Errorf("LoadAvroToSink() calls = %v, want %v", sink.calls, want)
+	}
+	want := []map[string]any{
+		{"id": int64(1), "name": "a", "price": 9.5},
+		{"id": int64(2), "name": nil, "price": nil},
+	}
+	if !reflect.DeepEqual(sink.rows, want) {
+		t.Errorf("LoadAvroToSink() rows = %v, want %v", sink.rows, want)
+	}
+
+	failing := &captureSink{failRow: 2}
+	if _, err := LoadAvroToSink(failing, schema, bytes.NewReader(data), LoadOptions{}); err == nil {
+		t.Fatal("LoadAvroToSink() error = nil, want error")
+	}
+	if want := []string{"Begin items [id name price]", "Close"}; !reflect.DeepEqual(failing.calls, want) {
+		t.Errorf("LoadAvroToSink() calls after a failed row = %v, want %v", failing.calls, want)
+	}
+}
+
+func TestSQLiteSink(t *testing.T) {
+	db := newTestDB(t)
+	schema := &SqliteSchema{
+		Table: "items",
+		Sql:   "CREATE TABLE items (id INTEGER, name TEXT)",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger},
+			{Name: "name", Type: SqliteText, Nullable: true},
+		},
+	}
+	data := encodeAvro(t, schema, []map[string]any{{"id": int64(1), "name": "a"}})
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("db.Begin() error = %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := LoadAvroToSink(NewSQLiteSink(tx, LoadOptions{}), schema, bytes.NewReader(data), LoadOptions{}); err != nil {
+		t.Fatalf("LoadAvroToSink() error = %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("tx.Commit() error = %v", err)
+	}
+
+	got, err := LoadData(db, "items")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	if want := []map[string]any{{"id": int64(1), "name": "a"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadData() = %v, want %v", got, want)
+	}
+}
+
+func TestSQLiteSink_Fields(t *testing.T) {
+	db := newTestDB(t)
+	schema := &SqliteSchema{
+		Table: "items",
+		Sql:   "CREATE TABLE items (id INTEGER, name TEXT)",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger},
+			{Name: "name", Type: SqliteText, Nullable: true},
+		},
+	}
+	data := encodeAvro(t, schema, []map[string]any{{"id": int64(1), "name": "a"}})
+
+	// the sink inserts the columns selected by the options of the load, not its own
+	sink := NewSQLiteSink(db, LoadOptions{})
+	if _, err := LoadAvroToSink(sink, schema, bytes.NewReader(data), LoadOptions{Fields: []string{"id"}}); err != nil {
+		t.Fatalf("LoadAvroToSink() error = %v", err)
+	}
+	got, err := LoadData(db, "items")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	if want := []map[string]any{{"id": int64(1)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadData() = %v, want %v", got, want)
+	}
+}
+
+func TestSQLiteSink_PreparedInsert(t *testing.T) {
+	db := newTestDB(t)
+	schema := &SqliteSchema{
+		Table:  "items",
+		Sql:    "CREATE TABLE items (id INTEGER, name TEXT)",
+		Fields: []SchemaField{{Name: "id", Type: SqliteInteger}, {Name: "name", Type: SqliteText}},
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	defer tx.Rollback()
+
+	sink := NewSQLiteSink(tx, LoadOptions{})
+	if err := sink.Begin(schema, []string{"id", "name"}); err != nil {
+		t.Fatalf("SQLiteSink.Begin() error = %v", err)
+	}
+	if sink.insert == nil {
+		t.Fatal("SQLiteSink.Begin() did not prepare the insert statement")
+	}
+	for i, name := range []string{"a", "b"} {
+		if err := sink.WriteRow(map[string]any{"id": int64(i), "name": name}); err != nil {
+			t.Fatalf("SQLiteSink.WriteRow() error = %v", err)
+		}
+	}
+	if err := sink.Commit(); err != nil {
+		t.Fatalf("SQLiteSink.Commit() error = %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("SQLiteSink.Close() error = %v", err)
+	}
+	if sink.insert != nil {
+		t.Error("SQLiteSink.Close() did not close the insert statement")
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT count(*) FROM items").Scan(&count); err != nil {
+		t.Fatalf("count error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("count = %d, want 2", count)
+	}
+}
@@ -1,8 +1,16 @@
 package avrosqlite
 
 import (
+	"context"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"path"
 	"strconv"
 	"strings"
 
@@ -20,22 +28,82 @@ const (
 	SqliteText           SqliteType = "text"
 	SqliteBlob           SqliteType = "blob"
 	SqliteBoolean        SqliteType = "boolean"
+	SqliteDate           SqliteType = "date"
+	SqliteDatetime       SqliteType = "datetime"
+	SqliteTimestamp      SqliteType = "timestamp"
+	SqliteAny            SqliteType = "any"
 	SqliteIntegerDefault            = 0
 	SqliteRealDefault               = 0.0
 	SqliteTextDefault               = ""
 )
 
+// AvroNamespace is the namespace of the Avro records generated from SQLite tables.
+const AvroNamespace = "com.github.britt.avrosqlite"
+
+// ErrUnsupportedType is returned, wrapped with the column and its declared type,
+// when a column's type has no Avro equivalent.
+var ErrUnsupportedType = errors.New("unsupported sqlite type")
+
+// ErrTableNotFound is returned, wrapped with the table name, when a table does not exist.
+var ErrTableNotFound = errors.New("table not found")
+
+// ErrEmptySchema is returned, wrapped with the table name, when a schema has no
+// fields, since an Avro record without fields cannot hold any data.
+var ErrEmptySchema = errors.New("schema has no fields")
+
 // SqliteBlobDefault represents the default value for BLOB type.
 var SqliteBlobDefault = []byte{}
 
 // sqliteSpecialTables is a list of SQLite system tables to be ignored.
-var sqliteSpecialTables = []string{"sqlite_sequence"}
+// They are path.Match patterns, sqlite_stat* covers the tables written by ANALYZE.
+var sqliteSpecialTables = []string{"sqlite_sequence", "sqlite_stat*"}
 
 // SqliteSchema represents the schema of a SQLite table.
 type SqliteSchema struct {
 	Table  string        `json:"table"`
 	Fields []SchemaField `json:"fields"`
 	Sql    string        `json:"sql"`
+	// WithoutRowID is true for tables created WITHOUT ROWID.
+	WithoutRowID bool `json:"without_rowid,omitempty"`
+	// Checks holds the expressions of the table level CHECK constraints.
+	Checks []string `json:"checks,omitempty"`
+	// FieldAliases maps column names to the Avro field names they were sanitized to.
+	// It is populated by AvroToSqliteSchema and only contains renamed columns.
+	// The names of a conversion are returned by AvroFieldNames.
+	FieldAliases map[string]string `json:"field_aliases,omitempty"`
+	// RecordName is the name of the Avro record converted from the schema.
+	// If empty, the table name is used.
+	RecordName string `json:"record_name,omitempty"`
+	// ForeignKeys holds the foreign key constraints of the table. Parent tables
+	// must be loaded before the tables referencing them.
+	ForeignKeys []ForeignKey `json:"foreign_keys,omitempty"`
+	// Virtual is true for virtual tables, such as FTS5 tables. Only their visible
+	// columns are exported. Their Sql, the CREATE VIRTUAL TABLE statement, is what
+	// is preserved: loading the rows rebuilds the data of the shadow tables, which
+	// are never exported themselves.
+	Virtual bool `json:"virtual,omitempty"`
+	// Sequence is the AUTOINCREMENT high-water mark of the table, recorded in
+	// sqlite_sequence, when exported with ExportOptions.IncludeSequence. Loads
+	// restore it so that new rows do not reuse the ids of deleted rows.
+	Sequence *int64 `json:"sequence,omitempty"`
+	// Triggers holds the CREATE TRIGGER statements of the table. Loads create
+	// them once the rows are inserted, so that they do not fire for the rows.
+	Triggers []string `json:"triggers,omitempty"`
+}
+
+// ForeignKey is a foreign key constraint of a table.
+type ForeignKey struct {
+	// Columns are the columns of the table referencing the parent table.
+	Columns []string `json:"columns"`
+	// ParentTable is the name of the referenced table.
+	ParentTable string `json:"parent_table"`
+	// ParentColumns are the referenced columns, in the order of Columns.
+	// If empty, the primary key of the parent table is referenced.
+	ParentColumns []string `json:"parent_columns,omitempty"`
+	// OnDelete and OnUpdate are the actions taken when a referenced row is
+	// deleted or updated, such as CASCADE. They are empty for NO ACTION.
+	OnDelete string `json:"on_delete,omitempty"`
+	OnUpdate string `json:"on_update,omitempty"`
 }
 
 // SchemaField represents a single field in a SQLite table schema.
@@ -44,8 +112,35 @@ type SchemaField struct {
 	Type     SqliteType `json:"type"`
 	Nullable bool       `json:"nullable"`
 	Default  any        `json:"default,omitempty"`
+	// PrimaryKey is the 1-based position of the field in the primary key,
+	// or 0 if the field is not part of it.
+	PrimaryKey int `json:"primary_key,omitempty"`
+	// Check is the expression of the column level CHECK constraint, if any.
+	Check string `json:"check,omitempty"`
+	// Generated is true for generated columns (GENERATED ALWAYS AS). Their values
+	// are exported but they are skipped on load, since SQLite computes them.
+	Generated bool `json:"generated,omitempty"`
+	// Aliases are the previous Avro names of the field, so that records written
+	// before it was renamed can still be read. hamba/avro v1 has no support for
+	// field aliases: they are serialized by ToAvroJSON, read back by
+	// AvroJSONToSqliteSchema and resolved by LoadAvroWithReaderSchema.
+	Aliases []string `json:"aliases,omitempty"`
+	// JSON is true for TEXT columns holding Avro arrays or maps, which are
+	// stored as JSON text since SQLite has no collection types.
+	JSON bool `json:"json,omitempty"`
+	// Collation is the name of the collating sequence of the column, such as
+	// NOCASE, if it has one other than the default BINARY.
+	Collation string `json:"collation,omitempty"`
+	// Props are custom properties of the Avro field, such as {"pii": true} for
+	// downstream tooling. hamba/avro v1 keeps them on the field but does not
+	// serialize them, use ToAvroJSON and AvroJSONToSqliteSchema to carry them
+	// through a schema's JSON.
+	Props map[string]any `json:"props,omitempty"`
 }
 
+// avroFieldReserved are the attributes of Avro fields that cannot be used as custom properties.
+var avroFieldReserved = map[string]bool{"name": true, "type": true, "default": true, "doc": true, "order": true, "aliases": true}
+
 // AvroDefault returns the default value for a field in the Avro schema.
 func (s SchemaField) AvroDefault() interface{} {
 	if s.Nullable {
@@ -56,60 +151,325 @@ func (s SchemaField) AvroDefault() interface{} {
 	case SqliteNull:
 		return nil
 	case SqliteInteger:
-		if _, ok := s.Default.(int64); !ok {
-			return SqliteIntegerDefault
+		// integers are Avro longs, whose defaults must be int64
+		switch i := s.Default.(type) {
+		case int64:
+			return i
+		case int:
+			return int64(i)
+		default:
+			return int64(SqliteIntegerDefault)
 		}
 	case SqliteReal:
-		if _, ok := s.Default.(float64); !ok {
-			return SqliteRealDefault
+		if f, ok := toFloat64(s.Default); ok {
+			return f
 		}
+		return SqliteRealDefault
 	case SqliteText:
 		if _, ok := s.Default.(string); !ok {
 			return SqliteTextDefault
 		}
 	case SqliteBlob:
-		if _, ok := s.Default.([]byte); !ok {
-			return SqliteBlobDefault
+		// hamba/avro only accepts the string form of a bytes default, as in the Avro JSON encoding
+		if b, ok := s.Default.([]byte); ok {
+			return string(b)
 		}
+		return string(SqliteBlobDefault)
+	case SqliteDate, SqliteDatetime, SqliteTimestamp:
+		// defaults of dates and times, such as CURRENT_TIMESTAMP, are not carried over to Avro
+		return avro.NoDefault
+	case SqliteAny:
+		// a default of an ANY column would have to match the null branch of its union
+		return avro.NoDefault
 	case SqliteBoolean:
-		if b, ok := s.Default.(int); !ok {
-			return false
-		} else {
+		// defaults read from a table are bool, those of schemas built by hand may be integers
+		switch b := s.Default.(type) {
+		case bool:
+			return b
+		case int:
+			return b != 0
+		case int64:
 			return b != 0
+		default:
+			return false
 		}
 	}
 	return s.Default
 }
 
+// AvroOptions controls how a SqliteSchema is converted to an Avro schema.
+type AvroOptions struct {
+	// TypeMapper converts each field to its Avro schema.
+	// If nil, DefaultTypeMapper is used.
+	TypeMapper TypeMapper
+	// SanitizeNames replaces column names that are not valid Avro names with valid ones.
+	// The original column name is recorded as the doc of the sanitized field.
+	// If false, invalid column names are an error.
+	SanitizeNames bool
+	// Namespace is the namespace of the Avro record. If empty, AvroNamespace is used.
+	Namespace string
+	// Renames maps column names to the Avro field names they are converted to.
+	// As with sanitized names, the original column name is recorded as the doc of the field.
+	Renames map[string]string
+	// NullDefaults gives nullable fields a null default when their union starts
+	// with null, so that they can be added to a schema as it evolves. By default
+	// nullable fields have no default, see SchemaField.AvroDefault.
+	NullDefaults bool
+}
+
 // ToAvro converts the SQLite schema to an Avro schema.
 func (s *SqliteSchema) ToAvro() (avro.Schema, error) {
+	return s.ToAvroWithOptions(AvroOptions{})
+}
+
+// ToAvroWithOptions converts the SQLite schema to an Avro schema using the given options.
+func (s *SqliteSchema) ToAvroWithOptions(opts AvroOptions) (avro.Schema, error) {
+	if len(s.Fields) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrEmptySchema, s.Table)
+	}
+	mapper := opts.TypeMapper
+	if mapper == nil {
+		mapper = DefaultTypeMapper{}
+	}
+
+	names, err := s.AvroFieldNames(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	fields := []*avro.Field{}
 	for _, field := range s.Fields {
-		s, err := sqliteTypeToAvroSchema(field.Type, field.Nullable)
+		s, err := mapper.ToAvro(field)
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert sqlite type to avro schema: [%w]", err)
+			return nil, fmt.Errorf("failed to convert column %q to avro schema: [%w]", field.Name, err)
+		}
+
+		name := avroFieldName(names, field.Name)
+		for _, alias := range field.Aliases {
+			if !isValidAvroName(alias) {
+				return nil, fmt.Errorf("alias %q of column %q is not a valid avro field name", alias, field.Name)
+			}
 		}
 
-		avroField, err := avro.NewField(field.Name, s, field.AvroDefault())
+		def := field.AvroDefault()
+		if opts.NullDefaults && field.Nullable && isNullFirst(s) {
+			def = nil
+		}
+		avroField, err := avro.NewField(name, s, def)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create avro field: [%w]", err)
 		}
+		for key, value := range field.Props {
+			if avroFieldReserved[key] {
+				return nil, fmt.Errorf("property %q of column %q is a reserved avro field attribute", key, field.Name)
+			}
+			avroField.AddProp(key, value)
+		}
+		if name != field.Name {
+			avroField.AddDoc(field.Name)
+		}
 
 		fields = append(fields, avroField)
 	}
-	record, err := avro.NewRecordSchema(s.Table, "com.github.britt.avrosqlite", fields)
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = AvroNamespace
+	}
+	record, err := avro.NewRecordSchema(s.recordName(), namespace, fields)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create avro record schema: [%w]", err)
 	}
 	return record, nil
 }
 
+// AvroFieldNames returns the names of the Avro fields the columns are converted
+// to by ToAvroWithOptions with the given options, keyed by column name. Only the
+// columns renamed by opts.Renames or sanitized with opts.SanitizeNames are
+// included. An error is returned if a column has no valid Avro name or if two
+// columns map to the same field.
+func (s *SqliteSchema) AvroFieldNames(opts AvroOptions) (map[string]string, error) {
+	renamed := map[string]string{}
+	columns := map[string]string{}
+	for _, field := range s.Fields {
+		name := field.Name
+		if rename, ok := opts.Renames[field.Name]; ok {
+			if !isValidAvroName(rename) {
+				return nil, fmt.Errorf("column %q is renamed to %q, which is not a valid avro field name", field.Name, rename)
+			}
+			name = rename
+		} else if !isValidAvroName(name) {
+			if !opts.SanitizeNames {
+				return nil, fmt.Errorf("column %q is not a valid avro field name", field.Name)
+			}
+			name = sanitizeAvroName(name)
+		}
+		if other, ok := columns[name]; ok {
+			return nil, fmt.Errorf("columns %q and %q both map to avro field %q", other, field.Name, name)
+		}
+		columns[name] = field.Name
+		if name != field.Name {
+			renamed[field.Name] = name
+		}
+	}
+	return renamed, nil
+}
+
+// loadFieldNames returns the names of the Avro fields the records loaded into
+// the table of the schema hold its columns in. Avro data can only have been
+// written with valid names, so columns are always matched to their sanitized
+// field names.
+func (s *SqliteSchema) loadFieldNames() (map[string]string, error) {
+	return s.AvroFieldNames(AvroOptions{SanitizeNames: true})
+}
+
+// ToAvroJSON converts the SQLite schema to the JSON of an Avro schema using the
+// given options. Unlike the JSON of the schema returned by ToAvroWithOptions, it
+// includes the aliases and the custom properties of the fields.
+func (s *SqliteSchema) ToAvroJSON(opts AvroOptions) ([]byte, error) {
+	avroSchema, err := s.ToAvroWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(avroSchema)
+	if err != nil {
+		return nil, err
+	}
+	var record map[string]any
+	if err := json.Unmarshal(b, &record); err != nil {
+		return nil, err
+	}
+	fields, _ := record["fields"].([]any)
+	for i, field := range s.Fields {
+		if (len(field.Props) == 0 && len(field.Aliases) == 0) || i >= len(fields) {
+			continue
+		}
+		avroField := fields[i].(map[string]any)
+		for key, value := range field.Props {
+			avroField[key] = value
+		}
+		if len(field.Aliases) > 0 {
+			avroField["aliases"] = field.Aliases
+		}
+	}
+	return json.Marshal(record)
+}
+
+// isNullFirst reports whether schema is a union whose first branch is null,
+// the only unions a null default is valid for.
+func isNullFirst(schema avro.Schema) bool {
+	union, ok := schema.(*avro.UnionSchema)
+	return ok && union.Types()[0].Type() == avro.Null
+}
+
+// recordName returns the name of the Avro record converted from the schema.
+func (s *SqliteSchema) recordName() string {
+	if s.RecordName != "" {
+		return s.RecordName
+	}
+	return s.Table
+}
+
+// avroFieldName returns the name of the Avro field holding the given column,
+// names being the renamed columns returned by AvroFieldNames.
+func avroFieldName(names map[string]string, column string) string {
+	if name, ok := names[column]; ok {
+		return name
+	}
+	return column
+}
+
+// avroAliases maps the Avro names of the fields that have Aliases to them,
+// names being the renamed columns returned by AvroFieldNames.
+func (s *SqliteSchema) avroAliases(names map[string]string) map[string][]string {
+	aliases := map[string][]string{}
+	for _, f := range s.Fields {
+		if len(f.Aliases) > 0 {
+			aliases[avroFieldName(names, f.Name)] = f.Aliases
+		}
+	}
+	return aliases
+}
+
+// toAvroRecord returns row keyed by Avro field names rather than column names,
+// names being the renamed columns returned by AvroFieldNames. The row is
+// returned as is when no column was renamed.
+func toAvroRecord(names map[string]string, row map[string]any) map[string]any {
+	if len(names) == 0 {
+		return row
+	}
+	record := make(map[string]any, len(row))
+	for column, v := range row {
+		record[avroFieldName(names, column)] = v
+	}
+	return record
+}
+
+// Querier runs SQL statements. It is satisfied by *sql.DB and *sql.Tx.
+type Querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// connQuerier adapts a *sql.Conn to the Querier interface so that a
+// sequence of statements runs on a single connection.
+type connQuerier struct {
+	conn *sql.Conn
+}
+
+func (c *connQuerier) Exec(query string, args ...any) (sql.Result, error) {
+	return c.conn.ExecContext(context.Background(), query, args...)
+}
+
+func (c *connQuerier) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.conn.QueryContext(context.Background(), query, args...)
+}
+
+func (c *connQuerier) Prepare(query string) (*sql.Stmt, error) {
+	return c.conn.PrepareContext(context.Background(), query)
+}
+
+// preparer is implemented by the Queriers that can prepare statements, such as
+// *sql.DB, *sql.Tx and the connections pinned by loadRecords.
+type preparer interface {
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// Fingerprint returns the SHA256 fingerprint of the Avro form of the schema.
+// Identical schemas always produce the same fingerprint, so it can be used to
+// key schemas in a cache or registry.
+func (s *SqliteSchema) Fingerprint() ([32]byte, error) {
+	avroSchema, err := s.ToAvro()
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return avroSchema.Fingerprint(), nil
+}
+
+// SubjectName returns the full name of the Avro record for the schema,
+// the namespace followed by the record name, for use as a registry subject.
+func (s *SqliteSchema) SubjectName() string {
+	return AvroNamespace + "." + s.recordName()
+}
+
 // ListTables returns a list of user-defined tables in the SQLite database.
-// It excludes system tables listed in sqliteSpecialTables.
+// It excludes system tables listed in sqliteSpecialTables and the shadow tables
+// of virtual tables. Temporary tables (CREATE TEMP TABLE) are listed after the
+// other tables, qualified with their schema as temp.name. They are only visible
+// to the connection that created them, so a *sql.DB holding temporary tables
+// should be limited to a single connection with SetMaxOpenConns(1).
 func ListTables(db *sql.DB) ([]string, error) {
+	return listTables(db)
+}
+
+// listTables returns the user-defined tables of the database using q. See ListTables.
+func listTables(q Querier) ([]string, error) {
 	tables := []string{}
-	// Read the list of tables from sqlite
-	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table';")
+	// Read the list of tables from sqlite, leaving out the shadow tables
+	// holding the data of virtual tables
+	rows, err := q.Query(`SELECT name FROM sqlite_master WHERE type='table'
+		AND name NOT IN (SELECT name FROM pragma_table_list WHERE schema = 'main' AND type = 'shadow')
+		UNION ALL
+		SELECT 'temp.' || name FROM sqlite_temp_master WHERE type='table';`)
 	if err != nil {
 		return tables, err
 	}
@@ -122,12 +482,9 @@ func ListTables(db *sql.DB) ([]string, error) {
 			return tables, err
 		}
 
-		isSpecial := false
-		for _, specialTable := range sqliteSpecialTables {
-			if tableName == specialTable {
-				isSpecial = true
-				break
-			}
+		isSpecial, err := matchesTable(tableName, sqliteSpecialTables)
+		if err != nil {
+			return tables, err
 		}
 		if isSpecial {
 			continue
@@ -135,12 +492,108 @@ func ListTables(db *sql.DB) ([]string, error) {
 
 		tables = append(tables, tableName)
 	}
-	return tables, nil
+	return tables, rows.Err()
+}
+
+// tempSchemaPrefix qualifies the names of temporary tables.
+const tempSchemaPrefix = "temp."
+
+// splitTableName returns the schema, main or temp, and the unqualified name of
+// a table. Only the temp. prefix is recognized, so that other table names
+// containing dots are not mistaken for qualified names.
+func splitTableName(table string) (string, string) {
+	if len(table) > len(tempSchemaPrefix) && strings.EqualFold(table[:len(tempSchemaPrefix)], tempSchemaPrefix) {
+		return "temp", table[len(tempSchemaPrefix):]
+	}
+	return "main", table
+}
+
+// qualifiedName returns name qualified with the schema of table, so that an
+// object of a temporary table, such as a trigger, is named temp.name.
+func qualifiedName(table, name string) string {
+	if schema, _ := splitTableName(table); schema == "temp" {
+		return tempSchemaPrefix + name
+	}
+	return name
+}
+
+// quoteTableName quotes a table name, qualified with its schema if it is a
+// temporary table, for use in a SQL statement.
+func quoteTableName(table string) string {
+	schema, name := splitTableName(table)
+	if schema == "temp" {
+		return quoteIdentifier(schema) + "." + quoteIdentifier(name)
+	}
+	return quoteIdentifier(name)
+}
+
+// masterTable returns the table holding the definitions of the tables of a schema.
+func masterTable(schema string) string {
+	if schema == "temp" {
+		return "sqlite_temp_master"
+	}
+	return "sqlite_master"
+}
+
+// matchesTable reports whether a table name matches one of the path.Match patterns.
+// Like SQLite identifiers, names are matched regardless of their case.
+func matchesTable(table string, patterns []string) (bool, error) {
+	for _, pattern := range patterns {
+		if strings.EqualFold(pattern, table) {
+			return true, nil
+		}
+		matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(table))
+		if err != nil {
+			return false, fmt.Errorf("invalid table pattern %q: [%w]", pattern, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TableRowCounts returns the number of rows in every user-defined table in the SQLite database.
+// Like ListTables, it excludes system tables listed in sqliteSpecialTables.
+func TableRowCounts(db *sql.DB) (map[string]int64, error) {
+	counts := map[string]int64{}
+	tables, err := ListTables(db)
+	if err != nil {
+		return counts, err
+	}
+
+	for _, table := range tables {
+		var count int64
+		err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", quoteTableName(table))).Scan(&count)
+		if err != nil {
+			return counts, err
+		}
+		counts[table] = count
+	}
+	return counts, nil
+}
+
+// databaseFile returns the file of the main database, or an empty string for
+// an in-memory or temporary database.
+func databaseFile(q Querier) (string, error) {
+	rows, err := q.Query("SELECT file FROM pragma_database_list WHERE name = 'main'")
+	if err != nil {
+		return "", fmt.Errorf("failed to read the database file: [%w]", err)
+	}
+	defer rows.Close()
+	var file string
+	if rows.Next() {
+		if err := rows.Scan(&file); err != nil {
+			return "", fmt.Errorf("failed to read the database file: [%w]", err)
+		}
+	}
+	return file, rows.Err()
 }
 
 // tableExists checks if a table with the given name exists in the SQLite database.
-func tableExists(db *sql.DB, table string) (bool, error) {
-	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table' AND name=?", table)
+func tableExists(q Querier, table string) (bool, error) {
+	schema, name := splitTableName(table)
+	rows, err := q.Query(fmt.Sprintf("SELECT name FROM %s WHERE type='table' AND name=?", masterTable(schema)), name)
 	if err != nil {
 		return false, err
 	}
@@ -154,31 +607,44 @@ SELECT
     "name" AS COLUMN_NAME,
     "type" AS DATA_TYPE,
     CASE when "notnull" = 0 THEN 'YES' ELSE 'NO' END AS IS_NULLABLE,
-    "dflt_value" AS COLUMN_DEFAULT
+    "dflt_value" AS COLUMN_DEFAULT,
+    "pk" AS PRIMARY_KEY,
+    "hidden" AS HIDDEN
 FROM 
-    pragma_table_info("%s")
+    pragma_table_xinfo("%s", '%s')
 `
 
+// Values of the hidden column of pragma_table_xinfo.
+// https://www.sqlite.org/pragma.html#pragma_table_xinfo
+const (
+	columnHidden           = 1
+	columnGeneratedVirtual = 2
+	columnGeneratedStored  = 3
+)
+
 const sqliteTableCreationSqlQuery = `
 SELECT sql
-FROM sqlite_master
+FROM %s
 WHERE type = 'table' AND name = '%s'
 `
 
 // ReadSchema retrieves the schema of a specified SQLite table.
 // It returns a SqliteSchema struct containing table name, fields, and creation SQL.
+// If the table does not exist the error wraps ErrTableNotFound.
+// Temporary tables are read with their qualified name, temp.name, see ListTables.
+// Their Avro record is named after their unqualified name.
 func ReadSchema(db *sql.DB, tableName string) (*SqliteSchema, error) {
-	// Read the schema of the table
-	rows, err := db.Query(
-		fmt.Sprintf(sqliteTableInfoQuery, tableName, tableName),
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	return readSchema(db, tableName, discardLogger)
+}
 
+// readSchema retrieves the schema of a table using q, logging the defaults that
+// cannot be converted to the type of their column. See ReadSchema.
+func readSchema(q Querier, tableName string, logger *slog.Logger) (*SqliteSchema, error) {
+	// Read the creation SQL first and release its connection before reading the
+	// columns, otherwise a second pooled connection may be used for the columns.
 	var createSql string
-	sqlRows, err := db.Query(fmt.Sprintf(sqliteTableCreationSqlQuery, tableName))
+	schemaName, name := splitTableName(tableName)
+	sqlRows, err := q.Query(fmt.Sprintf(sqliteTableCreationSqlQuery, masterTable(schemaName), name))
 	if err != nil {
 		return nil, err
 	}
@@ -189,11 +655,37 @@ func ReadSchema(db *sql.DB, tableName string) (*SqliteSchema, error) {
 			return nil, err
 		}
 	}
+	sqlRows.Close()
 
+	// Read the schema of the table
+	rows, err := q.Query(
+		fmt.Sprintf(sqliteTableInfoQuery, tableName, name, schemaName),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	virtual := virtualTablePattern.MatchString(createSql)
+	tableChecks, columnChecks := []string{}, map[string]string{}
+	collations := map[string]string{}
+	// the arguments of a virtual table are not column definitions
+	if !virtual {
+		tableChecks, columnChecks = parseChecks(createSql)
+		collations = parseCollations(createSql)
+	}
 	schema := &SqliteSchema{
-		Table:  tableName,
-		Fields: []SchemaField{},
-		Sql:    createSql,
+		Table:        tableName,
+		Fields:       []SchemaField{},
+		Sql:          createSql,
+		WithoutRowID: withoutRowIDPattern.MatchString(createSql),
+		Virtual:      virtual,
+	}
+	if schemaName == "temp" {
+		schema.RecordName = name
+	}
+	if len(tableChecks) > 0 {
+		schema.Checks = tableChecks
 	}
 
 	var (
@@ -204,33 +696,196 @@ func ReadSchema(db *sql.DB, tableName string) (*SqliteSchema, error) {
 		isNullable         bool
 		defaultValue       sql.NullString
 		defaultSchemaValue any
+		primaryKey         int
+		hidden             int
 	)
 	for rows.Next() {
-		err = rows.Scan(&tableSchema, &columnName, &dataType, &isNullableStr, &defaultValue)
+		err = rows.Scan(&tableSchema, &columnName, &dataType, &isNullableStr, &defaultValue, &primaryKey, &hidden)
 		if err != nil {
 			return nil, err
 		}
-		dataType = strings.ToLower(dataType)
+		// hidden columns of virtual tables are not returned by SELECT *
+		if hidden == columnHidden {
+			continue
+		}
+		dataType = string(NormalizeSqliteType(dataType))
 		isNullableStr = strings.ToLower(isNullableStr)
 		isNullable = isNullableStr == "yes"
+		// primary key columns of a WITHOUT ROWID table are implicitly NOT NULL
+		if schema.WithoutRowID && primaryKey > 0 {
+			isNullable = false
+		}
 		if defaultValue.Valid {
-			// TODO: handle this error?
-			defaultSchemaValue, _ = toDefaultValueType(dataType, defaultValue.String)
+			defaultSchemaValue, err = toDefaultValueType(dataType, defaultValue.String)
+			if err != nil {
+				// the default falls back to the zero value of the type
+				logger.Warn("invalid column default replaced", "table", tableName, "column", columnName,
+					"default", defaultValue.String, "value", defaultSchemaValue, "error", err)
+			}
 		} else {
 			defaultSchemaValue = avro.NoDefault
 		}
 
 		schema.Fields = append(schema.Fields, SchemaField{
-			Name:     columnName,
-			Type:     SqliteType(dataType),
-			Nullable: isNullable,
-			Default:  defaultSchemaValue,
+			Name:       columnName,
+			Type:       SqliteType(dataType),
+			Nullable:   isNullable,
+			Default:    defaultSchemaValue,
+			PrimaryKey: primaryKey,
+			Check:      columnChecks[columnName],
+			Collation:  collations[columnName],
+			Generated:  hidden == columnGeneratedVirtual || hidden == columnGeneratedStored,
 		})
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// every table has at least one column
+	if len(schema.Fields) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrTableNotFound, tableName)
+	}
+
+	schema.ForeignKeys, err = readForeignKeys(q, tableName)
+	if err != nil {
+		return nil, err
+	}
+	schema.Triggers, err = readTriggers(q, tableName)
+	if err != nil {
+		return nil, err
+	}
 
 	return schema, nil
 }
 
+const sqliteForeignKeyQuery = `
+SELECT "id", "table", "from", "to", "on_update", "on_delete"
+FROM pragma_foreign_key_list(?, ?)
+ORDER BY "id", "seq"
+`
+
+// readForeignKeys returns the foreign keys of a table, or nil if it has none.
+func readForeignKeys(q Querier, table string) ([]ForeignKey, error) {
+	schema, name := splitTableName(table)
+	rows, err := q.Query(sqliteForeignKeyQuery, name, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		foreignKeys []ForeignKey
+		lastID      = -1
+	)
+	for rows.Next() {
+		var (
+			id                 int
+			parent, from       string
+			to                 sql.NullString
+			onUpdate, onDelete string
+		)
+		if err := rows.Scan(&id, &parent, &from, &to, &onUpdate, &onDelete); err != nil {
+			return nil, err
+		}
+		// a foreign key over several columns has a row per column
+		if id != lastID {
+			foreignKeys = append(foreignKeys, ForeignKey{
+				Columns:     []string{},
+				ParentTable: parent,
+				OnDelete:    foreignKeyAction(onDelete),
+				OnUpdate:    foreignKeyAction(onUpdate),
+			})
+			lastID = id
+		}
+		fk := &foreignKeys[len(foreignKeys)-1]
+		fk.Columns = append(fk.Columns, from)
+		// to is NULL when the primary key of the parent is referenced implicitly
+		if to.Valid {
+			fk.ParentColumns = append(fk.ParentColumns, to.String)
+		}
+	}
+	return foreignKeys, rows.Err()
+}
+
+// foreignKeyAction returns the action of a foreign key, or "" for the default NO ACTION.
+func foreignKeyAction(action string) string {
+	if strings.EqualFold(action, "NO ACTION") {
+		return ""
+	}
+	return action
+}
+
+// rowIDColumn is the name of the column holding the rowid of each row
+// when ExportOptions.IncludeRowID is set.
+const rowIDColumn = "rowid"
+
+// addRowID adds a rowid column to the front of the schema. It reports false and
+// leaves the schema unchanged if the table is WITHOUT ROWID or already has a
+// column named rowid, which hides the implicit one.
+func (s *SqliteSchema) addRowID() bool {
+	if s.WithoutRowID {
+		return false
+	}
+	for _, f := range s.Fields {
+		if strings.EqualFold(f.Name, rowIDColumn) {
+			return false
+		}
+	}
+
+	// the default is never used, every row has a rowid, but Avro requires a long default
+	rowID := SchemaField{Name: rowIDColumn, Type: SqliteInteger, Default: int64(0)}
+	s.Fields = append([]SchemaField{rowID}, s.Fields...)
+	return true
+}
+
+// booleanTypes are the declared types of boolean columns.
+// SQLite stores them as integers, see https://www.sqlite.org/datatype3.html#boolean_datatype
+var booleanTypes = map[string]bool{"bool": true, "boolean": true}
+
+// NormalizeSqliteType returns the SqliteType of a column declared with the given type.
+//
+// The types with a meaning of their own in this package are recognized by name,
+// regardless of case: INTEGER, REAL, TEXT, BLOB, ANY, DATE, DATETIME, TIMESTAMP,
+// and BOOL or BOOLEAN. Any other declared type is mapped to the type of its
+// affinity, following the rules of SQLite in order:
+//   - a type containing "INT" has INTEGER affinity, such as BIGINT,
+//   - a type containing "CHAR", "CLOB" or "TEXT" has TEXT affinity, such as VARCHAR(255),
+//   - a type containing "BLOB" has BLOB affinity,
+//   - a type containing "REAL", "FLOA" or "DOUB" has REAL affinity, such as DOUBLE PRECISION,
+//   - any other type has NUMERIC affinity, such as DECIMAL(10,5).
+//
+// NUMERIC columns can hold integers, reals and, when they cannot be converted,
+// text and blobs, so they are mapped to SqliteAny. So are untyped columns, such
+// as the expression columns of CREATE TABLE ... AS SELECT and the columns of
+// most virtual tables: although they have BLOB affinity, they store every value
+// as it is given.
+// https://www.sqlite.org/datatype3.html#determination_of_column_affinity
+func NormalizeSqliteType(declared string) SqliteType {
+	t := strings.ToLower(strings.TrimSpace(declared))
+	if t == "" {
+		return SqliteAny
+	}
+	if booleanTypes[t] {
+		return SqliteBoolean
+	}
+	switch SqliteType(t) {
+	case SqliteNull, SqliteInteger, SqliteReal, SqliteText, SqliteBlob,
+		SqliteDate, SqliteDatetime, SqliteTimestamp, SqliteAny:
+		return SqliteType(t)
+	}
+
+	switch {
+	case strings.Contains(t, "int"):
+		return SqliteInteger
+	case strings.Contains(t, "char"), strings.Contains(t, "clob"), strings.Contains(t, "text"):
+		return SqliteText
+	case strings.Contains(t, "blob"):
+		return SqliteBlob
+	case strings.Contains(t, "real"), strings.Contains(t, "floa"), strings.Contains(t, "doub"):
+		return SqliteReal
+	}
+	return SqliteAny
+}
+
 // toDefaultValueType converts a string default value to the appropriate Go type
 // based on the SQLite data type.
 func toDefaultValueType(dataType string, s string) (any, error) {
@@ -240,55 +895,286 @@ func toDefaultValueType(dataType string, s string) (any, error) {
 	case SqliteInteger:
 		return strconv.ParseInt(s, 10, 64)
 	case SqliteReal:
-		return strconv.ParseFloat(s, 64)
+		// a quoted default, DEFAULT '1.5', keeps its quotes in dflt_value
+		return strconv.ParseFloat(strings.Trim(s, "'"), 64)
 	case SqliteText:
 		return s, nil
 	case SqliteBlob:
+		// BLOB literals are hex encoded, X'00FF'
+		if len(s) >= 3 && (s[0] == 'X' || s[0] == 'x') && s[1] == '\'' && s[len(s)-1] == '\'' {
+			if b, err := hex.DecodeString(s[2 : len(s)-1]); err == nil {
+				return b, nil
+			}
+		}
 		return []byte(s), nil
+	case SqliteDate, SqliteDatetime, SqliteTimestamp:
+		return s, nil
+	case SqliteAny:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, nil
+		}
+		return s, nil
 	case SqliteBoolean:
+		// TRUE and FALSE are aliases for 1 and 0 since SQLite 3.23
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
 		i, err := strconv.ParseInt(s, 10, 32)
 		if err != nil {
 			return false, err
 		}
 		return i != 0, nil
 	}
-	return nil, fmt.Errorf("unknown sqlite type: %s", dataType)
+	return nil, fmt.Errorf("%w: %q", ErrUnsupportedType, dataType)
+}
+
+// toFloat64 converts a numeric default, or a string holding one, to a float64.
+// Integer-valued defaults of REAL columns may be held as int64 or int, for
+// example in schemas built by hand.
+func toFloat64(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.Trim(n, "'"), 64)
+		return f, err == nil
+	}
+	return 0, false
 }
 
 // LoadData retrieves all data from the specified SQLite table.
 // It returns a slice of maps, where each map represents a row in the table.
+// Columns are selected explicitly in the order of the table's schema.
 func LoadData(db *sql.DB, table string) ([]map[string]any, error) {
+	schema, err := ReadSchema(db, table)
+	if err != nil {
+		return []map[string]any{}, err
+	}
+	return loadData(db, table, schema.columnNames(), false, nil)
+}
+
+// DataOptions controls how LoadDataWithOptions reads the rows of a table.
+type DataOptions struct {
+	// NormalizeIntegers converts the values of INTEGER columns to int64, whatever
+	// the Go type they were scanned as, including numeric strings and BLOBs such
+	// as x'3132', which SQLite keeps as they are in a column of INTEGER affinity.
+	// Values that are not integers are left unchanged.
+	NormalizeIntegers bool
+	// BigIntegers returns the integers of INTEGER columns that do not fit in an
+	// int64 as strings of their decimal digits, whatever their storage class.
+	// See DetectBigIntegers.
+	BigIntegers bool
+}
+
+// LoadDataWithOptions retrieves all data from the specified SQLite table using
+// the given options. See LoadData.
+func LoadDataWithOptions(db *sql.DB, table string, opts DataOptions) ([]map[string]any, error) {
+	schema, err := ReadSchema(db, table)
+	if err != nil {
+		return []map[string]any{}, err
+	}
+	data, err := loadData(db, table, schema.columnNames(), false, nil)
+	if err != nil || !opts.NormalizeIntegers && !opts.BigIntegers {
+		return data, err
+	}
+	for _, row := range data {
+		for _, f := range schema.Fields {
+			if f.Type != SqliteInteger {
+				continue
+			}
+			if s, ok := bigIntegerString(row[f.Name]); opts.BigIntegers && ok {
+				row[f.Name] = s
+			} else if opts.NormalizeIntegers {
+				row[f.Name] = toInt64(row[f.Name])
+			}
+		}
+	}
+	return data, nil
+}
+
+// toInt64 converts an integer of any width, or a string holding one, to an
+// int64. Other values, and integers out of the range of int64, are returned unchanged.
+func toInt64(v any) any {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int8:
+		return int64(n)
+	case int16:
+		return int64(n)
+	case int32:
+		return int64(n)
+	case uint8:
+		return int64(n)
+	case uint16:
+		return int64(n)
+	case uint32:
+		return int64(n)
+	case uint:
+		if uint64(n) <= math.MaxInt64 {
+			return int64(n)
+		}
+	case uint64:
+		if n <= math.MaxInt64 {
+			return int64(n)
+		}
+	case string:
+		if i, err := strconv.ParseInt(strings.TrimSpace(n), 10, 64); err == nil {
+			return i
+		}
+	case []byte:
+		if i, err := strconv.ParseInt(strings.TrimSpace(string(n)), 10, 64); err == nil {
+			return i
+		}
+	}
+	return v
+}
+
+// SampleData retrieves at most limit rows from the specified SQLite table or view,
+// in no particular order. It is a cheap way to preview a large table.
+func SampleData(db *sql.DB, table string, limit int) ([]map[string]any, error) {
 	data := []map[string]any{}
-	// Read the data from each table
-	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s", table))
+	if limit < 0 {
+		return data, fmt.Errorf("limit must not be negative, got %d", limit)
+	}
+
+	rows, err := db.Query(selectQuery(table, nil, false, nil)+" LIMIT ?", limit)
 	if err != nil {
 		return data, err
 	}
 	defer rows.Close()
 
-	for rows.Next() {
-		columns, err := rows.Columns()
+	reader, err := newRowReader(rows)
+	if err != nil {
+		return data, err
+	}
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			return data, nil
+		}
 		if err != nil {
 			return data, err
 		}
+		data = append(data, row)
+	}
+}
+
+// StreamData calls fn with each row of the specified SQLite table, in the column order
+// of the table's schema, without loading the whole table into memory. It stops at the
+// first error returned by fn and returns it.
+//
+// The map passed to fn is reused for every row: it is only valid until fn returns, and
+// callers must copy it to retain a row.
+func StreamData(db *sql.DB, table string, fn func(row map[string]any) error) error {
+	schema, err := ReadSchema(db, table)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query(selectQuery(table, schema.columnNames(), false, nil))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range columns {
-			valuePtrs[i] = &values[i]
+	reader, err := newRowReader(rows)
+	if err != nil {
+		return err
+	}
+	row := make(map[string]any, len(reader.columns))
+	for {
+		clear(row)
+		err := reader.nextInto(row)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+// loadData retrieves all data from the specified SQLite table. It selects the
+// given columns, or every column if there are none, optionally preceded by the
+// rowid of each row in a column named rowid, and sorts the rows by the orderBy
+// columns if there are any. See LoadData.
+func loadData(q Querier, table string, columns []string, includeRowID bool, orderBy []string) ([]map[string]any, error) {
+	return loadRows(q, selectQuery(table, columns, includeRowID, orderBy))
+}
 
-		err = rows.Scan(valuePtrs...)
+// loadRows returns the rows returned by a query.
+func loadRows(q Querier, query string, args ...any) ([]map[string]any, error) {
+	data := []map[string]any{}
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return data, err
+	}
+	defer rows.Close()
+
+	reader, err := newRowReader(rows)
+	if err != nil {
+		return data, err
+	}
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			return data, nil
+		}
 		if err != nil {
 			return data, err
 		}
+		data = append(data, row)
+	}
+}
 
-		entry := map[string]any{}
-		for i, col := range columns {
-			val := values[i]
-			entry[col] = val
+// selectQuery returns the SELECT statement reading the rows of a table for loadData.
+func selectQuery(table string, columns []string, includeRowID bool, orderBy []string) string {
+	selected := "*"
+	if len(columns) > 0 {
+		quoted := make([]string, 0, len(columns))
+		for _, col := range columns {
+			quoted = append(quoted, quoteIdentifier(col))
 		}
-		data = append(data, entry)
+		selected = strings.Join(quoted, ", ")
 	}
-	return data, nil
+	if includeRowID {
+		// the rowid is aliased, otherwise it is named after an INTEGER PRIMARY KEY column
+		selected = fmt.Sprintf("rowid AS %s, %s", rowIDColumn, selected)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", selected, quoteTableName(table))
+	if len(orderBy) > 0 {
+		quoted := make([]string, 0, len(orderBy))
+		for _, col := range orderBy {
+			quoted = append(quoted, quoteIdentifier(col))
+		}
+		query += " ORDER BY " + strings.Join(quoted, ", ")
+	}
+	return query
+}
+
+// columnNames returns the names of the fields of the schema in order.
+func (s *SqliteSchema) columnNames() []string {
+	names := make([]string, 0, len(s.Fields))
+	for _, f := range s.Fields {
+		names = append(names, f.Name)
+	}
+	return names
 }
@@ -2,7 +2,14 @@ package avrosqlite
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/hamba/avro"
@@ -55,6 +62,18 @@ func init() {
 	}
 }
 
+// newTestDB opens an empty database backed by a file in a temporary directory.
+// A file is used rather than :memory: so every pooled connection sees the same data.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
 func TestSchemaField_AvroDefault(t *testing.T) {
 	type fields struct {
 		Name               string
@@ -80,6 +99,15 @@ func TestSchemaField_AvroDefault(t *testing.T) {
 			},
 			want: avro.NoDefault,
 		},
+		{
+			name: "not null integer with an int default",
+			fields: fields{
+				Name:    "id",
+				Type:    SqliteInteger,
+				Default: 7,
+			},
+			want: int64(7),
+		},
 		{
 			name: "integer",
 			fields: fields{
@@ -118,7 +146,7 @@ func TestSchemaField_AvroDefault(t *testing.T) {
 				Nullable: false,
 				Default:  []byte("Edalyn Clawthorne"),
 			},
-			want: []byte("Edalyn Clawthorne"),
+			want: "Edalyn Clawthorne",
 		},
 		{
 			name: "boolean",
@@ -130,6 +158,56 @@ func TestSchemaField_AvroDefault(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "boolean bool default",
+			fields: fields{
+				Name:     "id",
+				Type:     SqliteBoolean,
+				Nullable: false,
+				Default:  true,
+			},
+			want: true,
+		},
+		{
+			name: "boolean false bool default",
+			fields: fields{
+				Name:     "id",
+				Type:     SqliteBoolean,
+				Nullable: false,
+				Default:  false,
+			},
+			want: false,
+		},
+		{
+			name: "boolean int zero default",
+			fields: fields{
+				Name:     "id",
+				Type:     SqliteBoolean,
+				Nullable: false,
+				Default:  0,
+			},
+			want: false,
+		},
+		{
+			name: "boolean int64 default",
+			fields: fields{
+				Name:     "id",
+				Type:     SqliteBoolean,
+				Nullable: false,
+				Default:  int64(1),
+			},
+			want: true,
+		},
+		{
+			name: "boolean int64 zero default",
+			fields: fields{
+				Name:     "id",
+				Type:     SqliteBoolean,
+				Nullable: false,
+				Default:  int64(0),
+			},
+			want: false,
+		},
 		{
 			name: "integer bad default",
 			fields: fields{
@@ -138,7 +216,7 @@ func TestSchemaField_AvroDefault(t *testing.T) {
 				Nullable: false,
 				Default:  "meatballs",
 			},
-			want: SqliteIntegerDefault,
+			want: int64(SqliteIntegerDefault),
 		},
 		{
 			name: "real bad default",
@@ -168,7 +246,7 @@ func TestSchemaField_AvroDefault(t *testing.T) {
 				Nullable: false,
 				Default:  []int{1, 2, 3},
 			},
-			want: SqliteBlobDefault,
+			want: "",
 		},
 		{
 			name: "boolean bad default",
@@ -248,6 +326,359 @@ func TestSqliteSchema_ToAvro(t *testing.T) {
 	}
 }
 
+// intMapper maps sqlite integers to Avro ints instead of longs.
+type intMapper struct{}
+
+func (intMapper) ToAvro(field SchemaField) (avro.Schema, error) {
+	if field.Type != SqliteInteger {
+		return DefaultTypeMapper{}.ToAvro(field)
+	}
+	s := avro.NewPrimitiveSchema(avro.Int, nil)
+	if field.Nullable {
+		return avro.NewUnionSchema([]avro.Schema{nullSchema, s})
+	}
+	return s, nil
+}
+
+func TestSqliteSchema_ToAvroWithOptions(t *testing.T) {
+	schema := &SqliteSchema{
+		Table: "foo",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger, Nullable: true},
+			{Name: "name", Type: SqliteText, Nullable: true},
+		},
+	}
+	tests := []struct {
+		name string
+		opts AvroOptions
+		want string
+	}{
+		{
+			name: "default mapper",
+			opts: AvroOptions{},
+			want: `{"name":"com.github.britt.avrosqlite.foo","type":"record","fields":[{"name":"id","type":["null","long"]},{"name":"name","type":["null","string"]}]}`,
+		},
+		{
+			name: "int mapper",
+			opts: AvroOptions{TypeMapper: intMapper{}},
+			want: `{"name":"com.github.britt.avrosqlite.foo","type":"record","fields":[{"name":"id","type":["null","int"]},{"name":"name","type":["null","string"]}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := schema.ToAvroWithOptions(tt.opts)
+			if err != nil {
+				t.Fatalf("SqliteSchema.ToAvroWithOptions() error = %v", err)
+			}
+			if want := avro.MustParse(tt.want); got.Fingerprint() != want.Fingerprint() {
+				t.Errorf("SqliteSchema.ToAvroWithOptions() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSqliteSchema_ToAvroWithOptions_NullDefaults(t *testing.T) {
+	schema := &SqliteSchema{
+		Table: "foo",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger, Default: int64(0)},
+			{Name: "name", Type: SqliteText, Nullable: true},
+		},
+	}
+	tests := []struct {
+		name string
+		opts AvroOptions
+		want string
+	}{
+		{
+			name: "no null defaults",
+			opts: AvroOptions{},
+			want: `{"name":"name","type":["null","string"]}`,
+		},
+		{
+			name: "null defaults",
+			opts: AvroOptions{NullDefaults: true},
+			want: `{"name":"name","type":["null","string"],"default":null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := schema.ToAvroWithOptions(tt.opts)
+			if err != nil {
+				t.Fatalf("SqliteSchema.ToAvroWithOptions() error = %v", err)
+			}
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			if !strings.Contains(string(b), tt.want) {
+				t.Errorf("SqliteSchema.ToAvroWithOptions() = %s, want it to contain %s", b, tt.want)
+			}
+			if !strings.Contains(string(b), `{"name":"id","type":"long","default":0}`) {
+				t.Errorf("SqliteSchema.ToAvroWithOptions() = %s, want the default of id unchanged", b)
+			}
+		})
+	}
+}
+
+func TestSqliteSchema_AvroFieldNames(t *testing.T) {
+	schema := &SqliteSchema{Table: "people", Fields: []SchemaField{
+		{Name: "id", Type: SqliteInteger},
+		{Name: "first name", Type: SqliteText, Nullable: true},
+		{Name: "2fa", Type: SqliteInteger, Nullable: true},
+	}}
+	tests := []struct {
+		name string
+		opts AvroOptions
+		want map[string]string
+	}{
+		{
+			name: "sanitized",
+			opts: AvroOptions{SanitizeNames: true},
+			want: map[string]string{"first name": "first_name", "2fa": "_2fa"},
+		},
+		{
+			name: "renamed",
+			opts: AvroOptions{SanitizeNames: true, Renames: map[string]string{"id": "person_id", "2fa": "two_factor"}},
+			want: map[string]string{"id": "person_id", "first name": "first_name", "2fa": "two_factor"},
+		},
+	}
+
+	// the conversions run concurrently, they must not write to the schema
+	var wg sync.WaitGroup
+	for _, tt := range tests {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(opts AvroOptions) {
+				defer wg.Done()
+				if _, err := schema.ToAvroWithOptions(opts); err != nil {
+					t.Errorf("SqliteSchema.ToAvroWithOptions() error = %v", err)
+				}
+			}(tt.opts)
+		}
+	}
+	wg.Wait()
+	if schema.FieldAliases != nil {
+		t.Errorf("SqliteSchema.FieldAliases = %v, want nil", schema.FieldAliases)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := schema.AvroFieldNames(tt.opts)
+			if err != nil {
+				t.Fatalf("SqliteSchema.AvroFieldNames() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SqliteSchema.AvroFieldNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSqliteSchema_ToAvroJSON_Props(t *testing.T) {
+	schema := &SqliteSchema{
+		Table: "people",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger},
+			{Name: "email", Type: SqliteText, Nullable: true, Props: map[string]any{"pii": true}},
+		},
+	}
+
+	avroSchema, err := schema.ToAvro()
+	if err != nil {
+		t.Fatalf("SqliteSchema.ToAvro() error = %v", err)
+	}
+	if got := avroSchema.(*avro.RecordSchema).Fields()[1].Prop("pii"); got != true {
+		t.Errorf("SqliteSchema.ToAvro() pii prop = %v, want %v", got, true)
+	}
+
+	b, err := schema.ToAvroJSON(AvroOptions{})
+	if err != nil {
+		t.Fatalf("SqliteSchema.ToAvroJSON() error = %v", err)
+	}
+	if want := `{"name":"email","pii":true,"type":["null","string"]}`; !strings.Contains(string(b), want) {
+		t.Errorf("SqliteSchema.ToAvroJSON() = %s, want it to contain %s", b, want)
+	}
+
+	got, err := AvroJSONToSqliteSchema(b)
+	if err != nil {
+		t.Fatalf("AvroJSONToSqliteSchema() error = %v", err)
+	}
+	if got.Fields[0].Props != nil {
+		t.Errorf("AvroJSONToSqliteSchema() id props = %v, want nil", got.Fields[0].Props)
+	}
+	if want := map[string]any{"pii": true}; !reflect.DeepEqual(got.Fields[1].Props, want) {
+		t.Errorf("AvroJSONToSqliteSchema() email props = %v, want %v", got.Fields[1].Props, want)
+	}
+
+	schema.Fields[0].Props = map[string]any{"type": "string"}
+	if _, err := schema.ToAvro(); err == nil {
+		t.Error("SqliteSchema.ToAvro() with a reserved property error = nil")
+	}
+}
+
+func TestSqliteSchema_ToAvroJSON_Aliases(t *testing.T) {
+	schema := &SqliteSchema{
+		Table: "items",
+		Fields: []SchemaField{
+			{Name: "id", Type: SqliteInteger},
+			{Name: "name", Type: SqliteText, Nullable: true, Aliases: []string{"title", "label"}},
+		},
+	}
+
+	b, err := schema.ToAvroJSON(AvroOptions{})
+	if err != nil {
+		t.Fatalf("SqliteSchema.ToAvroJSON() error = %v", err)
+	}
+	if want := `{"aliases":["title","label"],"name":"name","type":["null","string"]}`; !strings.Contains(string(b), want) {
+		t.Errorf("SqliteSchema.ToAvroJSON() = %s, want it to contain %s", b, want)
+	}
+
+	got, err := AvroJSONToSqliteSchema(b)
+	if err != nil {
+		t.Fatalf("AvroJSONToSqliteSchema() error = %v", err)
+	}
+	if got.Fields[0].Aliases != nil {
+		t.Errorf("AvroJSONToSqliteSchema() id aliases = %v, want nil", got.Fields[0].Aliases)
+	}
+	if want := []string{"title", "label"}; !reflect.DeepEqual(got.Fields[1].Aliases, want) {
+		t.Errorf("AvroJSONToSqliteSchema() name aliases = %v, want %v", got.Fields[1].Aliases, want)
+	}
+	if got.Fields[1].Props != nil {
+		t.Errorf("AvroJSONToSqliteSchema() name props = %v, want nil", got.Fields[1].Props)
+	}
+
+	schema.Fields[1].Aliases = []string{"old name"}
+	if _, err := schema.ToAvro(); err == nil {
+		t.Error("SqliteSchema.ToAvro() with an invalid alias error = nil")
+	}
+}
+
+func TestSqliteSchema_ToAvroWithOptions_Names(t *testing.T) {
+	tests := []struct {
+		name     string
+		columns  []string
+		sanitize bool
+		want     []string
+		wantDocs []string
+		wantErr  string
+	}{
+		{
+			name:     "valid names",
+			columns:  []string{"id", "_first_name"},
+			want:     []string{"id", "_first_name"},
+			wantDocs: []string{"", ""},
+		},
+		{
+			name:    "invalid name",
+			columns: []string{"id", "first name"},
+			wantErr: `column "first name" is not a valid avro field name`,
+		},
+		{
+			name:     "sanitized names",
+			columns:  []string{"id", "first name", "2fa"},
+			sanitize: true,
+			want:     []string{"id", "first_name", "_2fa"},
+			wantDocs: []string{"", "first name", "2fa"},
+		},
+		{
+			name:     "sanitized collision",
+			columns:  []string{"first_name", "first name"},
+			sanitize: true,
+			wantErr:  `columns "first_name" and "first name" both map to avro field "first_name"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := &SqliteSchema{Table: "people"}
+			for _, c := range tt.columns {
+				schema.Fields = append(schema.Fields, SchemaField{Name: c, Type: SqliteText, Nullable: true})
+			}
+
+			got, err := schema.ToAvroWithOptions(AvroOptions{SanitizeNames: tt.sanitize})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("SqliteSchema.ToAvroWithOptions() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SqliteSchema.ToAvroWithOptions() error = %v", err)
+			}
+
+			for i, f := range got.(*avro.RecordSchema).Fields() {
+				if f.Name() != tt.want[i] {
+					t.Errorf("Field.Name() = %v, want %v", f.Name(), tt.want[i])
+				}
+				if f.Doc() != tt.wantDocs[i] {
+					t.Errorf("Field.Doc() = %v, want %v", f.Doc(), tt.wantDocs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSqliteSchema_Fingerprint(t *testing.T) {
+	newSchema := func() *SqliteSchema {
+		return &SqliteSchema{
+			Table: "foo",
+			Fields: []SchemaField{
+				{Name: "id", Type: SqliteInteger, Nullable: true},
+				{Name: "name", Type: SqliteText, Nullable: true},
+			},
+		}
+	}
+	renamedTable := newSchema()
+	renamedTable.Table = "bar"
+	retypedField := newSchema()
+	retypedField.Fields[1].Type = SqliteBlob
+	extraField := newSchema()
+	extraField.Fields = append(extraField.Fields, SchemaField{Name: "age", Type: SqliteInteger, Nullable: true})
+
+	tests := []struct {
+		name   string
+		schema *SqliteSchema
+		same   bool
+	}{
+		{name: "identical", schema: newSchema(), same: true},
+		{name: "renamed table", schema: renamedTable, same: false},
+		{name: "retyped field", schema: retypedField, same: false},
+		{name: "extra field", schema: extraField, same: false},
+	}
+
+	want, err := newSchema().Fingerprint()
+	if err != nil {
+		t.Fatalf("SqliteSchema.Fingerprint() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.schema.Fingerprint()
+			if err != nil {
+				t.Fatalf("SqliteSchema.Fingerprint() error = %v", err)
+			}
+			if (got == want) != tt.same {
+				t.Errorf("SqliteSchema.Fingerprint() = %x, want same %v as %x", got, tt.same, want)
+			}
+		})
+	}
+}
+
+func TestSqliteSchema_SubjectName(t *testing.T) {
+	s := &SqliteSchema{Table: "foo", Fields: []SchemaField{{Name: "id", Type: SqliteInteger}}}
+	want := "com.github.britt.avrosqlite.foo"
+	if got := s.SubjectName(); got != want {
+		t.Errorf("SqliteSchema.SubjectName() = %v, want %v", got, want)
+	}
+
+	avroSchema, err := s.ToAvro()
+	if err != nil {
+		t.Fatalf("SqliteSchema.ToAvro() error = %v", err)
+	}
+	if got := avroSchema.(*avro.RecordSchema).FullName(); got != want {
+		t.Errorf("RecordSchema.FullName() = %v, want %v", got, want)
+	}
+}
+
 func TestListTables(t *testing.T) {
 	got, err := ListTables(testDB)
 	if err != nil {
@@ -265,6 +696,77 @@ func TestListTables(t *testing.T) {
 	}
 }
 
+func TestTempTables(t *testing.T) {
+	db := newTestDB(t)
+	// temporary tables are only visible to the connection that created them
+	db.SetMaxOpenConns(1)
+	_, err := db.Exec(`CREATE TABLE kept (id INTEGER);
+		CREATE TEMP TABLE staging (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO staging VALUES (1, 'bolt'), (2, 'nut');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	tables, err := ListTables(db)
+	if err != nil {
+		t.Fatalf("ListTables() error = %v", err)
+	}
+	if want := []string{"kept", "temp.staging"}; !reflect.DeepEqual(tables, want) {
+		t.Errorf("ListTables() = %v, want %v", tables, want)
+	}
+
+	schema, err := ReadSchema(db, "temp.staging")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	if len(schema.Fields) != 2 || schema.recordName() != "staging" {
+		t.Errorf("ReadSchema() = %+v, want 2 fields and record staging", schema)
+	}
+	want := []map[string]any{{"id": int64(1), "name": "bolt"}, {"id": int64(2), "name": "nut"}}
+	got, err := LoadData(db, "temp.staging")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadData() = %v, want %v", got, want)
+	}
+
+	dir := t.TempDir()
+	if _, err := SqliteToAvro(db, dir, "", false, nil); err != nil {
+		t.Fatalf("SqliteToAvro() error = %v", err)
+	}
+	f, err := os.Open(filepath.Join(dir, "temp.staging.avro"))
+	if err != nil {
+		t.Fatalf("os.Open() error = %v", err)
+	}
+	defer f.Close()
+
+	// the table is restored as a regular table named after its record
+	fresh := newTestDB(t)
+	if _, err := OCFToTable(fresh, f, ""); err != nil {
+		t.Fatalf("OCFToTable() error = %v", err)
+	}
+	got, err = LoadData(fresh, "staging")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadData() = %v, want %v", got, want)
+	}
+}
+
+func TestTableRowCounts(t *testing.T) {
+	got, err := TableRowCounts(testDB)
+	if err != nil {
+		t.Fatalf("TableRowCounts() error = %v", err)
+	}
+
+	want := map[string]int64{"foo": 3, "meats": 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TableRowCounts() = %v, want %v", got, want)
+	}
+}
+
 func TestReadSchema(t *testing.T) {
 	type args struct {
 		db        *sql.DB
@@ -334,6 +836,89 @@ func TestReadSchema(t *testing.T) {
 	}
 }
 
+func TestReadSchema_Booleans(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec("CREATE TABLE flags (a BOOL DEFAULT 1, b Boolean DEFAULT 0, c bool NOT NULL DEFAULT TRUE, d BOOL, e BOOL NOT NULL DEFAULT 1)")
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	schema, err := ReadSchema(db, "flags")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		wantDefault any
+		wantAvro    any
+	}{
+		{name: "a", wantDefault: true, wantAvro: avro.NoDefault},
+		{name: "b", wantDefault: false, wantAvro: avro.NoDefault},
+		{name: "c", wantDefault: true, wantAvro: true},
+		{name: "d", wantDefault: avro.NoDefault, wantAvro: avro.NoDefault},
+		{name: "e", wantDefault: true, wantAvro: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field := schema.Fields[i]
+			if field.Type != SqliteBoolean {
+				t.Errorf("ReadSchema() Type = %v, want %v", field.Type, SqliteBoolean)
+			}
+			if field.Default != tt.wantDefault {
+				t.Errorf("ReadSchema() Default = %v, want %v", field.Default, tt.wantDefault)
+			}
+			if got := field.AvroDefault(); got != tt.wantAvro {
+				t.Errorf("SchemaField.AvroDefault() = %v, want %v", got, tt.wantAvro)
+			}
+		})
+	}
+
+	if _, err := schema.ToAvro(); err != nil {
+		t.Errorf("SqliteSchema.ToAvro() error = %v", err)
+	}
+}
+
+func TestReadSchema_RealDefaults(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec("CREATE TABLE prices (a REAL NOT NULL DEFAULT 1, b REAL NOT NULL DEFAULT 1.5, c REAL NOT NULL DEFAULT '2', d REAL NOT NULL DEFAULT -3)")
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	schema, err := ReadSchema(db, "prices")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		wantAvro any
+	}{
+		{name: "a", wantAvro: 1.0},
+		{name: "b", wantAvro: 1.5},
+		{name: "c", wantAvro: 2.0},
+		{name: "d", wantAvro: -3.0},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := schema.Fields[i].AvroDefault(); got != tt.wantAvro {
+				t.Errorf("SchemaField.AvroDefault() = %v (%T), want %v", got, got, tt.wantAvro)
+			}
+		})
+	}
+
+	// integer defaults of schemas built by hand are converted too
+	field := SchemaField{Name: "e", Type: SqliteReal, Default: int64(1)}
+	if got := field.AvroDefault(); got != 1.0 {
+		t.Errorf("SchemaField.AvroDefault() = %v (%T), want %v", got, got, 1.0)
+	}
+
+	if _, err := schema.ToAvro(); err != nil {
+		t.Errorf("SqliteSchema.ToAvro() error = %v", err)
+	}
+}
+
 func Test_LoadData(t *testing.T) {
 	type args struct {
 		db    *sql.DB
@@ -377,3 +962,447 @@ func Test_LoadData(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadDataWithOptions_NormalizeIntegers(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE counts (n INTEGER, label TEXT);
+		INSERT INTO counts VALUES (1, '1'), (9223372036854775807, 'max'), (-5, 'min'), (x'3132', 'blob'), ('n/a', 'text'), (NULL, NULL);`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	rows, err := LoadDataWithOptions(db, "counts", DataOptions{NormalizeIntegers: true})
+	if err != nil {
+		t.Fatalf("LoadDataWithOptions() error = %v", err)
+	}
+	got := []any{}
+	for _, row := range rows {
+		got = append(got, row["n"])
+	}
+	want := []any{int64(1), int64(9223372036854775807), int64(-5), int64(12), "n/a", nil}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadDataWithOptions() n = %#v, want %#v", got, want)
+	}
+	// only INTEGER columns are normalized
+	if rows[0]["label"] != "1" {
+		t.Errorf("LoadDataWithOptions() label = %#v, want %#v", rows[0]["label"], "1")
+	}
+}
+
+func Test_toInt64(t *testing.T) {
+	tests := []struct {
+		v    any
+		want any
+	}{
+		{v: 1, want: int64(1)},
+		{v: int32(-2), want: int64(-2)},
+		{v: uint16(3), want: int64(3)},
+		{v: uint64(1 << 63), want: uint64(1 << 63)},
+		{v: " 42 ", want: int64(42)},
+		{v: "4.2", want: "4.2"},
+		{v: 4.5, want: 4.5},
+	}
+	for _, tt := range tests {
+		if got := toInt64(tt.v); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("toInt64(%#v) = %#v, want %#v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestLoadData_AlteredTable(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`CREATE TABLE witches (name TEXT, coven TEXT);
+		INSERT INTO witches VALUES ('Eda', NULL);
+		ALTER TABLE witches ADD COLUMN palisman TEXT DEFAULT 'Owlbert';
+		INSERT INTO witches VALUES ('Lilith', 'Emperor', 'Raven');`)
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+
+	got, err := LoadData(db, "witches")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	want := []map[string]any{
+		{"name": "Eda", "coven": nil, "palisman": "Owlbert"},
+		{"name": "Lilith", "coven": "Emperor", "palisman": "Raven"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadData() = %v, want %v", got, want)
+	}
+
+	data, err := TableToOCFBytes(db, "witches", nil)
+	if err != nil {
+		t.Fatalf("TableToOCFBytes() error = %v", err)
+	}
+	copied := newTestDB(t)
+	if _, err := OCFBytesToTable(copied, data, "witches"); err != nil {
+		t.Fatalf("OCFBytesToTable() error = %v", err)
+	}
+	got, err = LoadData(copied, "witches")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadData() after round trip = %v, want %v", got, want)
+	}
+}
+
+func TestSqliteSchema_ToAvro_UnsupportedType(t *testing.T) {
+	// ReadSchema maps every declared type to a supported one, see NormalizeSqliteType
+	schema := &SqliteSchema{Table: "exotic", Fields: []SchemaField{
+		{Name: "id", Type: SqliteInteger, Nullable: true},
+		{Name: "shape", Type: "geometry", Nullable: true},
+	}}
+
+	_, err := schema.ToAvro()
+	if !errors.Is(err, ErrUnsupportedType) {
+		t.Fatalf("SqliteSchema.ToAvro() error = %v, want %v", err, ErrUnsupportedType)
+	}
+	for _, want := range []string{`"shape"`, `"geometry"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("SqliteSchema.ToAvro() error = %v, want it to contain %s", err, want)
+		}
+	}
+}
+
+func TestEmptySchema(t *testing.T) {
+	schema := &SqliteSchema{Table: "empty", Sql: "CREATE TABLE empty (id INTEGER)", Fields: []SchemaField{}}
+
+	if _, err := schema.ToAvro(); !errors.Is(err, ErrEmptySchema) {
+		t.Errorf("SqliteSchema.ToAvro() error = %v, want %v", err, ErrEmptySchema)
+	}
+	if _, err := LoadAvro(newTestDB(t), schema, strings.NewReader("")); !errors.Is(err, ErrEmptySchema) {
+		t.Errorf("LoadAvro() error = %v, want %v", err, ErrEmptySchema)
+	}
+	if _, err := loadRecords(newTestDB(t), schema, nil, LoadOptions{}); !errors.Is(err, ErrEmptySchema) {
+		t.Errorf("loadRecords() error = %v, want %v", err, ErrEmptySchema)
+	}
+}
+
+func TestReadSchema_IntegerDefault(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec("CREATE TABLE counts (id INTEGER NOT NULL, n INTEGER NOT NULL DEFAULT 5, bad INTEGER NOT NULL DEFAULT 'x')")
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	schema, err := ReadSchema(db, "counts")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+
+	// Avro longs only accept int64 defaults, so a NOT NULL integer column
+	// without a usable default gets an int64 zero
+	avroSchema, err := schema.ToAvro()
+	if err != nil {
+		t.Fatalf("SqliteSchema.ToAvro() error = %v", err)
+	}
+	for i, want := range []any{int64(0), int64(5), int64(0)} {
+		if got := avroSchema.(*avro.RecordSchema).Fields()[i].Default(); got != want {
+			t.Errorf("SqliteSchema.ToAvro() default of %s = %#v, want %#v", schema.Fields[i].Name, got, want)
+		}
+	}
+
+	if _, err := db.Exec("INSERT INTO counts (id, bad) VALUES (1, 2)"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	if _, err := TableToOCFBytes(db, "counts", nil); err != nil {
+		t.Errorf("TableToOCFBytes() error = %v", err)
+	}
+}
+
+func TestReadSchema_BlobDefault(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec("CREATE TABLE blobs (hex BLOB NOT NULL DEFAULT X'00FF', empty BLOB DEFAULT x'', raw BLOB DEFAULT 'raw')")
+	if err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	schema, err := ReadSchema(db, "blobs")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want []byte
+	}{
+		{name: "hex", want: []byte{0x00, 0xff}},
+		{name: "empty", want: []byte{}},
+		{name: "raw", want: []byte("'raw'")},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := schema.Fields[i].Default; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadSchema() Default = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got, want := schema.Fields[0].AvroDefault(), "\x00\xff"; !reflect.DeepEqual(got, want) {
+		t.Errorf("SchemaField.AvroDefault() = %q, want %q", got, want)
+	}
+	avroSchema, err := schema.ToAvro()
+	if err != nil {
+		t.Fatalf("SqliteSchema.ToAvro() error = %v", err)
+	}
+	converted, err := AvroToSqliteSchema(avroSchema)
+	if err != nil {
+		t.Fatalf("AvroToSqliteSchema() error = %v", err)
+	}
+	if got, want := converted.Fields[0].Default, []byte{0x00, 0xff}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AvroToSqliteSchema() Default = %v, want %v", got, want)
+	}
+	if _, err := db.Exec("INSERT INTO blobs (empty, raw) VALUES (x'01', x'02')"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	data, err := TableToOCFBytes(db, "blobs", nil)
+	if err != nil {
+		t.Fatalf("TableToOCFBytes() error = %v", err)
+	}
+	if got, want := readOCFValues(t, data, "hex"), []any{[]byte{0x00, 0xff}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TableToOCFBytes() hex = %v, want %v", got, want)
+	}
+}
+
+func TestReadSchema_TableNotFound(t *testing.T) {
+	_, err := ReadSchema(testDB, "missing")
+	if !errors.Is(err, ErrTableNotFound) {
+		t.Fatalf("ReadSchema() error = %v, want %v", err, ErrTableNotFound)
+	}
+	if !strings.Contains(err.Error(), `"missing"`) {
+		t.Errorf("ReadSchema() error = %v, want it to name the table", err)
+	}
+}
+
+func TestSqliteSchema_ToAvro_RecordName(t *testing.T) {
+	schema := &SqliteSchema{
+		Table:      "tbl-2024",
+		RecordName: "Order",
+		Fields:     []SchemaField{{Name: "id", Type: SqliteInteger, Nullable: true}},
+	}
+	avroSchema, err := schema.ToAvro()
+	if err != nil {
+		t.Fatalf("SqliteSchema.ToAvro() error = %v", err)
+	}
+	if got, want := avroSchema.(*avro.RecordSchema).FullName(), AvroNamespace+".Order"; got != want {
+		t.Errorf("SqliteSchema.ToAvro() record = %v, want %v", got, want)
+	}
+	if got, want := schema.SubjectName(), AvroNamespace+".Order"; got != want {
+		t.Errorf("SqliteSchema.SubjectName() = %v, want %v", got, want)
+	}
+
+	schema.RecordName = ""
+	if _, err := schema.ToAvro(); err == nil {
+		t.Error("SqliteSchema.ToAvro() with an invalid table name as record name error = nil")
+	}
+}
+
+func TestStreamData(t *testing.T) {
+	got := []map[string]any{}
+	err := StreamData(testDB, "foo", func(row map[string]any) error {
+		// the row is reused, so it is copied to be retained
+		copied := map[string]any{}
+		for k, v := range row {
+			copied[k] = v
+		}
+		got = append(got, copied)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamData() error = %v", err)
+	}
+	want, err := LoadData(testDB, "foo")
+	if err != nil {
+		t.Fatalf("LoadData() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("StreamData() = %v, want %v", got, want)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = StreamData(testDB, "foo", func(map[string]any) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("StreamData() error = %v after %d calls, want %v after 1 call", err, calls, stop)
+	}
+}
+
+// newWideTestDB opens a database holding a table named wide with 20 columns and rows rows.
+func newWideTestDB(b *testing.B, rows int) *sql.DB {
+	b.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatalf("sql.Open() error = %v", err)
+	}
+	b.Cleanup(func() { db.Close() })
+
+	columns := []string{}
+	values := []string{}
+	for i := 0; i < 20; i++ {
+		switch i % 4 {
+		case 0:
+			columns = append(columns, fmt.Sprintf("c%d INTEGER", i))
+			values = append(values, "value")
+		case 1:
+			columns = append(columns, fmt.Sprintf("c%d REAL", i))
+			values = append(values, "value * 1.5")
+		case 2:
+			columns = append(columns, fmt.Sprintf("c%d TEXT", i))
+			values = append(values, "'text ' || value")
+		case 3:
+			columns = append(columns, fmt.Sprintf("c%d BLOB", i))
+			values = append(values, "randomblob(16)")
+		}
+	}
+	_, err = db.Exec(fmt.Sprintf(`CREATE TABLE wide (%s);
+		WITH RECURSIVE seq(value) AS (SELECT 1 UNION ALL SELECT value + 1 FROM seq WHERE value < %d)
+		INSERT INTO wide SELECT %s FROM seq;`,
+		strings.Join(columns, ", "), rows, strings.Join(values, ", ")))
+	if err != nil {
+		b.Fatalf("db.Exec() error = %v", err)
+	}
+	return db
+}
+
+func BenchmarkLoadData(b *testing.B) {
+	db := newWideTestDB(b, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := LoadData(db, "wide"); err != nil {
+			b.Fatalf("LoadData() error = %v", err)
+		}
+	}
+}
+
+func BenchmarkStreamData(b *testing.B) {
+	db := newWideTestDB(b, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := StreamData(db, "wide", func(map[string]any) error { return nil })
+		if err != nil {
+			b.Fatalf("StreamData() error = %v", err)
+		}
+	}
+}
+
+func TestSampleData(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		want  int
+	}{
+		{name: "fewer than the table", limit: 2, want: 2},
+		{name: "more than the table", limit: 10, want: 3},
+		{name: "none", limit: 0, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SampleData(testDB, "meats", tt.limit)
+			if err != nil {
+				t.Fatalf("SampleData() error = %v", err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("SampleData() returned %d rows, want %d", len(got), tt.want)
+			}
+			for _, row := range got {
+				if _, ok := row["name"]; !ok {
+					t.Errorf("SampleData() row = %v, want a name column", row)
+				}
+			}
+		})
+	}
+
+	if _, err := SampleData(testDB, "meats", -1); err == nil {
+		t.Error("SampleData() with a negative limit error = nil")
+	}
+}
+
+func TestNormalizeSqliteType(t *testing.T) {
+	// the examples of https://www.sqlite.org/datatype3.html#affinity_name_examples
+	tests := []struct {
+		declared string
+		want     SqliteType
+	}{
+		{declared: "INT", want: SqliteInteger},
+		{declared: "INTEGER", want: SqliteInteger},
+		{declared: "TINYINT", want: SqliteInteger},
+		{declared: "SMALLINT", want: SqliteInteger},
+		{declared: "MEDIUMINT", want: SqliteInteger},
+		{declared: "BIGINT", want: SqliteInteger},
+		{declared: "UNSIGNED BIG INT", want: SqliteInteger},
+		{declared: "INT2", want: SqliteInteger},
+		{declared: "INT8", want: SqliteInteger},
+		{declared: "CHARACTER(20)", want: SqliteText},
+		{declared: "VARCHAR(255)", want: SqliteText},
+		{declared: "VARYING CHARACTER(255)", want: SqliteText},
+		{declared: "NCHAR(55)", want: SqliteText},
+		{declared: "NATIVE CHARACTER(70)", want: SqliteText},
+		{declared: "NVARCHAR(100)", want: SqliteText},
+		{declared: "TEXT", want: SqliteText},
+		{declared: "CLOB", want: SqliteText},
+		{declared: "BLOB", want: SqliteBlob},
+		{declared: "", want: SqliteAny},
+		{declared: "REAL", want: SqliteReal},
+		{declared: "DOUBLE", want: SqliteReal},
+		{declared: "DOUBLE PRECISION", want: SqliteReal},
+		{declared: "FLOAT", want: SqliteReal},
+		{declared: "NUMERIC", want: SqliteAny},
+		{declared: "DECIMAL(10,5)", want: SqliteAny},
+		// precedence of the rules
+		{declared: "FLOATING POINT", want: SqliteInteger},
+		{declared: "STRING", want: SqliteAny},
+		// types with a meaning of their own
+		{declared: "BOOLEAN", want: SqliteBoolean},
+		{declared: "Bool", want: SqliteBoolean},
+		{declared: "DATE", want: SqliteDate},
+		{declared: "DATETIME", want: SqliteDatetime},
+		{declared: " timestamp ", want: SqliteTimestamp},
+		{declared: "ANY", want: SqliteAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.declared, func(t *testing.T) {
+			if got := NormalizeSqliteType(tt.declared); got != tt.want {
+				t.Errorf("NormalizeSqliteType(%q) = %v, want %v", tt.declared, got, tt.want)
+			}
+		})
+	}
+
+	db := newTestDB(t)
+	if _, err := db.Exec("CREATE TABLE declared (a VARCHAR(10), b BIGINT, c DECIMAL(10,5), d)"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	schema, err := ReadSchema(db, "declared")
+	if err != nil {
+		t.Fatalf("ReadSchema() error = %v", err)
+	}
+	got := []SqliteType{}
+	for _, f := range schema.Fields {
+		got = append(got, f.Type)
+	}
+	if want := []SqliteType{SqliteText, SqliteInteger, SqliteAny, SqliteAny}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadSchema() types = %v, want %v", got, want)
+	}
+}
+
+func TestTableToOCFBytes_CreateTableAsSelect(t *testing.T) {
+	db := newTestDB(t)
+	// the expression columns of CREATE TABLE ... AS SELECT are untyped
+	if _, err := db.Exec("CREATE TABLE c AS SELECT 1+1 AS two, 'x' || 'y' AS xy, x'00ff' AS bytes"); err != nil {
+		t.Fatalf("db.Exec() error = %v", err)
+	}
+	data, err := TableToOCFBytes(db, "c", nil)
+	if err != nil {
+		t.Fatalf("TableToOCFBytes() error = %v", err)
+	}
+	for field, want := range map[string]any{"two": int64(2), "xy": "xy", "bytes": []byte{0x00, 0xff}} {
+		got := readOCFValues(t, data, field)
+		if len(got) != 1 || !reflect.DeepEqual(unionValue(got[0]), want) {
+			t.Errorf("TableToOCFBytes() %s = %v, want %v", field, got, want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package testutil_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	avrosqlite "github.com/britt/avro-sqlite"
+	"github.com/britt/avro-sqlite/testutil"
+	"github.com/hamba/avro"
+)
+
+// This example checks that loading into an existing table deletes its rows first.
+func ExampleQuerier() {
+	schema := &avrosqlite.SqliteSchema{
+		Table:  "people",
+		Sql:    "CREATE TABLE people (name TEXT)",
+		Fields: []avrosqlite.SchemaField{{Name: "name", Type: avrosqlite.SqliteText, Nullable: true}},
+	}
+	avroSchema, _ := schema.ToAvro()
+	data, _ := avro.Marshal(avroSchema, map[string]any{"name": "Ann"})
+
+	q := testutil.NewQuerier()
+	defer q.Close()
+	// the table exists
+	q.AddRows("FROM sqlite_master", testutil.Rows{Columns: []string{"name"}, Values: [][]any{{"people"}}})
+
+	if _, err := avrosqlite.LoadAvroWithOptions(q, schema, bytes.NewReader(data), avrosqlite.LoadOptions{}); err != nil {
+		fmt.Println(err)
+	}
+	for _, statement := range q.Statements() {
+		fmt.Println(strings.Fields(statement.Query)[0], statement.Args)
+	}
+	// Output:
+	// SELECT [people]
+	// DELETE []
+	// INSERT [Ann]
+}
@@ -0,0 +1,211 @@
+// Package testutil provides a fake avrosqlite.Querier for testing code that
+// uses avrosqlite without a SQLite database.
+package testutil
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	avrosqlite "github.com/britt/avro-sqlite"
+)
+
+// Statement is a statement run through a Querier.
+type Statement struct {
+	Query string
+	Args  []any
+}
+
+// Rows are the canned rows returned by the queries of a Querier.
+type Rows struct {
+	Columns []string
+	Values  [][]any
+}
+
+// Querier is a fake avrosqlite.Querier. It records every statement run through
+// it and answers queries with the canned rows added with AddRows. Statements
+// are not executed: Exec always succeeds and affects no rows, and queries without
+// canned rows return no rows. It is safe for concurrent use.
+type Querier struct {
+	db *sql.DB
+
+	mu         sync.Mutex
+	statements []Statement
+	rows       []cannedRows
+}
+
+var _ avrosqlite.Querier = (*Querier)(nil)
+
+// cannedRows are rows returned by the queries containing match.
+type cannedRows struct {
+	match string
+	rows  Rows
+}
+
+// NewQuerier returns an empty Querier. It must be closed with Close.
+func NewQuerier() *Querier {
+	q := &Querier{}
+	registry.Lock()
+	registry.nextID++
+	id := strconv.Itoa(registry.nextID)
+	registry.queriers[id] = q
+	registry.Unlock()
+
+	// the connector is only used by Query, to build *sql.Rows from canned rows
+	q.db = sql.OpenDB(&connector{id: id})
+	return q
+}
+
+// AddRows makes the queries containing match return rows. When several canned
+// rows match a query the first added is returned.
+func (q *Querier) AddRows(match string, rows Rows) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rows = append(q.rows, cannedRows{match: match, rows: rows})
+}
+
+// Statements returns the statements run so far, in order.
+func (q *Querier) Statements() []Statement {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]Statement{}, q.statements...)
+}
+
+// Exec records the statement and returns a result affecting no rows.
+func (q *Querier) Exec(query string, args ...any) (sql.Result, error) {
+	q.record(query, args)
+	return driver.RowsAffected(0), nil
+}
+
+// Query records the query and returns the canned rows matching it.
+func (q *Querier) Query(query string, args ...any) (*sql.Rows, error) {
+	q.record(query, args)
+	return q.db.Query(query, args...)
+}
+
+// Close releases the resources of the Querier.
+func (q *Querier) Close() error {
+	registry.Lock()
+	for id, other := range registry.queriers {
+		if other == q {
+			delete(registry.queriers, id)
+		}
+	}
+	registry.Unlock()
+	return q.db.Close()
+}
+
+func (q *Querier) record(query string, args []any) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.statements = append(q.statements, Statement{Query: query, Args: args})
+}
+
+// match returns the canned rows of a query.
+func (q *Querier) match(query string) Rows {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, canned := range q.rows {
+		if strings.Contains(query, canned.match) {
+			return canned.rows
+		}
+	}
+	return Rows{}
+}
+
+// registry maps the ids of the connectors to their Querier.
+var registry = struct {
+	sync.Mutex
+	nextID   int
+	queriers map[string]*Querier
+}{queriers: map[string]*Querier{}}
+
+// connector, conn, stmt and rows implement a database/sql driver returning canned rows.
+type connector struct {
+	id string
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{id: c.id}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return &conn{id: name}, nil
+}
+
+type conn struct {
+	id string
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	registry.Lock()
+	q, ok := registry.queriers[c.id]
+	registry.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("testutil: querier %s is closed", c.id)
+	}
+	return &stmt{rows: q.match(query)}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("testutil: transactions are not supported")
+}
+
+type stmt struct {
+	rows Rows
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (s *stmt) Query([]driver.Value) (driver.Rows, error) {
+	return &rows{rows: s.rows}, nil
+}
+
+type rows struct {
+	rows Rows
+	next int
+}
+
+func (r *rows) Columns() []string {
+	return r.rows.Columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows.Values) {
+		return io.EOF
+	}
+	for i, v := range r.rows.Values[r.next] {
+		dest[i] = v
+	}
+	r.next++
+	return nil
+}
@@ -0,0 +1,62 @@
+package testutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQuerier(t *testing.T) {
+	q := NewQuerier()
+	defer q.Close()
+	q.AddRows("FROM people", Rows{Columns: []string{"id", "name"}, Values: [][]any{{int64(1), "Ann"}, {int64(2), "Bob"}}})
+
+	if _, err := q.Exec("DELETE FROM people WHERE id = ?", 3); err != nil {
+		t.Fatalf("Querier.Exec() error = %v", err)
+	}
+
+	rows, err := q.Query("SELECT id, name FROM people")
+	if err != nil {
+		t.Fatalf("Querier.Query() error = %v", err)
+	}
+	got := [][]any{}
+	for rows.Next() {
+		var (
+			id   int64
+			name string
+		)
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatalf("Rows.Scan() error = %v", err)
+		}
+		got = append(got, []any{id, name})
+	}
+	rows.Close()
+	if want := [][]any{{int64(1), "Ann"}, {int64(2), "Bob"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Querier.Query() rows = %v, want %v", got, want)
+	}
+
+	rows, err = q.Query("SELECT * FROM places")
+	if err != nil {
+		t.Fatalf("Querier.Query() error = %v", err)
+	}
+	if rows.Next() {
+		t.Error("Querier.Query() without canned rows returned a row")
+	}
+	rows.Close()
+
+	want := []Statement{
+		{Query: "DELETE FROM people WHERE id = ?", Args: []any{3}},
+		{Query: "SELECT id, name FROM people"},
+		{Query: "SELECT * FROM places"},
+	}
+	if got := q.Statements(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Querier.Statements() = %v, want %v", got, want)
+	}
+}
+
+func TestQuerier_Closed(t *testing.T) {
+	q := NewQuerier()
+	q.Close()
+	if _, err := q.Query("SELECT 1"); err == nil {
+		t.Error("Querier.Query() after Close error = nil")
+	}
+}
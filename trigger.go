@@ -0,0 +1,107 @@
+package avrosqlite
+
+import (
+	"fmt"
+)
+
+// trigger is a trigger of a table, as recorded in sqlite_master.
+type trigger struct {
+	name string
+	sql  string
+}
+
+// tableTriggers returns the triggers of a table, ordered by name.
+func tableTriggers(q Querier, table string) ([]trigger, error) {
+	schemaName, name := splitTableName(table)
+	query := fmt.Sprintf("SELECT name, sql FROM %s WHERE type = 'trigger' AND tbl_name = ? AND sql IS NOT NULL ORDER BY name", masterTable(schemaName))
+	rows, err := q.Query(query, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the triggers of table %s: [%w]", table, err)
+	}
+	defer rows.Close()
+
+	triggers := []trigger{}
+	for rows.Next() {
+		var t trigger
+		if err := rows.Scan(&t.name, &t.sql); err != nil {
+			return nil, err
+		}
+		triggers = append(triggers, t)
+	}
+	return triggers, rows.Err()
+}
+
+// readTriggers returns the CREATE TRIGGER statements of a table, or nil if it has none.
+func readTriggers(q Querier, table string) ([]string, error) {
+	triggers, err := tableTriggers(q, table)
+	if err != nil {
+		return nil, err
+	}
+	var statements []string
+	for _, t := range triggers {
+		statements = append(statements, t.sql)
+	}
+	return statements, nil
+}
+
+// createTriggers creates the triggers of schema that the table does not have yet.
+// Triggers already defined with the same statement are left as they are.
+func createTriggers(q Querier, schema *SqliteSchema) error {
+	if len(schema.Triggers) == 0 {
+		return nil
+	}
+	existing, err := readTriggers(q, schema.Table)
+	if err != nil {
+		return err
+	}
+	defined := map[string]bool{}
+	for _, statement := range existing {
+		defined[statement] = true
+	}
+	for _, statement := range schema.Triggers {
+		if defined[statement] {
+			continue
+		}
+		if _, err := q.Exec(statement); err != nil {
+			return fmt.Errorf("failed to create trigger of table %s: [%w]", schema.Table, err)
+		}
+	}
+	return nil
+}
+
+// withoutTriggers drops the triggers of a table, runs fn and creates the triggers
+// again, even if fn fails. See LoadOptions.DisableTriggers.
+func withoutTriggers(q Querier, table string, fn func() (LoadAvroResult, error)) (LoadAvroResult, error) {
+	triggers, err := tableTriggers(q, table)
+	if err != nil {
+		return LoadAvroResult{}, err
+	}
+	for _, t := range triggers {
+		if _, err := q.Exec(fmt.Sprintf("DROP TRIGGER %s", quoteTableName(qualifiedName(table, t.name)))); err != nil {
+			return LoadAvroResult{}, fmt.Errorf("failed to drop trigger %s: [%w]", t.name, err)
+		}
+	}
+
+	result, err := fn()
+	// the load may have created some of them again from the triggers of its schema
+	current, readErr := tableTriggers(q, table)
+	if readErr != nil {
+		if err == nil {
+			err = readErr
+		}
+		return result, err
+	}
+	created := map[string]bool{}
+	for _, t := range current {
+		created[t.name] = true
+	}
+	for _, t := range triggers {
+		if created[t.name] {
+			continue
+		}
+		if _, createErr := q.Exec(t.sql); createErr != nil && err == nil {
+			err = fmt.Errorf("failed to restore trigger %s: [%w]", t.name, createErr)
+		}
+	}
+	return result, err
+}
@@ -0,0 +1,38 @@
+package avrosqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrCheckpointBusy is returned by CheckpointWAL when the WAL could not be fully
+// checkpointed and truncated, usually because other connections are reading it.
+var ErrCheckpointBusy = errors.New("WAL checkpoint blocked by other connections")
+
+// CheckpointWAL copies the content of the write-ahead log of a database in WAL mode
+// into the database file and truncates the log, with PRAGMA wal_checkpoint(TRUNCATE).
+//
+// Parameters:
+//   - db: A pointer to the sql.DB representing the SQLite database connection.
+//
+// Returns:
+//   - error: An error wrapping ErrCheckpointBusy if readers or writers kept the log
+//     from being checkpointed entirely, any other error that occurred, nil otherwise.
+//
+// Connections to the database always see the committed content of the log, so an
+// export through database/sql does not need a checkpoint. It is needed when the
+// database file is read by other means, for example copied or opened with
+// immutable=1, which ignore the -wal file. It does nothing for databases that
+// are not in WAL mode.
+func CheckpointWAL(db *sql.DB) error {
+	var busy, logFrames, checkpointed int
+	err := db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logFrames, &checkpointed)
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint the WAL: [%w]", err)
+	}
+	if busy != 0 {
+		return fmt.Errorf("%w: %d of %d frames checkpointed", ErrCheckpointBusy, checkpointed, logFrames)
+	}
+	return nil
+}